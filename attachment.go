@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// playAttachmentCmdName is the message context-menu command; Discord shows the
+// name as is under Apps when a message is right-clicked.
+const playAttachmentCmdName = "Play this attachment"
+
+// attachmentMaxSize is the largest attachment the bot will stream: Discord's
+// upload limit on a fully boosted server.
+const attachmentMaxSize = 100 << 20
+
+func playAttachmentCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name: playAttachmentCmdName,
+		Type: discordgo.MessageApplicationCommand,
+	}
+}
+
+// pickAttachment returns the first attachment on msg the guild's file types
+// allow, or a reason none can be played.
+func pickAttachment(msg *discordgo.Message, exts extSet) (*discordgo.MessageAttachment, string) {
+	if msg == nil || len(msg.Attachments) == 0 {
+		return nil, "That message has no attachments."
+	}
+	tooBig := false
+	for _, att := range msg.Attachments {
+		if _, ok := exts[strings.ToLower(filepath.Ext(att.Filename))]; !ok || isPlaylist(att.Filename) {
+			continue
+		}
+		if att.Size > attachmentMaxSize {
+			tooBig = true
+			continue
+		}
+		return att, ""
+	}
+	if tooBig {
+		return nil, fmt.Sprintf("That attachment is over the %s limit.", formatBytes(attachmentMaxSize))
+	}
+	return nil, fmt.Sprintf("That message has no audio attachment this server plays (%s).", exts)
+}
+
+// handlePlayAttachmentCommand plays a message's audio attachment in the
+// invoker's voice channel, or queues it if something is already playing.
+// ffmpeg streams it from Discord's CDN, like any other URL track. Starting
+// playback means joining voice, so it answers with a deferred response.
+func handlePlayAttachmentCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	var msg *discordgo.Message
+	if data.Resolved != nil {
+		msg = data.Resolved.Messages[data.TargetID]
+	}
+	att, reason := pickAttachment(msg, effectiveExts(i.GuildID))
+	if att == nil {
+		logRespondErr(i, respondPrivate(s, i, reason))
+		return
+	}
+	vs, err := s.State.VoiceState(i.GuildID, interactionUserID(i))
+	if err != nil || vs.ChannelID == "" {
+		logRespondErr(i, respondPrivate(s, i, "Join a voice channel first, then try again."))
+		return
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	reply := func(content string) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("[attachment] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		log.Printf("[attachment] guild=%s user=%s: %s (%s) from message %s", i.GuildID, interactionUserID(i), att.Filename, formatBytes(int64(att.Size)), data.TargetID)
+		if queued, live := queueOnSession(i.GuildID, []string{att.URL}); live {
+			if queued == 0 {
+				reply(fmt.Sprintf("Queue is full (%d max).", maxQueue))
+				return
+			}
+			reply(fmt.Sprintf("Queued **%s**.", displayName(att.Filename)))
+			return
+		}
+		if err := startPlayback(s, i.GuildID, vs.ChannelID, []string{att.URL}, i.Interaction); err != nil {
+			log.Printf("[attachment] playback error: %v", err)
+			reply(playErrorMessage(err))
+			return
+		}
+		reply(fmt.Sprintf("Playing **%s** in <#%s>.", displayName(att.Filename), vs.ChannelID))
+	}()
+}
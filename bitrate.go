@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// tierBitrates is the highest voice bitrate, in kbps, Discord allows at each
+// server boost level.
+var tierBitrates = map[discordgo.PremiumTier]int{
+	discordgo.PremiumTierNone: 96,
+	discordgo.PremiumTier1:    128,
+	discordgo.PremiumTier2:    256,
+	discordgo.PremiumTier3:    384,
+}
+
+// guildBitrate is the encoder bitrate to use in guildID: the configured one,
+// capped at what the server's boost level allows. If the guild can't be
+// looked up the configured bitrate is used as is.
+func guildBitrate(s *discordgo.Session, guildID string) int {
+	want := encodeOptions().Bitrate
+	g, err := s.State.Guild(guildID)
+	if err != nil {
+		if g, err = s.Guild(guildID); err != nil {
+			log.Printf("[bitrate] guild=%s: couldn't look up the boost level, using %d kbps: %v", guildID, want, err)
+			return want
+		}
+	}
+	limit, ok := tierBitrates[g.PremiumTier]
+	if !ok || want <= limit {
+		log.Printf("[bitrate] guild=%s: boost tier %d, using %d kbps", guildID, g.PremiumTier, want)
+		return want
+	}
+	log.Printf("[bitrate] guild=%s: boost tier %d allows %d kbps, capping %d kbps", guildID, g.PremiumTier, limit, want)
+	return limit
+}
+
+// channelBitrate caps want (kbps) at the voice channel's own bitrate, which
+// admins can set below the server's limit; Discord won't carry more, so
+// encoding above it only costs CPU. ch is nil when the channel couldn't be
+// looked up, and want is kept.
+func channelBitrate(ch *discordgo.Channel, want int) int {
+	if ch == nil || ch.Bitrate <= 0 {
+		return want
+	}
+	limit := ch.Bitrate / 1000
+	if want <= limit {
+		return want
+	}
+	log.Printf("[bitrate] channel %s (%q) is set to %d kbps, capping %d kbps", ch.ID, ch.Name, limit, want)
+	return limit
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const categoriesFile = "categories.json"
+
+// categoryEntry is a file or folder (relative to SOUNDS_DIR, slash-separated)
+// in a category. Weight makes its tracks come up earlier in the shuffle: a
+// weight of 2 is twice as likely to be picked next as a weight of 1.
+type categoryEntry struct {
+	Path   string  `json:"path"`
+	Weight float64 `json:"weight,omitempty"` // 0 = 1
+}
+
+func (e categoryEntry) weight() float64 {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// categories holds the categories the owner defined with /categories, by
+// lower-case name, persisted in DATA_DIR. A top-level folder of the library
+// is a category of its own without being defined. Categories are bot-wide,
+// so every shard shares the file and rereads it before each use; a change
+// made on another shard is never overwritten with a stale copy.
+var categories = struct {
+	sync.Mutex
+	byName map[string][]categoryEntry
+}{byName: make(map[string][]categoryEntry)}
+
+func loadCategories() {
+	categories.Lock()
+	defer categories.Unlock()
+	loadCategoriesLocked()
+}
+
+// loadCategoriesLocked replaces the categories with the file's. If it can't
+// be read, the ones already loaded are kept.
+func loadCategoriesLocked() {
+	byName := make(map[string][]categoryEntry)
+	if err := loadJSON(categoriesFile, &byName); err != nil {
+		log.Printf("[category] couldn't load categories: %v", err)
+		return
+	}
+	if byName == nil {
+		byName = make(map[string][]categoryEntry)
+	}
+	categories.byName = byName
+}
+
+func saveCategoriesLocked() {
+	if err := saveJSON(categoriesFile, categories.byName); err != nil {
+		log.Printf("[category] couldn't save categories: %v", err)
+	}
+}
+
+// categoryEntries returns a category's entries: its definition, else the
+// top-level folder of that name. ok is false if it's neither.
+func categoryEntries(name string) (entries []categoryEntry, ok bool) {
+	categories.Lock()
+	loadCategoriesLocked()
+	entries, ok = categories.byName[name]
+	categories.Unlock()
+	if ok {
+		return entries, true
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, false
+	}
+	dirs, err := os.ReadDir(soundsDir)
+	if err != nil {
+		return nil, false
+	}
+	for _, d := range dirs {
+		if d.IsDir() && strings.ToLower(d.Name()) == name {
+			return []categoryEntry{{Path: d.Name()}}, true
+		}
+	}
+	return nil, false
+}
+
+// categoryTracks lists a category's tracks as full paths in weighted random
+// order. Playlists are left out, as in /playall.
+func categoryTracks(guildID string, entries []categoryEntry) ([]string, error) {
+	files, _, err := scanLibrary(soundsDir, effectiveExts(guildID))
+	if err != nil {
+		return nil, err
+	}
+	var tracks []string
+	var weights []float64
+	for _, f := range files {
+		if isPlaylist(f) {
+			continue
+		}
+		w := 0.0
+		for _, e := range entries {
+			if f == e.Path || strings.HasPrefix(f, e.Path+"/") {
+				w = math.Max(w, e.weight())
+			}
+		}
+		if w > 0 {
+			tracks = append(tracks, filepath.Join(soundsDir, f))
+			weights = append(weights, w)
+		}
+	}
+	weightedShuffle(tracks, weights)
+	return tracks, nil
+}
+
+// weightedShuffle orders tracks randomly, heavier ones more likely first. Each
+// track draws a key of -ln(U)/weight and the smallest keys go first, which is
+// the same as repeatedly picking the next track in proportion to its weight.
+func weightedShuffle(tracks []string, weights []float64) {
+	keys := make([]float64, len(tracks))
+	for n := range keys {
+		keys[n] = -math.Log(1-rand.Float64()) / weights[n]
+	}
+	sort.Sort(byKey{tracks, keys})
+}
+
+type byKey struct {
+	tracks []string
+	keys   []float64
+}
+
+func (b byKey) Len() int           { return len(b.tracks) }
+func (b byKey) Less(x, y int) bool { return b.keys[x] < b.keys[y] }
+func (b byKey) Swap(x, y int) {
+	b.tracks[x], b.tracks[y] = b.tracks[y], b.tracks[x]
+	b.keys[x], b.keys[y] = b.keys[y], b.keys[x]
+}
+
+func categoryCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        "category",
+		Description: "Play a shuffled stream of one category of sounds",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Category, e.g. lofi; /categories list shows them", Required: true},
+		},
+	}
+}
+
+// handleCategoryCommand queues a category, shuffled by weight, like /playall
+// does the library.
+func handleCategoryCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	name := strings.ToLower(strings.TrimSpace(i.ApplicationCommandData().Options[0].StringValue()))
+	entries, ok := categoryEntries(name)
+	if !ok {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("There's no %q category. /categories list shows them.", name)))
+		return
+	}
+
+	channelID := ""
+	if vs, err := s.State.VoiceState(i.GuildID, interactionUserID(i)); err == nil && vs.ChannelID != "" {
+		channelID = vs.ChannelID
+	} else if last := lastChannel(s, i.GuildID); last != nil {
+		channelID = last.ID
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	reply := func(content string) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("[category] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		tracks, err := categoryTracks(i.GuildID, entries)
+		if err != nil {
+			reply(scanErrorMessage(err))
+			return
+		}
+		if len(tracks) == 0 {
+			reply(fmt.Sprintf("The %s category has no sounds this server can play.", name))
+			return
+		}
+		log.Printf("[category] guild=%s user=%s: %s (%d tracks)", i.GuildID, interactionUserID(i), name, len(tracks))
+		reply(fmt.Sprintf("The %s category has %d track(s). %s", name, len(tracks), enqueueAll(s, i, channelID, tracks)))
+	}()
+}
+
+func categoriesCommand() *discordgo.ApplicationCommand {
+	minWeight, maxWeight := 0.1, 100.0
+	nameOpt := &discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Category name", Required: true}
+	return &discordgo.ApplicationCommand{
+		Name:                     "categories",
+		Description:              "Owner only: define the categories /category plays",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Add a file or folder to a category, creating it if needed",
+				Options: []*discordgo.ApplicationCommandOption{
+					nameOpt,
+					{Type: discordgo.ApplicationCommandOptionString, Name: "path", Description: "File or folder inside the sounds directory", Required: true},
+					{Type: discordgo.ApplicationCommandOptionNumber, Name: "weight", Description: "How much more often it comes up (default 1)", MinValue: &minWeight, MaxValue: maxWeight},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "remove",
+				Description: "Remove a file or folder from a category, or the whole category",
+				Options: []*discordgo.ApplicationCommandOption{
+					nameOpt,
+					{Type: discordgo.ApplicationCommandOptionString, Name: "path", Description: "Entry to remove (default: the whole category)"},
+				},
+			},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "Show every category"},
+		},
+	}
+}
+
+func handleCategoriesCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	if sub.Name != "list" && !isOwner(i) {
+		log.Printf("[AUDIT] /categories %s denied for user=%s guild=%s", sub.Name, interactionUserID(i), i.GuildID)
+		logRespondErr(i, respondPrivate(s, i, "Only the bot owner can change categories."))
+		return
+	}
+
+	var name, rel string
+	weight := 0.0
+	for _, opt := range sub.Options {
+		switch opt.Name {
+		case "name":
+			name = strings.ToLower(strings.TrimSpace(opt.StringValue()))
+		case "path":
+			if v := strings.Trim(filepath.ToSlash(strings.TrimSpace(opt.StringValue())), "/"); v != "" {
+				rel = path.Clean(v)
+			}
+		case "weight":
+			weight = opt.FloatValue()
+		}
+	}
+
+	switch sub.Name {
+	case "add":
+		if rel == "" || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			logRespondErr(i, respondPrivate(s, i, "The path must be inside the sounds directory."))
+			return
+		}
+		if _, err := os.Stat(filepath.Join(soundsDir, rel)); err != nil {
+			logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("There's no %s in the sounds directory.", rel)))
+			return
+		}
+		if weight == 1 {
+			weight = 0
+		}
+		categories.Lock()
+		loadCategoriesLocked()
+		var list []categoryEntry
+		for _, e := range categories.byName[name] {
+			if e.Path != rel {
+				list = append(list, e)
+			}
+		}
+		categories.byName[name] = append(list, categoryEntry{Path: rel, Weight: weight})
+		saveCategoriesLocked()
+		categories.Unlock()
+		log.Printf("[category] user=%s: added %s to %s (weight %g)", interactionUserID(i), rel, name, categoryEntry{Weight: weight}.weight())
+		logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("Added %s to %s.", rel, name), nil))
+	case "remove":
+		categories.Lock()
+		loadCategoriesLocked()
+		list, ok := categories.byName[name]
+		removed := ok
+		if ok && rel != "" {
+			removed = false
+			var kept []categoryEntry
+			for _, e := range list {
+				if e.Path == rel {
+					removed = true
+				} else {
+					kept = append(kept, e)
+				}
+			}
+			list = kept
+		} else {
+			list = nil
+		}
+		if len(list) == 0 {
+			delete(categories.byName, name)
+		} else {
+			categories.byName[name] = list
+		}
+		if removed {
+			saveCategoriesLocked()
+		}
+		categories.Unlock()
+		msg := fmt.Sprintf("%s isn't in %s.", rel, name)
+		switch {
+		case !ok:
+			msg = fmt.Sprintf("There's no %q category defined.", name)
+		case removed && len(list) == 0:
+			msg = fmt.Sprintf("Removed the %s category.", name)
+		case removed:
+			msg = fmt.Sprintf("Removed %s from %s.", rel, name)
+		}
+		if !removed {
+			logRespondErr(i, respondPrivate(s, i, msg))
+			return
+		}
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+	case "list":
+		logRespondErr(i, respondEphemeral(s, i, tailTruncate(categoryList(), 2000), nil))
+	}
+}
+
+// categoryList describes the defined categories, then the folder ones.
+func categoryList() string {
+	var lines []string
+	categories.Lock()
+	loadCategoriesLocked()
+	names := make([]string, 0, len(categories.byName))
+	for name := range categories.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var parts []string
+		for _, e := range categories.byName[name] {
+			part := e.Path
+			if e.weight() != 1 {
+				part += fmt.Sprintf(" (×%g)", e.weight())
+			}
+			parts = append(parts, part)
+		}
+		lines = append(lines, fmt.Sprintf("**%s**: %s", name, strings.Join(parts, ", ")))
+	}
+
+	var folders []string
+	if dirs, err := os.ReadDir(soundsDir); err == nil {
+		for _, d := range dirs {
+			if _, defined := categories.byName[strings.ToLower(d.Name())]; d.IsDir() && !defined {
+				folders = append(folders, strings.ToLower(d.Name()))
+			}
+		}
+	}
+	categories.Unlock()
+	if len(folders) > 0 {
+		lines = append(lines, "Folders: "+strings.Join(folders, ", "))
+	}
+	if len(lines) == 0 {
+		return "No categories yet. Add some with /categories add, or make folders in the sounds directory."
+	}
+	return strings.Join(lines, "\n")
+}
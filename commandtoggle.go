@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const disabledCommandsFile = "disabled-commands.json"
+
+// disabledCommands holds the commands each guild's admins have turned off,
+// persisted in DATA_DIR as guild → command names. Everything is enabled by
+// default.
+var disabledCommands = struct {
+	sync.Mutex
+	byGuild map[string][]string
+}{byGuild: make(map[string][]string)}
+
+func loadDisabledCommands() {
+	disabledCommands.Lock()
+	defer disabledCommands.Unlock()
+	if err := loadJSON(shardFileName(disabledCommandsFile), &disabledCommands.byGuild); err != nil {
+		log.Printf("[commands] couldn't load disabled commands: %v", err)
+	}
+	if disabledCommands.byGuild == nil {
+		disabledCommands.byGuild = make(map[string][]string)
+	}
+}
+
+func saveDisabledCommandsLocked() {
+	if err := saveJSON(shardFileName(disabledCommandsFile), disabledCommands.byGuild); err != nil {
+		log.Printf("[commands] couldn't save disabled commands: %v", err)
+	}
+}
+
+// commandDisabled reports whether a guild has turned the command off.
+func commandDisabled(guildID, name string) bool {
+	disabledCommands.Lock()
+	defer disabledCommands.Unlock()
+	for _, n := range disabledCommands.byGuild[guildID] {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// guildDisabledCommands lists the commands a guild has turned off.
+func guildDisabledCommands(guildID string) []string {
+	disabledCommands.Lock()
+	defer disabledCommands.Unlock()
+	return append([]string(nil), disabledCommands.byGuild[guildID]...)
+}
+
+// knownCommand reports whether name is one of the bot's commands. Names are
+// stored lower-case, so context-menu ones like "Play this attachment" match
+// regardless of case.
+func knownCommand(name string) bool {
+	for _, cmd := range slashCommands() {
+		if strings.EqualFold(cmd.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleCommandsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+
+	if sub.Name == "list" {
+		list := guildDisabledCommands(i.GuildID)
+		msg := "Every command is enabled in this server."
+		if len(list) > 0 {
+			msg = "Disabled in this server: /" + strings.Join(list, ", /")
+		}
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+		return
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(sub.Options[0].StringValue()), "/"))
+	if !knownCommand(name) {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("There's no /%s command.", name)))
+		return
+	}
+	if name == "commands" {
+		logRespondErr(i, respondPrivate(s, i, "/commands can't be disabled, or there'd be no way to turn it back on."))
+		return
+	}
+
+	disabledCommands.Lock()
+	var list []string
+	for _, n := range disabledCommands.byGuild[i.GuildID] {
+		if n != name {
+			list = append(list, n)
+		}
+	}
+	if sub.Name == "disable" {
+		list = append(list, name)
+		sort.Strings(list)
+	}
+	if len(list) == 0 {
+		delete(disabledCommands.byGuild, i.GuildID)
+	} else {
+		disabledCommands.byGuild[i.GuildID] = list
+	}
+	saveDisabledCommandsLocked()
+	disabledCommands.Unlock()
+
+	log.Printf("[commands] guild=%s user=%s: /%s %sd", i.GuildID, interactionUserID(i), name, sub.Name)
+	msg := fmt.Sprintf("/%s is now disabled in this server.", name)
+	if sub.Name == "enable" {
+		msg = fmt.Sprintf("/%s is enabled again.", name)
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+func commandsCommand() *discordgo.ApplicationCommand {
+	nameOption := []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "command", Description: "Command name, e.g. preview", Required: true},
+	}
+	return &discordgo.ApplicationCommand{
+		Name:                     "commands",
+		Description:              "Turn the bot's commands on or off in this server",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "disable", Description: "Turn a command off in this server", Options: nameOption},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "enable", Description: "Turn a disabled command back on", Options: nameOption},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list", Description: "Show which commands are disabled here"},
+		},
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// componentHandler handles a click or selection. id is the base custom ID and
+// gen the browser generation it carries, if any (see splitCustomID).
+type componentHandler func(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64)
+
+// componentRoutes maps custom ID prefixes to their handlers; the longest
+// matching prefix wins. A new component family needs only a route here, and
+// an ID that matches none is logged instead of vanishing.
+var componentRoutes = []struct {
+	prefix string
+	handle componentHandler
+}{
+	{"stop_confirm", handleStopConfirm},
+	{"stop_cancel", handleStopCancel},
+	{"sounds_", handleBrowserComponent},
+	{"sound_select", handleSoundSelect},
+	{"back_to_sounds", handleBackToSounds},
+	{"voice_select", handleVoiceSelect},
+	{"voice_last", handleVoiceLast},
+	{"voice_retry", handleVoiceRetry},
+}
+
+// componentRoute returns the handler for a base custom ID, or nil.
+func componentRoute(id string) componentHandler {
+	var handle componentHandler
+	best := -1
+	for _, r := range componentRoutes {
+		if strings.HasPrefix(id, r.prefix) && len(r.prefix) > best {
+			handle, best = r.handle, len(r.prefix)
+		}
+	}
+	return handle
+}
+
+func handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	id, gen := splitCustomID(i.MessageComponentData().CustomID)
+	if owner := componentOwner(i, gen); owner != "" && owner != interactionUserID(i) {
+		logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("That menu belongs to <@%s>. Run /%s to open your own.", owner, soundsCmdName),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		}))
+		return
+	}
+	handle := componentRoute(id)
+	if handle == nil {
+		unknownComponent(s, i, id)
+		return
+	}
+	handle(s, i, id, gen)
+}
+
+// unknownComponent answers a component or modal no route handles, usually one
+// from a message posted by an older version of the bot, and logs its ID.
+func unknownComponent(s *discordgo.Session, i *discordgo.InteractionCreate, id string) {
+	log.Printf("[interaction] unknown custom ID %q (%s) from user=%s guild=%s", id, interactionLabel(i), interactionUserID(i), i.GuildID)
+	logRespondErr(i, respondUpdate(s, i, "This control is no longer supported. Run /"+soundsCmdName+" again.", nil))
+}
+
+func handleStopConfirm(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	msg := "Nothing is playing."
+	if stopGuildPlayback(i.GuildID) {
+		msg = "Stopped playback and left the voice channel."
+	}
+	logRespondErr(i, respondUpdate(s, i, msg, []discordgo.MessageComponent{}))
+}
+
+func handleStopCancel(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	logRespondErr(i, respondUpdate(s, i, "Kept playing.", []discordgo.MessageComponent{}))
+}
+
+// handleBrowserComponent handles the picker's paging, search, sort, folder
+// and cancel buttons.
+func handleBrowserComponent(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	key := browserKey(i)
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	switch id {
+	case "sounds_dir_playing":
+		dir, ok := nowPlayingDir(i.GuildID)
+		if !ok {
+			logRespondErr(i, respondUpdate(s, i, "Nothing from the library is playing right now.\n"+pickerContent(state), buildSoundPickerComponents(state)))
+			return
+		}
+		if !state.setDir(dir) {
+			logRespondErr(i, respondUpdate(s, i, "That folder has nothing this menu can list.\n"+pickerContent(state), buildSoundPickerComponents(state)))
+			return
+		}
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_dir_all":
+		state.setDir("")
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_sort_name", "sounds_sort_mtime", "sounds_sort_size":
+		state.sortFiles(strings.TrimPrefix(id, "sounds_sort_"))
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_prev":
+		if state.Page > 0 {
+			state.Page--
+		}
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_next":
+		if state.Page < state.maxPage() {
+			state.Page++
+		}
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_jump":
+		logRespondErr(i, respondModal(s, i, browserID(state, "sounds_jump_modal"), "Jump to page", discordgo.TextInput{
+			CustomID:    "page",
+			Label:       fmt.Sprintf("Page number (1-%d)", state.maxPage()+1),
+			Style:       discordgo.TextInputShort,
+			Placeholder: strconv.Itoa(state.Page + 1),
+			Required:    true,
+			MaxLength:   6,
+		}))
+	case "sounds_search":
+		logRespondErr(i, respondModal(s, i, browserID(state, "sounds_search_modal"), "Search sounds", discordgo.TextInput{
+			CustomID:  "query",
+			Label:     "Name contains (leave empty to clear)",
+			Style:     discordgo.TextInputShort,
+			Value:     state.Query,
+			Required:  false,
+			MaxLength: 100,
+		}))
+	case "sounds_cancel":
+		if cancelStopsPlayback && browserPlayback(i.GuildID, state) != nil {
+			logRespondErr(i, respondUpdate(s, i, "Also stop the playback you started?", buildCancelConfirmComponents(state)))
+			return
+		}
+		fallthrough
+	case "sounds_cancel_close":
+		// End the ephemeral browser
+		browserStates.Lock()
+		delete(browserStates.data, key)
+		browserStates.Unlock()
+		logRespondErr(i, respondUpdate(s, i, "Cancelled.", []discordgo.MessageComponent{}))
+	case "sounds_cancel_stop":
+		browserStates.Lock()
+		delete(browserStates.data, key)
+		browserStates.Unlock()
+		msg := "Cancelled. Playback had already ended."
+		if gp := browserPlayback(i.GuildID, state); gp != nil {
+			gp.stop()
+			playSessions.CompareAndDelete(i.GuildID, gp)
+			msg = "Cancelled and stopped playback."
+		}
+		logRespondErr(i, respondUpdate(s, i, msg, []discordgo.MessageComponent{}))
+	default:
+		unknownComponent(s, i, id)
+	}
+}
+
+func handleSoundSelect(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	// selection value = index into state.Files
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	vals := i.MessageComponentData().Values
+	if len(vals) == 0 {
+		logRespondErr(i, respondUpdate(s, i, "No selection received. Try again.", buildSoundPickerComponents(state)))
+		return
+	}
+	selected, err := selectedFiles(state.Files, vals)
+	if err != nil {
+		logRespondErr(i, respondUpdate(s, i, "Invalid selection. Try again.", buildSoundPickerComponents(state)))
+		return
+	}
+	state.Selected = selected
+	if requireSameVC {
+		playInUserChannel(s, i, state)
+		return
+	}
+	if skipChannelPicker {
+		if last := lastChannel(s, i.GuildID); last != nil {
+			playSelection(s, i, state, last.ID)
+			return
+		}
+	}
+	// Move to voice channel selection view
+	components := buildVoiceChannelPickerComponents(s, i.GuildID, state)
+	content := fmt.Sprintf("Selected: %s\nSelect a voice channel to join and play.", strings.Join(state.Selected, ", "))
+	logRespondErr(i, respondUpdate(s, i, content, components))
+}
+
+func handleBackToSounds(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	state.Selected = nil
+	logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+}
+
+func handleVoiceSelect(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	// Start playback
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	if len(state.Selected) == 0 {
+		logRespondErr(i, respondUpdate(s, i, "No sound selected. Run /"+soundsCmdName+" again.", nil))
+		return
+	}
+	vals := i.MessageComponentData().Values
+	if len(vals) == 0 {
+		logRespondErr(i, respondUpdate(s, i, "No channel selected.", buildVoiceChannelPickerComponents(s, i.GuildID, state)))
+		return
+	}
+	playSelection(s, i, state, vals[0])
+}
+
+func handleVoiceLast(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	if len(state.Selected) == 0 {
+		logRespondErr(i, respondUpdate(s, i, "No sound selected. Run /"+soundsCmdName+" again.", nil))
+		return
+	}
+	last := lastChannel(s, i.GuildID)
+	if last == nil {
+		content := fmt.Sprintf("Selected: %s\nThe last channel no longer exists. Select a voice channel to join and play.", strings.Join(state.Selected, ", "))
+		logRespondErr(i, respondUpdate(s, i, content, buildVoiceChannelPickerComponents(s, i.GuildID, state)))
+		return
+	}
+	playSelection(s, i, state, last.ID)
+}
+
+func handleVoiceRetry(s *discordgo.Session, i *discordgo.InteractionCreate, id string, gen uint64) {
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+	if len(state.Selected) == 0 {
+		logRespondErr(i, respondUpdate(s, i, "No sound selected. Run /"+soundsCmdName+" again.", nil))
+		return
+	}
+	playInUserChannel(s, i, state)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// nowPlayingInfo is one guild's playback state as served by GET /nowplaying.
+type nowPlayingInfo struct {
+	GuildID        string   `json:"guild_id"`
+	ChannelID      string   `json:"channel_id"`
+	File           string   `json:"file"`  // path relative to SOUNDS_DIR, or the URL
+	Title          string   `json:"title"` // as shown in Discord
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	TotalSeconds   *float64 `json:"total_seconds"` // null if the length is unknown
+	Paused         bool     `json:"paused"`
+	Volume         float64  `json:"volume"`       // 1 = unchanged (per-file sidecar volume)
+	QueueLength    int      `json:"queue_length"` // tracks waiting after this one
+}
+
+// trackDurations caches probeDuration per file so polling dashboards don't
+// run ffprobe on every request.
+var trackDurations sync.Map // path → time.Duration
+
+// snapshot reads the current track's state in one go under the mutex, so
+// elapsed, paused and the queue always belong together. ok is false between
+// tracks or once playback has stopped.
+func (gp *guildPlayback) snapshot() (info nowPlayingInfo, ok bool) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if gp.stopped || gp.playing == "" || (gp.streamer == nil && gp.stream == nil) {
+		return info, false
+	}
+	info = nowPlayingInfo{
+		GuildID:     gp.guildID,
+		ChannelID:   gp.channelID,
+		File:        gp.playing,
+		Title:       trackLabel(gp.playing),
+		Paused:      gp.paused,
+		QueueLength: len(gp.queue),
+	}
+	info.ElapsedSeconds = gp.elapsedLocked().Seconds()
+	return info, true
+}
+
+// nowPlaying returns the state of every guild currently playing, with the
+// file made relative and the slower lookups (sidecar, ffprobe) filled in
+// outside the playback lock.
+func nowPlaying() []nowPlayingInfo {
+	list := []nowPlayingInfo{}
+	playSessions.Range(func(_, value any) bool {
+		if info, ok := value.(*guildPlayback).snapshot(); ok {
+			list = append(list, info)
+		}
+		return true
+	})
+	for idx := range list {
+		info := &list[idx]
+		path := info.File
+		info.Volume = 1
+		if meta := loadTrackMeta(path); meta.Volume != nil && !isReaderTrack(path) {
+			info.Volume = *meta.Volume
+		}
+		if total := cachedDuration(path); total > 0 {
+			secs := total.Seconds()
+			info.TotalSeconds = &secs
+		}
+		if rel, err := filepath.Rel(soundsDir, path); err == nil && !isURL(path) && !strings.HasPrefix(rel, "..") {
+			info.File = filepath.ToSlash(rel)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].GuildID < list[b].GuildID })
+	return list
+}
+
+func cachedDuration(path string) time.Duration {
+	if isURL(path) || isReaderTrack(path) {
+		return 0 // probing a stream can take seconds; a reader can't be probed
+	}
+	if d, ok := trackDurations.Load(path); ok {
+		return d.(time.Duration)
+	}
+	d := probeDuration(path)
+	trackDurations.Store(path, d)
+	return d
+}
+
+// startControlServer serves the HTTP control API on CONTROL_ADDR, if set.
+//
+//	GET /nowplaying              every guild that is playing, as a JSON array
+//	GET /nowplaying?guild=<id>   one guild as a JSON object, 404 if idle
+//	POST /play?guild=<id>        play the request body (raw audio) in voice
+//	GET /invite                  redirect to the bot's OAuth2 invite link (?json for the URL)
+//	GET /metrics                 audio health counters since startup, as JSON
+func startControlServer(s *discordgo.Session) {
+	if controlAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nowplaying", handleNowPlaying)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("POST /play", func(w http.ResponseWriter, r *http.Request) { handlePlayBody(s, w, r) })
+	mux.HandleFunc("GET /invite", func(w http.ResponseWriter, r *http.Request) { handleInvite(s, w, r) })
+
+	srv := &http.Server{
+		Addr:              controlAddr,
+		Handler:           requireControlToken(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if controlToken == "" && !isLoopbackAddr(controlAddr) {
+		log.Printf("[control] warning: CONTROL_ADDR=%s is reachable from other hosts and CONTROL_TOKEN is not set", controlAddr)
+	}
+	go func() {
+		log.Printf("[control] listening on %s", controlAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[control] server stopped: %v", err)
+		}
+	}()
+}
+
+func handleNowPlaying(w http.ResponseWriter, r *http.Request) {
+	list := nowPlaying()
+	guildID := r.URL.Query().Get("guild")
+	if guildID == "" {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	for _, info := range list {
+		if info.GuildID == guildID {
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "nothing is playing in that guild"})
+}
+
+// streamMetrics is what GET /metrics serves: this process's audio health
+// counters since startup, summed over its guilds.
+type streamMetrics struct {
+	Shard             int   `json:"shard"`
+	Sessions          int   `json:"sessions"`            // guilds playing right now
+	AudioUnderruns    int64 `json:"audio_underruns"`     // frames the encoder didn't have ready in time
+	VoiceSendStalls   int64 `json:"voice_send_stalls"`   // frames the voice connection was slow to take
+	VoiceSendFailures int64 `json:"voice_send_failures"` // streams ended by a stalled or dead connection
+	VoiceReconnects   int64 `json:"voice_reconnects"`    // reconnects to resume a track
+}
+
+func currentMetrics() streamMetrics {
+	m := streamMetrics{
+		Shard:             shardID,
+		AudioUnderruns:    audioUnderruns.Load(),
+		VoiceSendStalls:   voiceSendStalls.Load(),
+		VoiceSendFailures: voiceSendFailures.Load(),
+		VoiceReconnects:   voiceReconnects.Load(),
+	}
+	playSessions.Range(func(_, _ any) bool {
+		m.Sessions++
+		return true
+	})
+	return m
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentMetrics())
+}
+
+// handlePlayBody streams the request body into the guild's voice channel
+// (channel=<id>, or the bot's last channel there), replacing what's playing.
+// The body is read as it arrives, so a live stream or TTS can be piped in,
+// e.g. curl -T - ...; the response is sent once playback has ended.
+func handlePlayBody(s *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	guildID, channelID := q.Get("guild"), q.Get("channel")
+	if guildID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "guild is required"})
+		return
+	}
+	if channelID == "" {
+		if last := lastChannel(s, guildID); last != nil {
+			channelID = last.ID
+		} else {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "channel is required; the bot hasn't played in that guild yet"})
+			return
+		}
+	}
+	label := q.Get("label")
+	if label == "" {
+		label = "live audio"
+	}
+
+	id, rt := registerReader(label, r.Body)
+	log.Printf("[control] POST /play from %s: guild=%s channel=%s label=%q", r.RemoteAddr, guildID, channelID, label)
+	if err := startPlayback(s, guildID, channelID, []string{id}, nil); err != nil {
+		releaseReader(id)
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error(), "code": playErrName(err)})
+		return
+	}
+	select {
+	case <-rt.done:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "finished"})
+	case <-r.Context().Done():
+		// Client went away; ending the body ends the track.
+		releaseReader(id)
+	}
+}
+
+// requireControlToken rejects requests without the CONTROL_TOKEN bearer token.
+func requireControlToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if controlToken != "" {
+			got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(controlToken)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong bearer token"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[control] writing response: %v", err)
+	}
+}
+
+func isLoopbackAddr(addr string) bool {
+	host := addr
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		host = addr[:i]
+	}
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	before := currentMetrics()
+	audioUnderruns.Add(2)
+	voiceSendStalls.Add(1)
+	voiceSendFailures.Add(1)
+	voiceReconnects.Add(3)
+	playSessions.Store("metrics-test", &guildPlayback{guildID: "metrics-test"})
+	t.Cleanup(func() { playSessions.Delete("metrics-test") })
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var got streamMetrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := streamMetrics{
+		Shard:             shardID,
+		Sessions:          before.Sessions + 1,
+		AudioUnderruns:    before.AudioUnderruns + 2,
+		VoiceSendStalls:   before.VoiceSendStalls + 1,
+		VoiceSendFailures: before.VoiceSendFailures + 1,
+		VoiceReconnects:   before.VoiceReconnects + 3,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
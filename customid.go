@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Discord rejects the whole message if any custom_id is longer than this.
+	maxCustomIDLen = 100
+
+	// Interaction tokens die after 15 minutes, so no one can click a
+	// component whose mapping has expired and still get a response.
+	longIDTTL = 15 * time.Minute
+
+	longIDPrefix = "~"
+)
+
+// longIDs maps short opaque custom IDs to payloads that didn't fit.
+var longIDs = struct {
+	sync.Mutex
+	next    uint64
+	entries map[string]longIDEntry
+}{entries: make(map[string]longIDEntry)}
+
+type longIDEntry struct {
+	payload string
+	expires time.Time
+}
+
+// componentID returns payload as a custom ID, swapping it for a short opaque
+// key when it exceeds Discord's limit (deep folder paths and the like).
+func componentID(payload string) string {
+	if len(payload) <= maxCustomIDLen && !strings.HasPrefix(payload, longIDPrefix) {
+		return payload
+	}
+	now := time.Now()
+	longIDs.Lock()
+	defer longIDs.Unlock()
+	for k, e := range longIDs.entries {
+		if now.After(e.expires) {
+			delete(longIDs.entries, k)
+		}
+	}
+	longIDs.next++
+	key := longIDPrefix + strconv.FormatUint(longIDs.next, 36)
+	longIDs.entries[key] = longIDEntry{payload: payload, expires: now.Add(longIDTTL)}
+	return key
+}
+
+// resolveComponentID undoes componentID. An expired key resolves to itself,
+// which no handler recognises.
+func resolveComponentID(customID string) string {
+	if !strings.HasPrefix(customID, longIDPrefix) {
+		return customID
+	}
+	longIDs.Lock()
+	defer longIDs.Unlock()
+	if e, ok := longIDs.entries[customID]; ok && time.Now().Before(e.expires) {
+		return e.payload
+	}
+	return customID
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/dca"
+)
+
+const diagToneSeconds = 3
+
+// /diag -> owner-only end-to-end audio check that doesn't depend on library files.
+// Joins the caller's voice channel, streams a generated sine tone and reports each stage.
+func handleDiagCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isOwner(i) {
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+	if _, busy := playSessions.Load(i.GuildID); busy {
+		logRespondErr(i, respondPrivate(s, i, "Something is already playing here. Use /"+stopCmdName+" first."))
+		return
+	}
+	vs, err := s.State.VoiceState(i.GuildID, interactionUserID(i))
+	if err != nil || vs.ChannelID == "" {
+		logRespondErr(i, respondPrivate(s, i, "Join a voice channel first, then run /diag."))
+		return
+	}
+
+	// Joining and streaming take longer than the 3s interaction deadline.
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	var lines []string
+	report := func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		log.Printf("[diag] guild=%s %s", i.GuildID, line)
+		lines = append(lines, line)
+		content := strings.Join(lines, "\n")
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("[diag] failed to update report: %v", err)
+		}
+	}
+
+	go runDiag(s, i.GuildID, vs.ChannelID, report)
+}
+
+func runDiag(s *discordgo.Session, guildID, channelID string, report func(string, ...any)) {
+	start := time.Now()
+	vc, err := s.ChannelVoiceJoin(guildID, channelID, false, joinDeafened)
+	if err != nil {
+		report("❌ join <#%s>: %v", channelID, err)
+		return
+	}
+	defer disconnectVoice(vc)
+	report("✅ join <#%s> (%s)", channelID, time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	if err := waitVoiceReady(vc, 5*time.Second); err != nil {
+		report("❌ ready: %v", err)
+		return
+	}
+	report("✅ ready (%s)", time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	tone := exec.Command(
+		"ffmpeg", "-v", "error", "-nostdin", "-hide_banner",
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=440:duration=%d", diagToneSeconds),
+		"-f", "wav", "pipe:1",
+	)
+	pcm, err := tone.StdoutPipe()
+	if err != nil {
+		report("❌ encode: %v", err)
+		return
+	}
+	if err := tone.Start(); err != nil {
+		report("❌ encode: starting ffmpeg tone source: %v", err)
+		return
+	}
+	defer func() { _ = tone.Wait() }()
+
+	enc, err := dca.EncodeMem(pcm, encodeOptions())
+	if err != nil {
+		_ = tone.Process.Kill()
+		report("❌ encode: %v", err)
+		return
+	}
+	defer enc.Cleanup()
+	report("✅ encode started (%s)", time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	if err := vc.Speaking(true); err != nil {
+		report("⚠️ speaking: %v", err)
+	}
+	done := make(chan error, 1)
+	stream := dca.NewStream(enc, vc, done)
+	err = <-done
+	sent := stream.PlaybackPosition()
+	if err != nil && err != io.EOF {
+		report("❌ stream: %v after %s sent; ffmpeg: %s", err, sent, strings.TrimSpace(enc.FFMPEGMessages()))
+		return
+	}
+	if sent == 0 {
+		report("❌ stream: no audio frames were produced; ffmpeg: %s", strings.TrimSpace(enc.FFMPEGMessages()))
+		return
+	}
+	report("✅ stream: sent %s of audio in %s. If you heard a %ds tone, the audio path works.",
+		sent, time.Since(start).Round(time.Millisecond), diagToneSeconds)
+}
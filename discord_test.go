@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeAPI stands in for Discord's REST API, as in the interaction harness:
+// it records every request and answers it successfully, except that, like
+// Discord, it refuses a second response to the same interaction.
+type fakeAPI struct {
+	mu       sync.Mutex
+	requests []apiRequest
+	answered map[string]bool
+}
+
+type apiRequest struct {
+	Method, Path string
+	Body         []byte
+}
+
+func (f *fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, apiRequest{Method: req.Method, Path: req.URL.Path, Body: body})
+	status, reply := http.StatusOK, "{}"
+	if strings.HasSuffix(req.URL.Path, "/callback") {
+		status, reply = http.StatusNoContent, ""
+		if f.answered[req.URL.Path] {
+			status, reply = http.StatusBadRequest, `{"code": 40060, "message": "Interaction has already been acknowledged."}`
+		}
+		f.answered[req.URL.Path] = true
+	}
+	f.mu.Unlock()
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(reply)),
+		Request:    req,
+	}, nil
+}
+
+// sentMessage is the part of a response or followup the tests look at.
+type sentMessage struct {
+	Content string
+	Flags   discordgo.MessageFlags
+}
+
+// responses returns the interaction responses that were accepted, in order.
+func (f *fakeAPI) responses() []sentMessage {
+	return f.sent(func(path string) bool { return strings.HasSuffix(path, "/callback") })
+}
+
+// followups returns the followup messages sent, in order.
+func (f *fakeAPI) followups() []sentMessage {
+	return f.sent(func(path string) bool { return strings.HasPrefix(path, "/api/v9/webhooks/") })
+}
+
+func (f *fakeAPI) sent(match func(path string) bool) []sentMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seen := map[string]bool{}
+	var out []sentMessage
+	for _, r := range f.requests {
+		if !match(r.Path) || r.Method != http.MethodPost {
+			continue
+		}
+		if strings.HasSuffix(r.Path, "/callback") {
+			if seen[r.Path] {
+				continue // refused
+			}
+			seen[r.Path] = true
+		}
+		var raw struct {
+			Data *sentMessage `json:"data"`
+			sentMessage
+		}
+		_ = json.Unmarshal(r.Body, &raw)
+		if raw.Data != nil {
+			out = append(out, *raw.Data)
+		} else {
+			out = append(out, raw.sentMessage)
+		}
+	}
+	return out
+}
+
+// newTestSession returns a session whose REST calls go to a fakeAPI.
+func newTestSession(t *testing.T) (*discordgo.Session, *fakeAPI) {
+	t.Helper()
+	s, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeAPI{answered: map[string]bool{}}
+	s.Client = &http.Client{Transport: api}
+	s.State.User = &discordgo.User{ID: "900"}
+	return s, api
+}
+
+var testInteractionSeq atomic.Uint64
+
+// testInteraction is an interaction from member 200 in guildID ("" for a DM,
+// where Discord sends User rather than Member).
+func testInteraction(typ discordgo.InteractionType, guildID string, data discordgo.InteractionData) *discordgo.InteractionCreate {
+	id := strconv.FormatUint(testInteractionSeq.Add(1), 10)
+	in := &discordgo.Interaction{
+		ID:      id,
+		AppID:   "900",
+		Token:   "token-" + id,
+		Type:    typ,
+		GuildID: guildID,
+		Data:    data,
+	}
+	user := &discordgo.User{ID: "200"}
+	if guildID == "" {
+		in.User = user
+	} else {
+		in.Member = &discordgo.Member{User: user}
+	}
+	return &discordgo.InteractionCreate{Interaction: in}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const djRolesFile = "dj-roles.json"
+
+// DJ role scopes: what members without the role can't do.
+const (
+	djScopeControls = "controls" // steer playback: stop, pause, queue order, EQ
+	djScopePlay     = "play"     // start playback; browsing stays open
+	djScopeAll      = "all"      // both
+)
+
+// djSetting is a guild's DJ role and what it gates.
+type djSetting struct {
+	Role  string `json:"role"`
+	Scope string `json:"scope"`
+}
+
+// djRoles holds the DJ role each guild's admins set with /djrole, persisted in
+// DATA_DIR. A guild without one falls back to DJ_ROLE; with neither, every
+// member can do everything.
+var djRoles = struct {
+	sync.Mutex
+	byGuild map[string]djSetting
+}{byGuild: make(map[string]djSetting)}
+
+func loadDJRoles() {
+	djRoles.Lock()
+	defer djRoles.Unlock()
+	if err := loadJSON(shardFileName(djRolesFile), &djRoles.byGuild); err != nil {
+		log.Printf("[djrole] couldn't load DJ roles: %v", err)
+	}
+	if djRoles.byGuild == nil {
+		djRoles.byGuild = make(map[string]djSetting)
+	}
+}
+
+func saveDJRolesLocked() {
+	if err := saveJSON(shardFileName(djRolesFile), djRoles.byGuild); err != nil {
+		log.Printf("[djrole] couldn't save DJ roles: %v", err)
+	}
+}
+
+// guildDJ returns the guild's DJ setting, reporting whether it has one.
+func guildDJ(guildID string) (djSetting, bool) {
+	djRoles.Lock()
+	dj, ok := djRoles.byGuild[guildID]
+	djRoles.Unlock()
+	if ok {
+		return dj, true
+	}
+	if role := djRoleEnv[guildID]; role != "" {
+		return djSetting{Role: role, Scope: djScope}, true
+	}
+	return djSetting{}, false
+}
+
+// djCommandScope is the scope that gates a slash command, or "" for one
+// anyone may use. Admin-only commands are left to Discord's own permissions.
+func djCommandScope(name string) string {
+	switch name {
+	case stopCmdName, "pause", "resume", "playnext", "queue", "eq", "nightmode", "pitch":
+		return djScopeControls
+	case "playall", "category", playAttachmentCmdName:
+		return djScopePlay
+	}
+	return ""
+}
+
+// djComponentScope is djCommandScope for buttons and menus, by base custom ID.
+// Paging, searching and sorting the picker stay open to everyone.
+func djComponentScope(id string) string {
+	switch id {
+	case "stop_confirm", "sounds_cancel_stop":
+		return djScopeControls
+	case "sound_select", "voice_select", "voice_last", "voice_retry":
+		return djScopePlay
+	}
+	return ""
+}
+
+// gates reports whether the setting restricts actions of the given scope.
+func (dj djSetting) gates(scope string) bool {
+	return scope != "" && (dj.Scope == djScopeAll || dj.Scope == scope)
+}
+
+// djAllowed reports whether a member may take an action of the given scope.
+// Admins and the bot owner always may.
+func djAllowed(guildID, userID string, roles []string, admin bool, scope string) (djSetting, bool) {
+	dj, ok := guildDJ(guildID)
+	if !ok || !dj.gates(scope) || admin || (ownerID != "" && userID == ownerID) {
+		return dj, true
+	}
+	return dj, slices.Contains(roles, dj.Role)
+}
+
+// checkDJ answers a denied interaction itself and reports whether the handler
+// should go on.
+func checkDJ(s *discordgo.Session, i *discordgo.InteractionCreate, scope, action string) bool {
+	var roles []string
+	admin := false
+	if i.Member != nil {
+		roles = i.Member.Roles
+		admin = i.Member.Permissions&discordgo.PermissionAdministrator != 0
+	}
+	dj, ok := djAllowed(i.GuildID, interactionUserID(i), roles, admin, scope)
+	if ok {
+		return true
+	}
+	log.Printf("[djrole] denied %s for user=%s guild=%s", action, interactionUserID(i), i.GuildID)
+	logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Only members with the %s role can %s here.", roleMention(dj.Role), djScopeVerb(scope))))
+	return false
+}
+
+// djScopeVerb describes what a scope gates, for the denial message.
+func djScopeVerb(scope string) string {
+	if scope == djScopePlay {
+		return "start playback"
+	}
+	return "control playback"
+}
+
+// roleMention mentions a role, which Discord shows as its name whether or not
+// the bot has the role cached. Replies don't ping it: respondEphemeral allows
+// no mentions, and embeds never ping.
+func roleMention(roleID string) string {
+	return "<@&" + roleID + ">"
+}
+
+func djScopeChoices() []*discordgo.ApplicationCommandOptionChoice {
+	return []*discordgo.ApplicationCommandOptionChoice{
+		{Name: "Controls: stop, pause, queue order, EQ (default)", Value: djScopeControls},
+		{Name: "Play: start playback; browsing stays open", Value: djScopePlay},
+		{Name: "All: both", Value: djScopeAll},
+	}
+}
+
+func djRoleCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:                     "djrole",
+		Description:              "Require a role for controlling or starting playback",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Set the DJ role for this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Description: "Members with this role are DJs", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "scope", Description: "What only DJs can do", Choices: djScopeChoices()},
+				},
+			},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "clear", Description: "Let everyone control playback again"},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show", Description: "Show this server's DJ role"},
+		},
+	}
+}
+
+func handleDJRoleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+
+	switch sub.Name {
+	case "set":
+		dj := djSetting{Scope: djScopeControls}
+		for _, opt := range sub.Options {
+			switch opt.Name {
+			case "role":
+				dj.Role = opt.RoleValue(nil, i.GuildID).ID
+			case "scope":
+				dj.Scope = opt.StringValue()
+			}
+		}
+		djRoles.Lock()
+		djRoles.byGuild[i.GuildID] = dj
+		saveDJRolesLocked()
+		djRoles.Unlock()
+		log.Printf("[djrole] guild=%s user=%s: DJ role %s, scope %s", i.GuildID, interactionUserID(i), dj.Role, dj.Scope)
+		logRespondErr(i, respondEphemeral(s, i, "DJ role set. "+djDescription(dj), nil))
+	case "clear":
+		djRoles.Lock()
+		delete(djRoles.byGuild, i.GuildID)
+		saveDJRolesLocked()
+		djRoles.Unlock()
+		msg := "DJ role cleared; everyone can control playback."
+		if dj, ok := guildDJ(i.GuildID); ok {
+			msg = "DJ role cleared. DJ_ROLE still applies: " + djDescription(dj)
+		}
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+	case "show":
+		msg := "No DJ role; everyone can control playback."
+		if dj, ok := guildDJ(i.GuildID); ok {
+			msg = djDescription(dj)
+		}
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+	}
+}
+
+// djDescription says who may do what under dj.
+func djDescription(dj djSetting) string {
+	var what string
+	switch dj.Scope {
+	case djScopePlay:
+		what = "start playback"
+	case djScopeAll:
+		what = "start or control playback"
+	default:
+		what = "control playback"
+	}
+	return fmt.Sprintf("Only members with the %s role (and admins) can %s.", roleMention(dj.Role), what)
+}
+
+// djSummary is the settings line for a guild's DJ role.
+func djSummary(guildID string) string {
+	dj, ok := guildDJ(guildID)
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprintf("%s (%s)", roleMention(dj.Role), dj.Scope)
+}
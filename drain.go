@@ -0,0 +1,144 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// playbacks counts running playback lifecycles (guildPlayback.run), so
+// shutdown can wait for them to finish and leave voice.
+var playbacks sync.WaitGroup
+
+// draining is set once shutdown begins, including while GRACEFUL_DRAIN lets
+// current tracks finish. Interactions are turned away and no new playback
+// starts.
+var draining atomic.Bool
+
+// lifecycles orders starting a playback lifecycle against stopNewPlayback.
+var lifecycles sync.Mutex
+
+// startLifecycle stores gp in playSessions and counts its lifecycle in
+// playbacks, unless shutdown has begun. Once stopNewPlayback has returned
+// every session is in playSessions to be stopped, and playbacks.Wait can't
+// race an Add.
+func startLifecycle(gp *guildPlayback) bool {
+	lifecycles.Lock()
+	defer lifecycles.Unlock()
+	if draining.Load() {
+		return false
+	}
+	playSessions.Store(gp.guildID, gp)
+	playbacks.Add(1)
+	return true
+}
+
+// stopNewPlayback sets draining, so no playback starts from here on.
+func stopNewPlayback() {
+	lifecycles.Lock()
+	draining.Store(true)
+	lifecycles.Unlock()
+}
+
+// drainSummary is the settings line for GRACEFUL_DRAIN.
+func drainSummary() string {
+	if !gracefulDrain {
+		return "off"
+	}
+	return "on, up to " + drainTimeout.String()
+}
+
+// waitPlaybacks waits up to timeout for every playback lifecycle to end,
+// reporting whether they all did. abort, if not nil, ends the wait early.
+func waitPlaybacks(timeout time.Duration, abort <-chan os.Signal) bool {
+	done := make(chan struct{})
+	go func() {
+		playbacks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	case <-abort:
+		return false
+	}
+}
+
+// drainPlayback lets every guild finish its current track, for up to
+// GRACEFUL_DRAIN_TIMEOUT, instead of cutting it off. Queues are cleared so
+// each session ends after its track; with RESUME_ON_START they're saved
+// first and pick up at the next track after the restart. Paused sessions
+// have no track to finish and are stopped; they're saved with their current
+// track and position, as a normal shutdown would. A second signal skips the
+// wait.
+func drainPlayback() {
+	stopNewPlayback()
+	var next []resumeEntry
+	active := 0
+	playSessions.Range(func(key, value any) bool {
+		gp := value.(*guildPlayback)
+		gp.mu.Lock()
+		paused := gp.paused
+		if paused {
+			if e, ok := resumeEntryLocked(gp, time.Now()); ok {
+				next = append(next, e)
+			}
+		} else if !gp.stopped && len(gp.queue) > 0 {
+			e := resumeEntry{GuildID: gp.guildID, ChannelID: gp.channelID, Saved: time.Now()}
+			for _, t := range gp.queue {
+				if isReaderTrack(t) {
+					continue
+				}
+				if e.Playing == "" {
+					e.Playing = t
+				} else {
+					e.Queue = append(e.Queue, t)
+				}
+			}
+			if e.Playing != "" {
+				next = append(next, e)
+			}
+		}
+		gp.queue = nil
+		gp.mu.Unlock()
+		if paused {
+			gp.stop()
+			playSessions.CompareAndDelete(key, gp)
+		} else {
+			active++
+		}
+		return true
+	})
+	// From here sessions ending must not overwrite what was saved below.
+	shuttingDown.Store(true)
+	endIdle()
+	if resumeOnStart {
+		sort.Slice(next, func(a, b int) bool { return next[a].GuildID < next[b].GuildID })
+		resumeSaves.Lock()
+		if err := saveJSON(resumeFileName(), next); err != nil {
+			log.Printf("[drain] couldn't save playback state: %v", err)
+		}
+		resumeSaves.Unlock()
+	}
+	if active == 0 {
+		return
+	}
+
+	log.Printf("[drain] letting %d session(s) finish their track (up to %s); signal again to stop now", active, drainTimeout)
+	abort := make(chan os.Signal, 1)
+	signal.Notify(abort, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(abort)
+	start := time.Now()
+	if waitPlaybacks(drainTimeout, abort) {
+		log.Printf("[drain] all sessions finished after %s", time.Since(start).Round(time.Second))
+	} else {
+		log.Printf("[drain] stopping the remaining sessions after %s", time.Since(start).Round(time.Second))
+	}
+}
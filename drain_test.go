@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// shutdownForTest undoes drainPlayback and stopNewPlayback after the test.
+func shutdownForTest(t *testing.T) {
+	t.Helper()
+	oldResume := resumeOnStart
+	resumeOnStart = true
+	t.Cleanup(func() {
+		resumeOnStart = oldResume
+		draining.Store(false)
+		shuttingDown.Store(false)
+	})
+}
+
+// A paused session has no track to finish, so the drain stops it, but its
+// track and position are saved like any other, followed by its queue.
+func TestDrainSavesPausedSessions(t *testing.T) {
+	testLibrary(t)
+	shutdownForTest(t)
+	gp := &guildPlayback{guildID: "1", channelID: "42", playing: "a.mp3", trackStart: 75, paused: true, queue: []string{"b.mp3", "c.mp3"}}
+	playSessions.Store("1", gp)
+	t.Cleanup(func() { playSessions.Delete("1") })
+
+	drainPlayback()
+
+	var saved []resumeEntry
+	if err := loadJSON(resumeFileName(), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("saved %+v, want the paused session", saved)
+	}
+	e := saved[0]
+	if e.GuildID != "1" || e.ChannelID != "42" || e.Playing != "a.mp3" || e.PositionSec != 75 || !slices.Equal(e.Queue, []string{"b.mp3", "c.mp3"}) {
+		t.Errorf("saved %+v, want a.mp3 at 75s then b.mp3, c.mp3", e)
+	}
+	if !gp.stopped {
+		t.Error("the paused session wasn't stopped")
+	}
+}
+
+// Once shutdown has begun no lifecycle starts, so the wait for them can't
+// race a new one.
+func TestStartLifecycleAfterShutdown(t *testing.T) {
+	shutdownForTest(t)
+	gp := &guildPlayback{guildID: "1"}
+	stopNewPlayback()
+	if startLifecycle(gp) {
+		playbacks.Done()
+		playSessions.Delete("1")
+		t.Fatal("a lifecycle started after shutdown began")
+	}
+	if _, ok := playSessions.Load("1"); ok {
+		t.Error("the refused session was stored")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+const (
+	encodeAttempts     = 3
+	encodeRetryBackoff = 250 * time.Millisecond
+	encodeStartTimeout = 15 * time.Second // first frame; URLs can be slow to open
+)
+
+// errEncoderNotStarted means ffmpeg exited without output or diagnostics,
+// which is what a failed process spawn (e.g. EAGAIN on a busy host) looks like.
+var errEncoderNotStarted = errors.New("ffmpeg produced no audio and no error output")
+
+// trackSource is what the playback loop consumes: Opus frames plus a way to
+// stop whatever is producing them.
+type trackSource interface {
+	dca.OpusReader
+	Cleanup()
+}
+
+// primedEncoder is an encode session whose first frame has already been read,
+// proving ffmpeg actually started. It replays that frame before the rest.
+type primedEncoder struct {
+	*dca.EncodeSession
+	first []byte
+}
+
+func (p *primedEncoder) OpusFrame() ([]byte, error) {
+	if p.first != nil {
+		frame := p.first
+		p.first = nil
+		return frame, nil
+	}
+	return p.EncodeSession.OpusFrame()
+}
+
+// encoderCrash reports how the ffmpeg behind src failed, with its stderr, once
+// the source has returned io.EOF. EOF alone doesn't tell a crash from the end
+// of the file: dca closes its frames either way and only records the exit
+// error. It returns nil for a clean exit or a source that can't tell.
+func encoderCrash(src trackSource) (stderr string, err error) {
+	if s, ok := src.(*slotSource); ok {
+		src = s.trackSource
+	}
+	if f, ok := src.(interface {
+		Error() error
+		FFMPEGMessages() string
+	}); ok {
+		if err := f.Error(); err != nil {
+			return strings.TrimSpace(f.FFMPEGMessages()), err
+		}
+	}
+	return "", nil
+}
+
+// encodeOptions returns a fresh copy of the configured encoder options.
+func encodeOptions() *dca.EncodeOptions {
+	opts := *dca.StdEncodeOptions
+	opts.RawOutput = false // <-- THE FIX: Let dca handle Opus encoding.
+	opts.Bitrate = 128     // kbps
+	opts.FrameDuration = frameDuration
+	opts.BufferedFrames = bufferedFrames
+	opts.PacketLoss = packetLoss
+	opts.Channels = audioChannels
+	//opts.Volume = 256      // This is the default volume, good to have explicitly.
+	return &opts
+}
+
+// trackOptions are per-playback choices applied when a track is encoded.
+type trackOptions struct {
+	eq    string // equalizer profile
+	night string // night mode filter chain ("" = off)
+	start int    // seconds to skip, when resuming mid-track
+	pitch pitchShift
+
+	bitrate int // kbps, capped for the guild's boost level (0 = encodeOptions' own)
+}
+
+// applyBitrate lowers opts.Bitrate to to.bitrate, reporting whether it did.
+func (to trackOptions) applyBitrate(opts *dca.EncodeOptions) bool {
+	if to.bitrate <= 0 || to.bitrate >= opts.Bitrate {
+		return false
+	}
+	opts.Bitrate = to.bitrate
+	return true
+}
+
+// encodeTrack starts an ffmpeg/dca encode session for a single track, retrying
+// transient start failures with backoff. Permanent failures (missing file,
+// invalid options, ffmpeg rejecting the input) are returned immediately.
+func encodeTrack(filePath string, to trackOptions) (trackSource, error) {
+	backoff := encodeRetryBackoff
+	for attempt := 1; ; attempt++ {
+		enc, err := startEncode(filePath, to)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("[encodeTrack] encoder started on attempt %d for %s", attempt, filePath)
+			}
+			return enc, nil
+		}
+		// A reader can't be rewound, so it gets only the one attempt.
+		if !retryableEncodeErr(err) || isReaderTrack(filePath) || attempt == encodeAttempts {
+			log.Printf("[encodeTrack] giving up on %s after %d attempt(s): %v", filePath, attempt, err)
+			return nil, err
+		}
+		log.Printf("[encodeTrack] attempt %d/%d for %s failed, retrying in %s: %v", attempt, encodeAttempts, filePath, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// startEncode makes a single encode attempt. dca only logs ffmpeg spawn
+// failures, so we wait for the first frame to know the encoder is really running.
+func startEncode(filePath string, to trackOptions) (trackSource, error) {
+	if isReaderTrack(filePath) {
+		return startReaderEncode(filePath, to)
+	}
+	if !isURL(filePath) {
+		if _, err := os.Stat(filePath); err != nil {
+			return nil, fmt.Errorf("file not accessible: %w", err)
+		}
+	}
+
+	opts := encodeOptions()
+	meta := loadTrackMeta(filePath)
+	opts.Volume = trackVolume(filePath, meta, opts.Volume)
+	trim := trimSilence && !isURL(filePath)
+	if meta.TrimSilence != nil {
+		trim = *meta.TrimSilence
+	}
+
+	opts.AudioFilter = audioFilter(filePath, opts.Volume, to, trim)
+	opts.StartTime = to.start
+	capped := to.applyBitrate(opts)
+	// Probe before taking a process slot; probes need one of their own. The
+	// remux can't seek or re-compress, so a resumed track, or one for a
+	// server whose bitrate is capped, is always transcoded.
+	passthrough := opusPassthrough && to.start == 0 && !capped && passthroughOK(filePath, opts)
+
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return nil, err
+	}
+	src, err := spawnEncode(filePath, opts, passthrough)
+	if err != nil {
+		releaseFFmpeg()
+		return nil, err
+	}
+	return &slotSource{trackSource: src}, nil
+}
+
+// maxVolume is the loudest volume dca accepts; EncodeFile rejects more.
+const maxVolume = 2
+
+// trackVolume applies the sidecar volume and, with NORMALIZE_LOUDNESS, the
+// measured loudness gain to the base volume, capped at maxVolume.
+func trackVolume(filePath string, meta trackMeta, volume float32) float32 {
+	if meta.Volume != nil {
+		volume = float32(*meta.Volume)
+		log.Printf("[encodeTrack] sidecar volume %.2f for %s", *meta.Volume, filePath)
+	}
+	if normalizeLoudness && !isURL(filePath) {
+		if gain, ok := trackGain(filePath); ok {
+			volume *= float32(gain)
+			log.Printf("[encodeTrack] loudness gain %.2f for %s", gain, filePath)
+		}
+	}
+	return min(volume, maxVolume)
+}
+
+// spawnEncode starts the ffmpeg process for a file: a remux when passthrough
+// is allowed and works, otherwise a dca transcode.
+func spawnEncode(filePath string, opts *dca.EncodeOptions, passthrough bool) (trackSource, error) {
+	if passthrough {
+		src, err := startPassthrough(filePath)
+		if err == nil {
+			log.Printf("[encodeTrack] passing through opus from %s (%s packets)", filePath, src.FrameDuration())
+			return src, nil
+		}
+		log.Printf("[encodeTrack] passthrough failed for %s, transcoding instead: %v", filePath, err)
+	}
+
+	log.Printf("[encodeTrack] starting encoder for file %s", filePath)
+	enc, err := dca.EncodeFile(filePath, opts)
+	if err != nil {
+		log.Printf("[encodeTrack] EncodeFile error: %v", err)
+		return nil, fmt.Errorf("failed to start ffmpeg/dca encode for %q: %w", filePath, err)
+	}
+	return primeEncoder(enc, filePath)
+}
+
+// primeEncoder waits for a new encode session's first frame, so a dead ffmpeg
+// is reported as an error instead of an empty track.
+func primeEncoder(enc *dca.EncodeSession, filePath string) (*primedEncoder, error) {
+	type result struct {
+		frame []byte
+		err   error
+	}
+	firstc := make(chan result, 1)
+	go func() {
+		frame, err := enc.OpusFrame()
+		firstc <- result{frame, err}
+	}()
+
+	select {
+	case res := <-firstc:
+		if res.err == nil {
+			log.Printf("[encodeTrack] encoder started successfully")
+			return &primedEncoder{EncodeSession: enc, first: res.frame}, nil
+		}
+		enc.Cleanup()
+		msgs := strings.TrimSpace(enc.FFMPEGMessages())
+		if ferr := enc.Error(); ferr != nil || msgs != "" {
+			return nil, fmt.Errorf("ffmpeg failed on %q: %v; stderr:\n%s", filePath, ferr, msgs)
+		}
+		return nil, fmt.Errorf("encode %q: %w", filePath, errEncoderNotStarted)
+	case <-time.After(encodeStartTimeout):
+		enc.Cleanup()
+		return nil, fmt.Errorf("encode %q: no audio after %s", filePath, encodeStartTimeout)
+	}
+}
+
+// silenceTrim strips silence below -50dB from the start, and any stretch of
+// it longer than a second after that, which covers a clip's trailing silence.
+const silenceTrim = "silenceremove=start_periods=1:start_threshold=-50dB:stop_periods=-1:stop_duration=1:stop_threshold=-50dB"
+
+// audioFilter builds the ffmpeg filter chain for silence trimming, the eq
+// profile, night mode, /pitch and FADE_IN_MS/FADE_OUT_MS, or "" when none apply. The
+// fade-out needs the track length, so it's skipped for sources ffprobe can't
+// time (e.g. live streams) and for trimmed tracks, whose length after trimming
+// isn't known.
+func audioFilter(filePath string, volume float32, to trackOptions, trim bool) string {
+	var filters []string
+	if trim {
+		filters = append(filters, silenceTrim)
+	}
+	if chain := eqPresets[to.eq]; chain != "" {
+		filters = append(filters, chain)
+	}
+	if to.night != "" {
+		filters = append(filters, to.night)
+	}
+	if chain := to.pitch.filter(); chain != "" {
+		filters = append(filters, chain)
+	}
+	if fadeInMS > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:d=%.3f", float64(fadeInMS)/1000))
+	}
+	if fadeOutMS > 0 && !trim {
+		fade := time.Duration(fadeOutMS) * time.Millisecond
+		if length := probeDuration(filePath); length > 2*fade {
+			filters = append(filters, fmt.Sprintf("afade=t=out:st=%.3f:d=%.3f", (length-fade).Seconds(), fade.Seconds()))
+		}
+	}
+	if len(filters) == 0 {
+		return ""
+	}
+	// -af replaces dca's own volume filter, so carry the volume along.
+	return fmt.Sprintf("volume=%.2f,%s", volume, strings.Join(filters, ","))
+}
+
+// probeDuration returns the length of a media file, or 0 if it's unknown.
+func probeDuration(filePath string) time.Duration {
+	if acquireFFmpeg(ffmpegSlotWait) != nil {
+		return 0
+	}
+	defer releaseFFmpeg()
+	out, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		filePath,
+	).Output()
+	if err != nil {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func retryableEncodeErr(err error) bool {
+	if errors.Is(err, errFFmpegBusy) {
+		return false // acquireFFmpeg already waited
+	}
+	if errors.Is(err, errEncoderNotStarted) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "resource temporarily unavailable") ||
+		strings.Contains(msg, "cannot allocate memory") ||
+		strings.Contains(msg, "too many open files")
+}
+
+// prefetchedTrack is the next queued track, encoding in the background so it
+// can take over from the current one without the ffmpeg startup gap.
+type prefetchedTrack struct {
+	path    string
+	opts    trackOptions // what it was encoded with
+	done    chan struct{}
+	src     trackSource
+	err     error
+	startup time.Duration // how long the encoder took to produce its first frame
+}
+
+func prefetchTrack(filePath string, to trackOptions) *prefetchedTrack {
+	p := &prefetchedTrack{path: filePath, opts: to, done: make(chan struct{})}
+	go func() {
+		start := time.Now()
+		p.src, p.err = encodeTrack(filePath, to)
+		p.startup = time.Since(start)
+		close(p.done)
+	}()
+	return p
+}
+
+// wait blocks until the encoder has started (or failed).
+func (p *prefetchedTrack) wait() (trackSource, error) {
+	<-p.done
+	return p.src, p.err
+}
+
+// discard cleans up an unused prefetch once it has settled. Safe on nil.
+func (p *prefetchedTrack) discard() {
+	if p == nil {
+		return
+	}
+	go func() {
+		if src, err := p.wait(); err == nil {
+			src.Cleanup()
+		}
+	}()
+}
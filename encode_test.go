@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeFFprobe puts an ffprobe on PATH that reports every file as secs long
+// ("" = unknown, as for a live stream). ffmpeg itself isn't on PATH.
+func fakeFFprobe(t *testing.T, secs string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffprobe is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\n"
+	if secs != "" {
+		script += "echo " + secs + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func setFades(t *testing.T, in, out int) {
+	t.Helper()
+	oldIn, oldOut := fadeInMS, fadeOutMS
+	fadeInMS, fadeOutMS = in, out
+	t.Cleanup(func() { fadeInMS, fadeOutMS = oldIn, oldOut })
+}
+
+func TestAudioFilter(t *testing.T) {
+	const (
+		bass  = "equalizer=f=60:t=q:w=1:g=6,equalizer=f=150:t=q:w=1:g=3"
+		night = nightCompressor + ",volume=0.50"
+		up    = "aresample=48000,asetrate=96000,aresample=48000"
+	)
+	for _, tc := range []struct {
+		name     string
+		volume   float32
+		to       trackOptions
+		trim     bool
+		fadeIn   int
+		fadeOut  int
+		duration string // what ffprobe reports
+		want     string
+	}{
+		{name: "nothing to do", volume: 1, want: ""},
+		{name: "volume alone is left to dca", volume: 1.5, want: ""},
+		{name: "flat eq", volume: 1, to: trackOptions{eq: "flat"}, want: ""},
+		{name: "trim", volume: 1, trim: true, want: "volume=1.00," + silenceTrim},
+		{name: "eq", volume: 1, to: trackOptions{eq: "bassboost"}, want: "volume=1.00," + bass},
+		{name: "night mode", volume: 1, to: trackOptions{night: night}, want: "volume=1.00," + night},
+		{name: "pitch", volume: 1, to: trackOptions{pitch: pitchShift{semitones: 12}}, want: "volume=1.00," + up},
+		{name: "fade in", volume: 1, fadeIn: 500, want: "volume=1.00,afade=t=in:d=0.500"},
+		{name: "fade out", volume: 1, fadeOut: 2000, duration: "10.0", want: "volume=1.00,afade=t=out:st=8.000:d=2.000"},
+		{name: "fade out, unknown length", volume: 1, fadeOut: 2000, want: ""},
+		{name: "fade out, track too short", volume: 1, fadeOut: 2000, duration: "3.5", want: ""},
+		{name: "fade out, trimmed", volume: 1, trim: true, fadeOut: 2000, duration: "10.0", want: "volume=1.00," + silenceTrim},
+		{name: "volume carried", volume: 0.25, fadeIn: 1000, want: "volume=0.25,afade=t=in:d=1.000"},
+		{
+			name:     "everything, in order",
+			volume:   0.8,
+			to:       trackOptions{eq: "bassboost", night: night, pitch: pitchShift{semitones: 12}},
+			fadeIn:   250,
+			fadeOut:  1000,
+			duration: "60",
+			want:     "volume=0.80," + bass + "," + night + "," + up + ",afade=t=in:d=0.250,afade=t=out:st=59.000:d=1.000",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeFFprobe(t, tc.duration)
+			setFades(t, tc.fadeIn, tc.fadeOut)
+			if got := audioFilter("track.mp3", tc.volume, tc.to, tc.trim); got != tc.want {
+				t.Errorf("got  %q\nwant %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Keeping the tempo uses rubberband when ffmpeg has it, else undoes the
+// resample's speed change with atempo.
+func TestAudioFilterPitchKeepTempo(t *testing.T) {
+	fakeFFprobe(t, "")
+	setFades(t, 0, 0)
+	got := audioFilter("track.mp3", 1, trackOptions{pitch: pitchShift{semitones: 12, keepTempo: true}}, false)
+	want := "volume=1.00,aresample=48000,asetrate=96000,aresample=48000,atempo=0.500000"
+	if rubberband() {
+		want = "volume=1.00,rubberband=pitch=2.000000"
+	}
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const eqFile = "eq.json"
+
+// eqPresets are the /eq profiles as ffmpeg filter chains; "" plays unchanged.
+var eqPresets = map[string]string{
+	"flat":        "",
+	"bassboost":   "equalizer=f=60:t=q:w=1:g=6,equalizer=f=150:t=q:w=1:g=3",
+	"trebleboost": "equalizer=f=6000:t=q:w=1:g=4,equalizer=f=12000:t=q:w=1:g=5",
+	"vocal":       "equalizer=f=250:t=q:w=1:g=-3,equalizer=f=3000:t=q:w=1.5:g=4",
+}
+
+var eqLabels = map[string]string{
+	"flat":        "Flat",
+	"bassboost":   "Bass boost",
+	"trebleboost": "Treble boost",
+	"vocal":       "Vocal",
+}
+
+// guildEQ holds each guild's chosen profile, persisted in DATA_DIR. Guilds
+// without an entry play flat.
+var guildEQ = struct {
+	sync.Mutex
+	profile map[string]string
+}{profile: make(map[string]string)}
+
+func loadGuildEQ() {
+	guildEQ.Lock()
+	defer guildEQ.Unlock()
+	if err := loadJSON(shardFileName(eqFile), &guildEQ.profile); err != nil {
+		log.Printf("[eq] couldn't load equalizer settings: %v", err)
+	}
+	if guildEQ.profile == nil {
+		guildEQ.profile = make(map[string]string)
+	}
+}
+
+// eqProfile returns the guild's profile name ("flat" by default).
+func eqProfile(guildID string) string {
+	guildEQ.Lock()
+	defer guildEQ.Unlock()
+	if p, ok := guildEQ.profile[guildID]; ok {
+		return p
+	}
+	return "flat"
+}
+
+func handleEQCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	opts := i.ApplicationCommandData().Options
+	if len(opts) == 0 {
+		msg := fmt.Sprintf("Equalizer: **%s**. Use /eq profile:<name> to change it.", eqLabels[eqProfile(i.GuildID)])
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+		return
+	}
+	profile := opts[0].StringValue()
+	if _, ok := eqPresets[profile]; !ok {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Unknown profile %q.", profile)))
+		return
+	}
+
+	guildEQ.Lock()
+	if profile == "flat" {
+		delete(guildEQ.profile, i.GuildID)
+	} else {
+		guildEQ.profile[i.GuildID] = profile
+	}
+	if err := saveJSON(shardFileName(eqFile), guildEQ.profile); err != nil {
+		log.Printf("[eq] couldn't save equalizer settings: %v", err)
+	}
+	guildEQ.Unlock()
+	log.Printf("[eq] guild=%s profile=%s", i.GuildID, profile)
+
+	msg := fmt.Sprintf("Equalizer set to **%s**.", eqLabels[profile])
+	if val, ok := playSessions.Load(i.GuildID); ok {
+		gp := val.(*guildPlayback)
+		gp.mu.Lock()
+		gp.eq = profile
+		gp.mu.Unlock()
+		msg += " It applies from the next track."
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+func eqCommand() *discordgo.ApplicationCommand {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, name := range []string{"flat", "bassboost", "trebleboost", "vocal"} {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: eqLabels[name], Value: name})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:        "eq",
+		Description: "Show or set this server's equalizer profile",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "profile", Description: "Equalizer preset", Choices: choices},
+		},
+	}
+}
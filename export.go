@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// exportMaxSize is Discord's attachment limit for servers without a boost.
+const exportMaxSize = 10 << 20
+
+func exportCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:                     "export",
+		Description:              "Download a listing of the whole library",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "format",
+				Description: "File format (default CSV)",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "CSV", Value: "csv"},
+					{Name: "Text", Value: "txt"},
+				},
+			},
+		},
+	}
+}
+
+// handleExportCommand attaches a listing of every file in the library, with
+// its duration, size and tags. Durations and tags not yet known are probed,
+// which can take a while on a big library, so it answers with a deferred
+// response. The listing is written to a temp file and attached from there.
+func handleExportCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	format := "csv"
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "format" {
+			format = opt.StringValue()
+		}
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	reply := func(content string, files []*discordgo.File) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content, Files: files}); err != nil {
+			log.Printf("[export] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		start := time.Now()
+		files, index, err := scanLibrary(soundsDir, effectiveExts(i.GuildID))
+		if err != nil {
+			reply(scanErrorMessage(err), nil)
+			return
+		}
+		if len(files) == 0 {
+			reply("No audio files found in "+soundsDir, nil)
+			return
+		}
+		tags := libraryTags(files, index)
+		durations := libraryDurations(files)
+
+		tmp, err := createTemp("export-*." + format)
+		if err != nil {
+			log.Printf("[export] %v", err)
+			reply("Couldn't create the export file.", nil)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		w := bufio.NewWriter(tmp)
+		if format == "csv" {
+			err = writeExportCSV(w, files, index, durations, tags)
+		} else {
+			err = writeExportText(w, files, index, durations, tags)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		var info os.FileInfo
+		if err == nil {
+			info, err = tmp.Stat()
+		}
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			log.Printf("[export] writing %s: %v", tmp.Name(), err)
+			reply("Couldn't write the export file.", nil)
+			return
+		}
+		size := info.Size()
+		if size > exportMaxSize {
+			reply(fmt.Sprintf("The listing is %s, over Discord's %s attachment limit. Try the text format, or read the library on the host.", formatBytes(size), formatBytes(exportMaxSize)), nil)
+			return
+		}
+
+		log.Printf("[export] user=%s guild=%s: %d file(s), %s, in %s", interactionUserID(i), i.GuildID, len(files), formatBytes(size), time.Since(start).Round(time.Millisecond))
+		name := "library-" + time.Now().Format("2006-01-02") + "." + format
+		reply(fmt.Sprintf("Library listing: %d file(s).", len(files)), []*discordgo.File{
+			{Name: name, ContentType: exportContentType(format), Reader: tmp},
+		})
+	}()
+}
+
+// libraryDurations looks up the duration of each file, probing uncached ones
+// tagWorkers at a time. Playlists and files ffprobe can't read get 0.
+func libraryDurations(files []string) map[string]time.Duration {
+	out := make(map[string]time.Duration, len(files))
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range min(tagWorkers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				d := cachedDuration(filepath.Join(soundsDir, rel))
+				mu.Lock()
+				out[rel] = d
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, rel := range files {
+		if !isPlaylist(rel) {
+			jobs <- rel
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return out
+}
+
+func writeExportCSV(w *bufio.Writer, files []string, index map[string]audioFile, durations map[string]time.Duration, tags map[string]map[string]string) error {
+	cw := csv.NewWriter(w)
+	header := append([]string{"path", "duration_seconds", "size_bytes", "modified"}, tagFields...)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, rel := range files {
+		duration := ""
+		if d := durations[rel]; d > 0 {
+			duration = strconv.FormatFloat(d.Seconds(), 'f', 1, 64)
+		}
+		row := []string{rel, duration, strconv.FormatInt(index[rel].Size, 10), index[rel].ModTime.UTC().Format(time.RFC3339)}
+		for _, field := range tagFields {
+			row = append(row, tags[rel][field])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportText(w *bufio.Writer, files []string, index map[string]audioFile, durations map[string]time.Duration, tags map[string]map[string]string) error {
+	for _, rel := range files {
+		details := []string{formatBytes(index[rel].Size)}
+		if d := durations[rel]; d > 0 {
+			details = append([]string{formatPosition(d)}, details...)
+		}
+		var named []string
+		for _, field := range tagFields {
+			if v := tags[rel][field]; v != "" {
+				named = append(named, field+": "+v)
+			}
+		}
+		line := fmt.Sprintf("%s (%s)", rel, strings.Join(details, ", "))
+		if len(named) > 0 {
+			line += " [" + strings.Join(named, "; ") + "]"
+		}
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportContentType(format string) string {
+	if format == "csv" {
+		return "text/csv"
+	}
+	return "text/plain"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const extensionsFile = "extensions.json"
+
+// extSet is a set of lower-case file extensions with the leading dot, e.g. ".mp3".
+type extSet map[string]struct{}
+
+func (e extSet) String() string {
+	list := make([]string, 0, len(e))
+	for ext := range e {
+		list = append(list, ext)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// allows reports whether name has one of the set's extensions.
+func (e extSet) allows(name string) bool {
+	_, ok := e[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// guildExts holds per-guild overrides of allowedExts, persisted in DATA_DIR
+// as guild → extensions.
+var guildExts = struct {
+	sync.Mutex
+	byGuild map[string][]string
+}{byGuild: make(map[string][]string)}
+
+var extPattern = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// parseExtList parses a comma- or space-separated list such as "mp3, .wav".
+func parseExtList(s string) (extSet, error) {
+	set := extSet{}
+	for _, f := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ',' || r == ' ' }) {
+		ext := "." + strings.TrimPrefix(f, ".")
+		if !extPattern.MatchString(ext) {
+			return nil, fmt.Errorf("%q is not a file extension", f)
+		}
+		if _, ok := playlistExts[ext]; ok {
+			return nil, fmt.Errorf("%s is a playlist format; playlists are always listed", ext)
+		}
+		set[ext] = struct{}{}
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("no extensions given")
+	}
+	return set, nil
+}
+
+// effectiveExts is the audio extension set for a guild: its override if an
+// admin set one, else the global allowedExts.
+func effectiveExts(guildID string) extSet {
+	guildExts.Lock()
+	defer guildExts.Unlock()
+	list, ok := guildExts.byGuild[guildID]
+	if !ok {
+		return allowedExts
+	}
+	set := make(extSet, len(list))
+	for _, ext := range list {
+		set[ext] = struct{}{}
+	}
+	return set
+}
+
+func loadGuildExts() {
+	guildExts.Lock()
+	defer guildExts.Unlock()
+	if err := loadJSON(shardFileName(extensionsFile), &guildExts.byGuild); err != nil {
+		log.Printf("[extensions] couldn't load per-guild extensions: %v", err)
+	}
+	if guildExts.byGuild == nil {
+		guildExts.byGuild = make(map[string][]string)
+	}
+}
+
+func saveGuildExtsLocked() {
+	if err := saveJSON(shardFileName(extensionsFile), guildExts.byGuild); err != nil {
+		log.Printf("[extensions] couldn't save per-guild extensions: %v", err)
+	}
+}
+
+func handleExtensionsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+
+	switch sub.Name {
+	case "set":
+		set, err := parseExtList(sub.Options[0].StringValue())
+		if err != nil {
+			logRespondErr(i, respondPrivate(s, i, "Invalid extensions: "+err.Error()))
+			return
+		}
+		list := make([]string, 0, len(set))
+		for ext := range set {
+			list = append(list, ext)
+		}
+		sort.Strings(list)
+		guildExts.Lock()
+		guildExts.byGuild[i.GuildID] = list
+		saveGuildExtsLocked()
+		guildExts.Unlock()
+		log.Printf("[extensions] guild=%s now lists %s", i.GuildID, set)
+		logRespondErr(i, respondEphemeral(s, i, "This server now lists: "+set.String(), nil))
+	case "reset":
+		guildExts.Lock()
+		delete(guildExts.byGuild, i.GuildID)
+		saveGuildExtsLocked()
+		guildExts.Unlock()
+		logRespondErr(i, respondEphemeral(s, i, "Back to the bot's default file types: "+allowedExts.String(), nil))
+	case "show":
+		msg := "This server uses the bot's default file types: " + allowedExts.String()
+		guildExts.Lock()
+		_, custom := guildExts.byGuild[i.GuildID]
+		guildExts.Unlock()
+		if custom {
+			msg = "This server lists: " + effectiveExts(i.GuildID).String()
+		}
+		logRespondErr(i, respondEphemeral(s, i, msg, nil))
+	}
+}
+
+func extensionsCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:                     "extensions",
+		Description:              "Choose which file types this server's sound menus list",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "List only these file types in this server",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "types", Description: "Comma-separated extensions, e.g. mp3, wav", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Go back to the bot's default file types",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "show",
+				Description: "Show which file types this server lists",
+			},
+		},
+	}
+}
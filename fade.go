@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+const (
+	stopFadeDuration = 500 * time.Millisecond
+	// stopFadeTimeout bounds the whole fade, encoder start included; past it
+	// the stop is immediate. /stop replies afterwards, within Discord's 3s.
+	stopFadeTimeout = 2 * time.Second
+	// fadeReleaseWait is how long a timed-out fade gives the streamer to let
+	// go of the fade source before it's killed.
+	fadeReleaseWait = 200 * time.Millisecond
+)
+
+// fadeStop stops playback like stop, but with FADE_OUT_STOP it first plays a
+// short fade-out from the current position so listeners don't hear a hard
+// cut. The streamer can't change the volume of Opus frames it only forwards,
+// so the fade is a fresh ffmpeg encode of the next half second, swapped in
+// for the rest of the track. Anything it can't fade (paused, legacy stream,
+// live or uploaded sources, no free ffmpeg slot) stops immediately.
+func (gp *guildPlayback) fadeStop() {
+	if !fadeOutStop {
+		gp.stop()
+		return
+	}
+	gp.mu.Lock()
+	st, filePath := gp.streamer, gp.playing
+	to := trackOptions{eq: gp.eq, night: gp.night, pitch: gp.pitch, bitrate: gp.bitrate}
+	pos := gp.elapsedLocked()
+	ok := st != nil && !gp.paused && !gp.stopped && filePath != "" && !isURL(filePath) && !isReaderTrack(filePath)
+	gp.mu.Unlock()
+	if !ok {
+		gp.stop()
+		return
+	}
+
+	start := time.Now()
+	fade, err := startFadeOut(filePath, pos, to)
+	if err != nil {
+		log.Printf("[fade] guild=%s: stopping without a fade: %v", gp.guildID, err)
+		gp.stop()
+		return
+	}
+
+	gp.mu.Lock()
+	if gp.stopped || gp.streamer != st {
+		gp.mu.Unlock()
+		fade.Cleanup()
+		gp.stop()
+		return
+	}
+	// The track kept playing while ffmpeg started; skip the part of the fade
+	// listeners have already heard.
+	behind := max(0, gp.elapsedLocked()-pos)
+	// Marked stopped now so the lifecycle ends, rather than moving on to the
+	// next track, when the fade runs out.
+	gp.stopped = true
+	st.swap <- &skipFrames{OpusReader: fade, n: int(behind / st.frame)}
+	gp.mu.Unlock()
+
+	select {
+	case <-st.done:
+		log.Printf("[fade] guild=%s: faded out in %s (%s in)", gp.guildID, time.Since(start).Round(time.Millisecond), behind.Round(time.Millisecond))
+	case <-time.After(stopFadeTimeout - time.Since(start)):
+		log.Printf("[fade] guild=%s: fade-out timed out, stopping", gp.guildID)
+		gp.stop()
+		// Let the streamer drop the fade before killing it. One stuck reading
+		// the fade is only freed by the kill, so don't wait long.
+		select {
+		case <-st.done:
+		case <-time.After(fadeReleaseWait):
+		}
+	}
+	fade.Cleanup()
+	gp.stop()
+}
+
+// skipFrames drops the first n frames of a source.
+type skipFrames struct {
+	dca.OpusReader
+	n int
+}
+
+func (s *skipFrames) OpusFrame() ([]byte, error) {
+	for ; s.n > 0; s.n-- {
+		if _, err := s.OpusReader.OpusFrame(); err != nil {
+			return nil, err
+		}
+	}
+	return s.OpusReader.OpusFrame()
+}
+
+// startFadeOut encodes stopFadeDuration of filePath from pos, fading to
+// silence, with the volume and filters the track was playing with.
+func startFadeOut(filePath string, pos time.Duration, to trackOptions) (trackSource, error) {
+	if err := acquireFFmpeg(0); err != nil {
+		return nil, err
+	}
+	filter := fmt.Sprintf("volume=%.2f", trackVolume(filePath, loadTrackMeta(filePath), 1))
+	if chain := eqPresets[to.eq]; chain != "" {
+		filter += "," + chain
+	}
+	if to.night != "" {
+		filter += "," + to.night
+	}
+	if chain := to.pitch.filter(); chain != "" {
+		filter += "," + chain
+	}
+	filter += fmt.Sprintf(",afade=t=out:d=%.3f", stopFadeDuration.Seconds())
+	opts := encodeOptions()
+	to.applyBitrate(opts)
+	src, err := startOggOpus(filePath,
+		"-ss", fmt.Sprintf("%.3f", pos.Seconds()),
+		"-t", fmt.Sprintf("%.3f", stopFadeDuration.Seconds()),
+		"-i", filePath,
+		"-map", "0:a:0",
+		"-af", filter,
+		"-ar", "48000", "-ac", fmt.Sprint(audioChannels),
+		"-c:a", "libopus", "-b:a", fmt.Sprintf("%dk", opts.Bitrate),
+		"-frame_duration", fmt.Sprint(frameDuration),
+	)
+	if err != nil {
+		releaseFFmpeg()
+		return nil, err
+	}
+	return &slotSource{trackSource: src}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestSkipFrames(t *testing.T) {
+	for _, tc := range []struct{ frames, skip, want int }{
+		{5, 0, 5},
+		{5, 2, 3},
+		{5, 5, 0},
+		{5, 9, 0},
+	} {
+		src := &skipFrames{OpusReader: &fakeOpus{n: tc.frames}, n: tc.skip}
+		got := 0
+		for {
+			if _, err := src.OpusFrame(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got++
+		}
+		if got != tc.want {
+			t.Errorf("%d frames, skipping %d: got %d, want %d", tc.frames, tc.skip, got, tc.want)
+		}
+	}
+}
+
+// The track keeps playing while the fade's ffmpeg starts up. The fade picks
+// up where the track got to instead of repeating that stretch, and it's only
+// cleaned up once the streamer is done with it.
+func TestFadeStopSkipsWhatWasHeard(t *testing.T) {
+	testLibrary(t, "a.mp3")
+	old := fadeOutStop
+	fadeOutStop = true
+	t.Cleanup(func() { fadeOutStop = old })
+	// A 1s fade from a 200 ms slow ffmpeg.
+	fakeFFmpeg(t, 50, `sleep 0.2; cat "$STREAM"`)
+
+	vc := &discordgo.VoiceConnection{OpusSend: make(chan []byte)}
+	st := newStreamer(&fakeOpus{n: 100000}, vc)
+	gp := &guildPlayback{guildID: "1", playing: soundsDir + "/a.mp3", streamer: st}
+	go func() { _ = st.run() }()
+
+	// Take frames at the pace discordgo sends them, counting the fade's,
+	// which are the 320-byte packets.
+	fadeFrames := make(chan int, 1)
+	go func() {
+		n := 0
+		tick := time.NewTicker(20 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case f := <-vc.OpusSend:
+				if len(f) == 320 {
+					n++
+				}
+			case <-st.done:
+				fadeFrames <- n
+				return
+			}
+			<-tick.C
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	gp.fadeStop()
+	select {
+	case <-st.done:
+	default:
+		t.Fatal("fadeStop returned before the streamer stopped")
+	}
+	// About 10 frames (200 ms) were played from the track during startup.
+	if n := <-fadeFrames; n < 20 || n > 42 {
+		t.Errorf("sent %d of the fade's 50 frames, want about 40", n)
+	}
+	if !gp.stopped {
+		t.Error("the session wasn't stopped")
+	}
+}
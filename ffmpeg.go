@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ffmpegSlots caps how many ffmpeg/ffprobe processes run at once across all
+// guilds (MAX_FFMPEG_PROCS); nil means no cap. An encoder holds its slot for
+// the whole track, a probe only while it runs.
+var ffmpegSlots chan struct{}
+
+// ffmpegSlotWait is how long a request queues for a slot before giving up.
+const ffmpegSlotWait = 5 * time.Second
+
+var errFFmpegBusy = errors.New("bot is busy, try again in a moment")
+
+// acquireFFmpeg takes a process slot, waiting up to wait (forever if wait < 0).
+// Every successful call must be paired with releaseFFmpeg.
+func acquireFFmpeg(wait time.Duration) error {
+	if ffmpegSlots == nil {
+		return nil
+	}
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return nil
+	default:
+	}
+	if wait < 0 {
+		ffmpegSlots <- struct{}{}
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		log.Printf("[ffmpeg] all %d process slots busy for %s; rejecting", cap(ffmpegSlots), wait)
+		return errFFmpegBusy
+	}
+}
+
+func releaseFFmpeg() {
+	if ffmpegSlots != nil {
+		<-ffmpegSlots
+	}
+}
+
+// slotSource holds a process slot for as long as its encoder runs.
+type slotSource struct {
+	trackSource
+	release sync.Once
+}
+
+func (s *slotSource) Cleanup() {
+	s.trackSource.Cleanup()
+	s.release.Do(releaseFFmpeg)
+}
+
+// configureFFmpeg makes FFMPEG_PATH the "ffmpeg" every exec.Command (ours and
+// dca's, which hardcodes the name) resolves to, by putting it first on PATH.
+// A binary with another name (ffmpeg-6, ...) is linked as "ffmpeg" from a temp
+// dir. Its own directory goes on PATH too, so a bundled ffprobe is found.
+func configureFFmpeg(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("FFMPEG_PATH: %w", err)
+	}
+	if info.IsDir() || (runtime.GOOS != "windows" && info.Mode()&0o111 == 0) {
+		return fmt.Errorf("FFMPEG_PATH %s is not an executable file", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("FFMPEG_PATH: %w", err)
+	}
+
+	dirs := []string{filepath.Dir(abs)}
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(abs)), ".exe")
+	if name != "ffmpeg" {
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("FFMPEG_PATH must point at a file named ffmpeg.exe on Windows")
+		}
+		tmp, err := os.MkdirTemp("", "tunetalk-ffmpeg")
+		if err != nil {
+			return fmt.Errorf("FFMPEG_PATH: %w", err)
+		}
+		if err := os.Symlink(abs, filepath.Join(tmp, "ffmpeg")); err != nil {
+			return fmt.Errorf("FFMPEG_PATH: %w", err)
+		}
+		dirs = append([]string{tmp}, dirs...)
+	}
+
+	dirs = append(dirs, os.Getenv("PATH"))
+	if err := os.Setenv("PATH", strings.Join(dirs, string(os.PathListSeparator))); err != nil {
+		return fmt.Errorf("FFMPEG_PATH: %w", err)
+	}
+	log.Printf("Using ffmpeg at %s", abs)
+	return nil
+}
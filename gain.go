@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	gainsFile = "gains.json"
+
+	// gainScanInterval is how often the background job looks for new or
+	// changed files to measure.
+	gainScanInterval = 30 * time.Minute
+
+	// Keep corrections within what dca's volume filter handles cleanly.
+	minGain = 0.1
+	maxGain = 2.0
+)
+
+// gainEntry is a file's measured integrated loudness, valid while the file's
+// size and mod time are unchanged.
+type gainEntry struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	LUFS    float64   `json:"lufs"`
+}
+
+// gains caches loudness per full file path, persisted in DATA_DIR so files
+// are measured once, not on every play or restart.
+var gains = struct {
+	sync.Mutex
+	entries map[string]gainEntry
+	pending map[string]bool // queued for measuring
+	queue   chan string
+}{
+	entries: make(map[string]gainEntry),
+	pending: make(map[string]bool),
+	queue:   make(chan string, 256),
+}
+
+// trackGain returns the volume factor that brings filePath to LOUDNESS_TARGET.
+// ok is false if the file hasn't been measured yet (or changed since); it is
+// then queued, so the next play is normalized. Playback never waits for it.
+func trackGain(filePath string) (gain float64, ok bool) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 1, false
+	}
+	gains.Lock()
+	e, found := gains.entries[filePath]
+	gains.Unlock()
+	if !found || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		queueGain(filePath)
+		return 1, false
+	}
+	gain = math.Pow(10, (loudnessTarget-e.LUFS)/20)
+	return min(max(gain, minGain), maxGain), true
+}
+
+// queueGain schedules filePath for measuring unless it's already queued. It
+// drops the request if the queue is full; the next library scan catches up.
+// Only shard 0 measures; other shards pick its results up from the cache.
+func queueGain(filePath string) {
+	if shardID != 0 {
+		return
+	}
+	gains.Lock()
+	defer gains.Unlock()
+	if gains.pending[filePath] {
+		return
+	}
+	select {
+	case gains.queue <- filePath:
+		gains.pending[filePath] = true
+	default:
+	}
+}
+
+// startGainAnalysis loads the cache and starts the worker that measures
+// queued files one at a time, plus the job that queues new library files.
+// The library and gainsFile are shared by every shard, so only shard 0
+// measures and writes it; the others re-read it every gainScanInterval.
+func startGainAnalysis() {
+	if !normalizeLoudness {
+		return
+	}
+	loadGains()
+	if shardID != 0 {
+		go func() {
+			for {
+				time.Sleep(gainScanInterval)
+				loadGains()
+			}
+		}()
+		return
+	}
+
+	go func() {
+		for filePath := range gains.queue {
+			measureGain(filePath)
+		}
+	}()
+	go func() {
+		for {
+			queueLibraryGains()
+			time.Sleep(gainScanInterval)
+		}
+	}()
+}
+
+// loadGains replaces the in-memory cache with gainsFile.
+func loadGains() {
+	entries := make(map[string]gainEntry)
+	if err := loadJSON(gainsFile, &entries); err != nil {
+		log.Printf("[gain] couldn't load the loudness cache: %v", err)
+		return
+	}
+	if entries == nil {
+		entries = make(map[string]gainEntry)
+	}
+	gains.Lock()
+	gains.entries = entries
+	gains.Unlock()
+}
+
+// queueLibraryGains queues every library file without an up-to-date entry.
+func queueLibraryGains() {
+	files, index, err := scanLibrary(soundsDir, allowedExts)
+	if err != nil {
+		log.Printf("[gain] library scan failed: %v", err)
+		return
+	}
+	queued := 0
+	for _, rel := range files {
+		if isPlaylist(rel) {
+			continue
+		}
+		filePath := filepath.Join(soundsDir, rel)
+		gains.Lock()
+		e, found := gains.entries[filePath]
+		gains.Unlock()
+		if found && e.Size == index[rel].Size && e.ModTime.Equal(index[rel].ModTime) {
+			continue
+		}
+		queueGain(filePath)
+		queued++
+	}
+	if queued > 0 {
+		log.Printf("[gain] queued %d file(s) for loudness analysis", queued)
+	}
+}
+
+func measureGain(filePath string) {
+	defer func() {
+		gains.Lock()
+		delete(gains.pending, filePath)
+		gains.Unlock()
+	}()
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return // removed since it was queued
+	}
+	start := time.Now()
+	lufs, err := measureLoudness(filePath)
+	if err != nil {
+		log.Printf("[gain] %s: %v", filePath, err)
+		return
+	}
+	log.Printf("[gain] %s: %.1f LUFS (measured in %s)", trackLabel(filePath), lufs, time.Since(start).Round(time.Millisecond))
+
+	gains.Lock()
+	defer gains.Unlock()
+	gains.entries[filePath] = gainEntry{ModTime: info.ModTime(), Size: info.Size(), LUFS: lufs}
+	if err := saveJSON(gainsFile, gains.entries); err != nil {
+		log.Printf("[gain] couldn't save the loudness cache: %v", err)
+	}
+}
+
+// ebur128 prints a running log and then a summary; the last "I:" value is
+// the integrated loudness of the whole file.
+var integratedLoudness = regexp.MustCompile(`I:\s+(-?[0-9.]+) LUFS`)
+
+// measureLoudness decodes the whole file through ffmpeg's ebur128 filter and
+// returns its integrated loudness in LUFS.
+func measureLoudness(filePath string) (float64, error) {
+	// Background work must not hold up playback: if every slot stays busy,
+	// give up and let the next library scan queue the file again.
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return 0, err
+	}
+	defer releaseFFmpeg()
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg",
+		"-nostats", "-hide_banner", "-nostdin",
+		"-i", filePath,
+		"-map", "0:a:0", "-af", "ebur128", "-f", "null", "-",
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("ffmpeg ebur128: %v", err)
+	}
+	matches := integratedLoudness.FindAllSubmatch(stderr.Bytes(), -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no loudness in ffmpeg output")
+	}
+	lufs, err := strconv.ParseFloat(string(matches[len(matches)-1][1]), 64)
+	if err != nil {
+		return 0, err
+	}
+	if lufs <= -70 {
+		return 0, fmt.Errorf("track is silent")
+	}
+	return lufs, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// gatewayReadies counts Ready events. discordgo resumes a dropped gateway
+// session when it can (Resumed); a second Ready means it had to start a new
+// session instead, and anything the old one implied may be stale.
+var gatewayReadies atomic.Int64
+
+// voiceGrace is how long a playback's voice connection may stay down after a
+// gateway reconnect before the session is given up on. discordgo reconnects
+// voice on its own, which usually takes a few seconds.
+const voiceGrace = 15 * time.Second
+
+func onDisconnect(_ *discordgo.Session, _ *discordgo.Disconnect) {
+	log.Printf("[gateway] disconnected; discordgo will reconnect")
+}
+
+func onResumed(s *discordgo.Session, _ *discordgo.Resumed) {
+	log.Printf("[gateway] session resumed")
+	go pruneDeadSessions(s)
+}
+
+func onReady(s *discordgo.Session, r *discordgo.Ready) {
+	// Presence isn't kept across reconnects, so set it on every Ready.
+	updatePresence(s)
+	if gatewayReadies.Add(1) == 1 {
+		return
+	}
+	log.Printf("[gateway] new session after reconnect (session %s, %d guilds)", r.SessionID, len(r.Guilds))
+	go func() {
+		if shardID == 0 {
+			verifyCommands(s)
+		}
+		pruneDeadSessions(s)
+	}()
+}
+
+// verifyCommands re-registers the slash commands if any went missing, e.g.
+// when someone ran -unregister while this process was running.
+func verifyCommands(s *discordgo.Session) {
+	appID := s.State.User.ID
+	registered, err := s.ApplicationCommands(appID, "")
+	if err != nil {
+		log.Printf("[gateway] couldn't list commands to verify them: %v", err)
+		return
+	}
+	have := make(map[string]bool, len(registered))
+	for _, cmd := range registered {
+		have[cmd.Name] = true
+	}
+	for _, cmd := range slashCommands() {
+		if !have[cmd.Name] {
+			log.Printf("[gateway] command /%s is missing; registering commands again", cmd.Name)
+			registerCommands(s, appID, "")
+			return
+		}
+	}
+	log.Printf("[gateway] all %d commands are registered", len(registered))
+}
+
+// pruneDeadSessions stops playback in guilds whose voice connection didn't
+// survive a reconnect, so they don't stay "playing" forever and block new
+// playback or join sounds.
+func pruneDeadSessions(s *discordgo.Session) {
+	suspect := deadSessions(s)
+	if len(suspect) == 0 {
+		return
+	}
+	time.Sleep(voiceGrace)
+	for _, gp := range deadSessions(s) {
+		if suspect[gp.guildID] != gp {
+			continue
+		}
+		log.Printf("[gateway] voice connection for guild=%s didn't come back; ending its playback", gp.guildID)
+		gp.stop()
+		playSessions.CompareAndDelete(gp.guildID, gp)
+	}
+	updatePresence(s)
+}
+
+// deadSessions lists playbacks whose voice connection is gone or not ready.
+func deadSessions(s *discordgo.Session) map[string]*guildPlayback {
+	dead := map[string]*guildPlayback{}
+	playSessions.Range(func(key, value any) bool {
+		gp := value.(*guildPlayback)
+		gp.mu.Lock()
+		vc, stopped := gp.vc, gp.stopped
+		gp.mu.Unlock()
+		if stopped {
+			return true
+		}
+
+		s.RLock()
+		current := s.VoiceConnections[gp.guildID]
+		s.RUnlock()
+		ready := false
+		if vc != nil && vc == current {
+			vc.RLock()
+			ready = vc.Ready
+			vc.RUnlock()
+		}
+		if !ready {
+			dead[gp.guildID] = gp
+		}
+		return true
+	})
+	return dead
+}
@@ -0,0 +1,275 @@
+//go:build harness
+
+package main
+
+// The interaction harness drives the /sounds menu end to end without Discord:
+//
+//	go run -tags harness .
+//
+// It feeds synthetic InteractionCreate events to onInteractionCreate on a
+// session whose HTTP client is a fake Discord API, so every response the
+// handlers send is captured instead of delivered. Each step checks the
+// captured response; the exit status is 1 if any check failed, so CI can run
+// it. The bot itself takes *discordgo.Session everywhere, and all REST calls
+// go through its Client, which is what makes swapping the transport enough.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	harnessGuild   = "100"
+	harnessUser    = "200"
+	harnessChannel = "300"
+)
+
+// apiCall is one request the handlers made to the fake Discord API.
+type apiCall struct {
+	Method, Path string
+	Body         []byte
+}
+
+// fakeDiscord records requests and answers them all successfully.
+type fakeDiscord struct {
+	mu    sync.Mutex
+	calls []apiCall
+}
+
+func (f *fakeDiscord) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, apiCall{Method: req.Method, Path: req.URL.Path, Body: body})
+	f.mu.Unlock()
+
+	status, reply := http.StatusOK, "{}"
+	if strings.HasSuffix(req.URL.Path, "/callback") {
+		status, reply = http.StatusNoContent, ""
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(reply)),
+		Request:    req,
+	}, nil
+}
+
+// lastResponse returns the most recent interaction response.
+func (f *fakeDiscord) lastResponse() (resp harnessResponse, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for n := len(f.calls) - 1; n >= 0; n-- {
+		if strings.HasSuffix(f.calls[n].Path, "/callback") {
+			return parseResponse(f.calls[n].Body), true
+		}
+	}
+	return resp, false
+}
+
+// harnessResponse is the part of an interaction response the checks look at.
+type harnessResponse struct {
+	Type      discordgo.InteractionResponseType
+	Content   string
+	CustomIDs map[string]string // base ID (see splitCustomID) → full custom ID
+	Options   int               // select menu options, summed over menus
+}
+
+func parseResponse(body []byte) harnessResponse {
+	var raw struct {
+		Type discordgo.InteractionResponseType `json:"type"`
+		Data struct {
+			Content    string `json:"content"`
+			Components []any  `json:"components"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(body, &raw)
+	resp := harnessResponse{Type: raw.Type, Content: raw.Data.Content, CustomIDs: map[string]string{}}
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if id, ok := v["custom_id"].(string); ok {
+				base, _ := splitCustomID(id)
+				resp.CustomIDs[base] = id
+			}
+			if opts, ok := v["options"].([]any); ok {
+				resp.Options += len(opts)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(raw.Data.Components)
+	return resp
+}
+
+// harness runs steps against one fake session and counts failed checks.
+type harness struct {
+	s      *discordgo.Session
+	api    *fakeDiscord
+	seq    atomic.Uint64
+	failed int
+}
+
+func (h *harness) interaction(t discordgo.InteractionType, data discordgo.InteractionData) *discordgo.InteractionCreate {
+	id := strconv.FormatUint(h.seq.Add(1), 10)
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:      id,
+		Token:   "token-" + id,
+		Type:    t,
+		GuildID: harnessGuild,
+		Member:  &discordgo.Member{User: &discordgo.User{ID: harnessUser}},
+		Data:    data,
+	}}
+}
+
+func (h *harness) command(name string) harnessResponse {
+	return h.send(h.interaction(discordgo.InteractionApplicationCommand, discordgo.ApplicationCommandInteractionData{Name: name}))
+}
+
+func (h *harness) click(customID string, values ...string) harnessResponse {
+	return h.send(h.interaction(discordgo.InteractionMessageComponent, discordgo.MessageComponentInteractionData{
+		CustomID: customID,
+		Values:   values,
+	}))
+}
+
+func (h *harness) send(i *discordgo.InteractionCreate) harnessResponse {
+	onInteractionCreate(h.s, i)
+	resp, ok := h.api.lastResponse()
+	if !ok {
+		h.check(false, "no response to %s", interactionLabel(i))
+	}
+	return resp
+}
+
+func (h *harness) check(ok bool, format string, args ...any) {
+	status := "PASS"
+	if !ok {
+		status = "FAIL"
+		h.failed++
+	}
+	fmt.Printf("%s  %s\n", status, fmt.Sprintf(format, args...))
+}
+
+// runHarness runs the scripted /sounds flow and exits with its result.
+func runHarness() bool {
+	dir, err := os.MkdirTemp("", "tunetalk-harness-")
+	if err != nil {
+		log.Fatalf("[harness] %v", err)
+	}
+	defer os.RemoveAll(dir)
+	soundsDir = filepath.Join(dir, "sounds")
+	dataDir = filepath.Join(dir, "data")
+	if err := os.MkdirAll(soundsDir, 0o755); err != nil {
+		log.Fatalf("[harness] %v", err)
+	}
+	// Enough files for two pages of the picker.
+	for n := range pageSize + 5 {
+		name := filepath.Join(soundsDir, fmt.Sprintf("clip%02d.mp3", n))
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			log.Fatalf("[harness] %v", err)
+		}
+	}
+	log.SetOutput(io.Discard) // handler logs would drown the report
+
+	s, err := discordgo.New("Bot harness")
+	if err != nil {
+		log.Fatalf("[harness] %v", err)
+	}
+	api := &fakeDiscord{}
+	s.Client = &http.Client{Transport: api}
+	s.State.User = &discordgo.User{ID: "1"}
+	h := &harness{s: s, api: api}
+
+	first := h.command(soundsCmdName)
+	h.check(first.Type == discordgo.InteractionResponseChannelMessageWithSource, "/%s opens a menu (type %d)", soundsCmdName, first.Type)
+	h.check(first.Options == pageSize, "first page lists %d sounds (got %d)", pageSize, first.Options)
+
+	page2 := h.click(first.CustomIDs["sounds_next"])
+	h.check(page2.Type == discordgo.InteractionResponseUpdateMessage, "Next updates the menu in place (type %d)", page2.Type)
+	h.check(page2.Options == 5, "second page lists the remaining 5 sounds (got %d)", page2.Options)
+
+	back := h.click(page2.CustomIDs["sounds_prev"])
+	h.check(back.Options == pageSize, "Prev returns to the first page (got %d sounds)", back.Options)
+
+	selected := h.click(back.CustomIDs["sound_select"], "3", "1")
+	h.check(strings.Contains(selected.Content, "clip01.mp3, clip03.mp3"), "a multi-select is kept in list order: %q", firstLine(selected.Content))
+	_, hasVoice := selected.CustomIDs["voice_select"]
+	h.check(hasVoice, "selecting moves on to the voice channel picker")
+
+	joining := h.click(selected.CustomIDs["voice_select"], harnessChannel)
+	h.check(strings.Contains(joining.Content, "Joining <#"+harnessChannel+">"), "picking a channel starts playback: %q", firstLine(joining.Content))
+
+	// REQUIRE_SAME_VC: no channel picker, and a Retry until the user joins voice.
+	requireSameVC = true
+	menu := h.command(soundsCmdName)
+	waiting := h.click(menu.CustomIDs["sound_select"], "2")
+	h.check(strings.Contains(waiting.Content, "Join a voice channel first"), "REQUIRE_SAME_VC asks to join voice first: %q", lastLine(waiting.Content))
+	_ = s.State.GuildAdd(&discordgo.Guild{ID: harnessGuild, VoiceStates: []*discordgo.VoiceState{
+		{GuildID: harnessGuild, ChannelID: harnessChannel, UserID: harnessUser},
+	}})
+	retried := h.click(waiting.CustomIDs["voice_retry"])
+	h.check(strings.Contains(retried.Content, "Joining <#"+harnessChannel+"> and playing: clip02.mp3"), "Retry plays the kept selection in the user's channel: %q", firstLine(retried.Content))
+	requireSameVC = false
+
+	// A DJ role scoped to play blocks starting playback, not browsing.
+	djRoles.byGuild[harnessGuild] = djSetting{Role: "400", Scope: djScopePlay}
+	menu = h.command(soundsCmdName)
+	h.check(menu.Options == pageSize, "the picker stays open without the DJ role (got %d sounds)", menu.Options)
+	denied := h.click(menu.CustomIDs["sound_select"], "0")
+	h.check(strings.Contains(denied.Content, "can start playback"), "picking a sound needs the DJ role: %q", denied.Content)
+	delete(djRoles.byGuild, harnessGuild)
+
+	// An ID no route knows, e.g. from a newer build's message, is answered.
+	unknown := h.click("seek_forward")
+	h.check(strings.Contains(unknown.Content, "no longer supported"), "an unknown component is answered: %q", unknown.Content)
+
+	// A second /sounds replaces the browser; the first menu is now stale.
+	h.command(soundsCmdName)
+	stale := h.click(first.CustomIDs["sounds_next"])
+	h.check(strings.Contains(stale.Content, "outdated"), "an older menu is rejected: %q", stale.Content)
+
+	// A server restart forgets browsers; clicking an old menu then expires.
+	browserStates.Lock()
+	clear(browserStates.data)
+	browserStates.Unlock()
+	expired := h.click(first.CustomIDs["sounds_next"])
+	h.check(expired.Content == sessionExpiredMsg(), "a forgotten session reports expiry: %q", expired.Content)
+
+	stopGuildPlayback(harnessGuild)
+	if h.failed > 0 {
+		fmt.Printf("%d check(s) failed\n", h.failed)
+		os.Exit(1)
+	}
+	fmt.Println("all checks passed")
+	return true
+}
+
+func lastLine(s string) string {
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// historyPerUser caps how many plays are remembered for each user.
+const historyPerUser = 200
+
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	GuildID string    `json:"guild_id"`
+	Track   string    `json:"track"`
+}
+
+// playHistory is an in-memory log of tracks each user started, newest last.
+var playHistory = struct {
+	sync.Mutex
+	byUser map[string][]historyEntry
+}{byUser: make(map[string][]historyEntry)}
+
+// recordPlay notes that the user who started the session heard this track.
+func recordPlay(origin *discordgo.Interaction, guildID, filePath string) {
+	if origin == nil {
+		return
+	}
+	userID := interactionUserID(&discordgo.InteractionCreate{Interaction: origin})
+	if userID == "" {
+		return
+	}
+	playHistory.Lock()
+	defer playHistory.Unlock()
+	h := append(playHistory.byUser[userID], historyEntry{Time: time.Now().UTC(), GuildID: guildID, Track: trackLabel(filePath)})
+	if len(h) > historyPerUser {
+		h = h[len(h)-historyPerUser:]
+	}
+	playHistory.byUser[userID] = h
+}
+
+func userHistory(userID string) []historyEntry {
+	playHistory.Lock()
+	defer playHistory.Unlock()
+	return append([]historyEntry(nil), playHistory.byUser[userID]...)
+}
+
+// handleMyHistoryCommand DMs the caller their play history as a short list plus
+// a JSON attachment, falling back to an ephemeral reply if DMs are closed.
+func handleMyHistoryCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	userID := interactionUserID(i)
+	history := userHistory(userID)
+	if len(history) == 0 {
+		logRespondErr(i, respondPrivate(s, i, "You haven't played anything since the bot last started."))
+		return
+	}
+
+	summary := historySummary(history)
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		log.Printf("[history] marshal: %v", err)
+		logRespondErr(i, respondPrivate(s, i, summary))
+		return
+	}
+
+	dm, err := s.UserChannelCreate(userID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+			Content: summary,
+			Files:   []*discordgo.File{{Name: "history.json", ContentType: "application/json", Reader: bytes.NewReader(data)}},
+		})
+	}
+	if err != nil {
+		log.Printf("[history] DM to %s failed, replying ephemerally: %v", userID, err)
+		logRespondErr(i, respondPrivate(s, i, "I couldn't DM you (are DMs from server members off?).\n"+summary))
+		return
+	}
+	logRespondErr(i, respondEphemeral(s, i, "Sent your play history in a DM.", nil))
+}
+
+// historySummary lists the most recent plays, newest first, within a message.
+func historySummary(history []historyEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your last %d play(s) since the bot started:\n", len(history))
+	for idx := len(history) - 1; idx >= 0; idx-- {
+		e := history[idx]
+		line := fmt.Sprintf("<t:%d:f> %s\n", e.Time.Unix(), e.Track)
+		if b.Len()+len(line) > 1900 {
+			b.WriteString("…")
+			break
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// idleWait keeps vc open for IDLE_TIMEOUT after the queue ran out, so the
+// next pick starts without joining voice again. It reports whether to leave
+// now: false means a new session started and may take vc over (waitLeave).
+func (gp *guildPlayback) idleWait(vc *discordgo.VoiceConnection, l *pendingLeave) bool {
+	if err := vc.Speaking(false); err != nil {
+		log.Printf("[idle] vc.Speaking(false) error: %v", err)
+	}
+	log.Printf("[idle] guild=%s: queue finished; leaving voice in %s unless something is played", gp.guildID, idleTimeout)
+	timer := time.NewTimer(idleTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		log.Printf("[idle] guild=%s: idle for %s; leaving voice", gp.guildID, idleTimeout)
+		return true
+	case <-l.leave:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// leaveIdle ends guildID's idle wait, if there is one, so the bot leaves
+// voice now. It reports whether there was one.
+func leaveIdle(guildID string) bool {
+	val, ok := pendingLeaves.Load(guildID)
+	if !ok || !val.(*pendingLeave).idle {
+		return false
+	}
+	l := val.(*pendingLeave)
+	l.leaveOnce.Do(func() { close(l.leave) })
+	return true
+}
+
+// endIdle ends every idle wait, for shutdown: the bot leaves voice without an
+// outro. Call it after stopNewPlayback, so no new wait can start.
+func endIdle() {
+	pendingLeaves.Range(func(key, _ any) bool {
+		leaveIdle(key.(string))
+		return true
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// intentNames maps GATEWAY_INTENTS names to discordgo intents.
+var intentNames = map[string]discordgo.Intent{
+	"guilds":                   discordgo.IntentGuilds,
+	"guild_members":            discordgo.IntentGuildMembers,
+	"guild_moderation":         discordgo.IntentGuildModeration,
+	"guild_emojis":             discordgo.IntentGuildEmojis,
+	"guild_integrations":       discordgo.IntentGuildIntegrations,
+	"guild_webhooks":           discordgo.IntentGuildWebhooks,
+	"guild_invites":            discordgo.IntentGuildInvites,
+	"guild_voice_states":       discordgo.IntentGuildVoiceStates,
+	"guild_presences":          discordgo.IntentGuildPresences,
+	"guild_messages":           discordgo.IntentGuildMessages,
+	"guild_message_reactions":  discordgo.IntentGuildMessageReactions,
+	"guild_message_typing":     discordgo.IntentGuildMessageTyping,
+	"direct_messages":          discordgo.IntentDirectMessages,
+	"direct_message_reactions": discordgo.IntentDirectMessageReactions,
+	"direct_message_typing":    discordgo.IntentDirectMessageTyping,
+	"message_content":          discordgo.IntentMessageContent,
+	"guild_scheduled_events":   discordgo.IntentGuildScheduledEvents,
+}
+
+// privilegedIntents must also be switched on in the Developer Portal, or
+// Discord closes the gateway connection on identify.
+var privilegedIntents = discordgo.IntentGuildMembers | discordgo.IntentGuildPresences | discordgo.IntentMessageContent
+
+// gatewayIntents is what the bot identifies with: what its enabled features
+// need, plus any extras named in GATEWAY_INTENTS.
+func gatewayIntents() discordgo.Intent {
+	// Guilds for the guild/channel cache, voice states for joining voice,
+	// /listeners and join sounds.
+	intents := discordgo.IntentGuilds | discordgo.IntentGuildVoiceStates
+	if reactionControls {
+		intents |= discordgo.IntentGuildMessageReactions
+	}
+	intents |= extraIntents
+
+	if intents&discordgo.IntentMessageContent != 0 && intents&(discordgo.IntentGuildMessages|discordgo.IntentDirectMessages) == 0 {
+		log.Printf("Warning: GATEWAY_INTENTS has message_content without guild_messages or direct_messages, so no messages will arrive")
+	}
+	if p := intents & privilegedIntents; p != 0 {
+		log.Printf("GATEWAY_INTENTS requests privileged intents (%s); enable them for the bot in the Developer Portal or the connection will be refused",
+			strings.Join(intentList(p), ", "))
+	}
+	return intents
+}
+
+// parseIntents reads a comma-separated list of intent names, warning about
+// and skipping unknown ones.
+func parseIntents(v string) discordgo.Intent {
+	var intents discordgo.Intent
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		intent, ok := intentNames[name]
+		if !ok {
+			log.Printf("Warning: GATEWAY_INTENTS: unknown intent %q (known: %s)", name, strings.Join(intentList(^discordgo.Intent(0)), ", "))
+			continue
+		}
+		intents |= intent
+	}
+	return intents
+}
+
+// intentList names the intents set in mask, sorted.
+func intentList(mask discordgo.Intent) []string {
+	var names []string
+	for name, intent := range intentNames {
+		if mask&intent != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Interactions from DMs have no GuildID, which every handler relies on. They
+// must be turned away with a reply, never reach a handler, and never panic.
+func TestDMInteractionsRejected(t *testing.T) {
+	testLibrary(t)
+	const want = "This bot only works in servers."
+
+	cases := map[string]*discordgo.InteractionCreate{
+		"component": testInteraction(discordgo.InteractionMessageComponent, "", discordgo.MessageComponentInteractionData{CustomID: "sound_select", Values: []string{"0"}}),
+		"modal":     testInteraction(discordgo.InteractionModalSubmit, "", discordgo.ModalSubmitInteractionData{CustomID: "sounds_search_modal"}),
+	}
+	for _, cmd := range slashCommands() {
+		cases["/"+cmd.Name] = testInteraction(discordgo.InteractionApplicationCommand, "", discordgo.ApplicationCommandInteractionData{Name: cmd.Name, CommandType: cmd.Type})
+	}
+	for name, i := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, api := newTestSession(t)
+			onInteractionCreate(s, i)
+			got := api.responses()
+			if len(got) != 1 || got[0].Content != want || got[0].Flags != discordgo.MessageFlagsEphemeral {
+				t.Errorf("got %+v, want one ephemeral %q", got, want)
+			}
+			if f := api.followups(); len(f) != 0 {
+				t.Errorf("unexpected followups %+v", f)
+			}
+		})
+	}
+
+	// Autocomplete can't take a message, so it gets no answer at all.
+	s, api := newTestSession(t)
+	onInteractionCreate(s, testInteraction(discordgo.InteractionApplicationCommandAutocomplete, "", discordgo.ApplicationCommandInteractionData{Name: "playpath"}))
+	if got := api.responses(); len(got) != 0 {
+		t.Errorf("autocomplete from a DM: got %+v, want no response", got)
+	}
+}
+
+// With EPHEMERAL_RESPONSES=false, replies go public, but errors and refusals
+// stay with the person who ran the command.
+func TestPublicRepliesKeepErrorsPrivate(t *testing.T) {
+	testLibrary(t)
+	old := ephemeralResponses
+	ephemeralResponses = false
+	t.Cleanup(func() { ephemeralResponses = old })
+
+	for _, tc := range []struct {
+		name  string
+		flags discordgo.MessageFlags
+	}{
+		{"eq", 0},
+		{"pause", discordgo.MessageFlagsEphemeral},
+		{"restart", discordgo.MessageFlagsEphemeral},
+		{"playpath", discordgo.MessageFlagsEphemeral},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, api := newTestSession(t)
+			onInteractionCreate(s, testInteraction(discordgo.InteractionApplicationCommand, "1", discordgo.ApplicationCommandInteractionData{Name: tc.name}))
+			got := api.responses()
+			if len(got) != 1 || got[0].Flags != tc.flags {
+				t.Errorf("got %+v, want one reply with flags %d", got, tc.flags)
+			}
+		})
+	}
+}
+
+// A pick is acknowledged before playback starts, and a failure to start
+// replaces that reply rather than racing it with a followup.
+func TestPlaySelectionReportsFailureInPlace(t *testing.T) {
+	testLibrary(t, "a.mp3")
+	t.Setenv("PATH", t.TempDir()) // no ffmpeg
+	s, api := newTestSession(t)
+	i := testInteraction(discordgo.InteractionMessageComponent, "1", discordgo.MessageComponentInteractionData{CustomID: "voice_select"})
+	playSelection(s, i, &browserState{Selected: []string{"a.mp3"}, GuildID: "1"}, "42")
+
+	var edit *apiRequest
+	for deadline := time.Now().Add(5 * time.Second); edit == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		api.mu.Lock()
+		for k, r := range api.requests {
+			if r.Method == http.MethodPatch && strings.HasSuffix(r.Path, "/messages/@original") {
+				edit = &api.requests[k]
+				if k == 0 || !strings.HasSuffix(api.requests[0].Path, "/callback") {
+					t.Errorf("the reply was edited before it was sent: %+v", api.requests[:k+1])
+				}
+			}
+		}
+		api.mu.Unlock()
+	}
+	if edit == nil {
+		t.Fatal("the failure wasn't reported")
+	}
+	if want := playErrorMessage(newPlayError(errCodeFFmpegMissing, nil)); !strings.Contains(string(edit.Body), strings.Split(want, "\n")[0]) {
+		t.Errorf("edit %s doesn't carry %q", edit.Body, want)
+	}
+	if f := api.followups(); len(f) != 0 {
+		t.Errorf("unexpected followups %+v", f)
+	}
+}
+
+// /maintenance shows host paths and sweeps the host's temp folder, so server
+// admins who aren't the owner are refused.
+func TestMaintenanceOwnerOnly(t *testing.T) {
+	testLibrary(t)
+	t.Setenv("TMPDIR", t.TempDir()) // the owner's run sweeps it
+	old := ownerID
+	t.Cleanup(func() { ownerID = old })
+	cleanup := discordgo.ApplicationCommandInteractionData{
+		Name:    "maintenance",
+		Options: []*discordgo.ApplicationCommandInteractionDataOption{{Name: "cleanup", Type: discordgo.ApplicationCommandOptionSubCommand}},
+	}
+
+	for _, tc := range []struct {
+		owner   string
+		refused bool
+	}{{"", true}, {"300", true}, {"200", false}} {
+		ownerID = tc.owner
+		s, api := newTestSession(t)
+		onInteractionCreate(s, testInteraction(discordgo.InteractionApplicationCommand, "1", cleanup))
+		got := api.responses()
+		if len(got) != 1 || got[0].Flags != discordgo.MessageFlagsEphemeral {
+			t.Fatalf("owner %q: got %+v, want one private reply", tc.owner, got)
+		}
+		if refused := strings.Contains(got[0].Content, "restricted to the bot owner"); refused != tc.refused {
+			t.Errorf("owner %q: got %q, want refused: %v", tc.owner, got[0].Content, tc.refused)
+		}
+	}
+}
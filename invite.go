@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// invitePermissions is what the bot needs in every server: see voice and text
+// channels, join and speak (with voice activity, as it never pushes to talk),
+// and post notices and /preview clips.
+const invitePermissions int64 = discordgo.PermissionViewChannel |
+	discordgo.PermissionVoiceConnect |
+	discordgo.PermissionVoiceSpeak |
+	discordgo.PermissionVoiceUseVAD |
+	discordgo.PermissionSendMessages |
+	discordgo.PermissionAttachFiles
+
+// inviteURL builds the OAuth2 link that adds the bot to a server with the
+// scopes and permissions it needs. REACTION_CONTROLS also needs to add and
+// remove reactions on its notices.
+func inviteURL(clientID string) string {
+	perms := invitePermissions
+	if reactionControls {
+		perms |= discordgo.PermissionAddReactions | discordgo.PermissionManageMessages
+	}
+	q := url.Values{}
+	q.Set("client_id", clientID)
+	q.Set("scope", "bot applications.commands")
+	q.Set("permissions", fmt.Sprint(perms))
+	return "https://discord.com/oauth2/authorize?" + q.Encode()
+}
+
+// logInviteURL prints the invite link once the session knows the bot's ID.
+func logInviteURL(s *discordgo.Session) {
+	log.Printf("Invite link (bot + slash commands, with the permissions it needs): %s", inviteURL(s.State.User.ID))
+}
+
+// handleInvite serves the invite link as a redirect, so a browser pointed at
+// /invite lands straight on Discord's authorize page.
+func handleInvite(s *discordgo.Session, w http.ResponseWriter, r *http.Request) {
+	link := inviteURL(s.State.User.ID)
+	if r.URL.Query().Has("json") {
+		writeJSON(w, http.StatusOK, map[string]string{"url": link})
+		return
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
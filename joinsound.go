@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const joinSoundsFile = "joinsounds.json"
+
+// joinSounds maps guild → user → sound (relative to soundsDir). lastPlayed
+// rate-limits each user; it isn't persisted.
+var joinSounds = struct {
+	sync.Mutex
+	byGuild    map[string]map[string]string
+	lastPlayed map[string]time.Time // guildID:userID
+}{byGuild: make(map[string]map[string]string), lastPlayed: make(map[string]time.Time)}
+
+func loadJoinSounds() {
+	joinSounds.Lock()
+	defer joinSounds.Unlock()
+	if err := loadJSON(shardFileName(joinSoundsFile), &joinSounds.byGuild); err != nil {
+		log.Printf("[joinsound] couldn't load join sounds: %v", err)
+	}
+	if joinSounds.byGuild == nil {
+		joinSounds.byGuild = make(map[string]map[string]string)
+	}
+}
+
+func saveJoinSoundsLocked() {
+	if err := saveJSON(shardFileName(joinSoundsFile), joinSounds.byGuild); err != nil {
+		log.Printf("[joinsound] couldn't save join sounds: %v", err)
+	}
+}
+
+// onVoiceStateUpdate plays a user's join sound when they enter a voice channel
+// and the bot is otherwise idle in that guild.
+func onVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.ChannelID == "" || (v.BeforeUpdate != nil && v.BeforeUpdate.ChannelID == v.ChannelID) {
+		return // left, or a mute/deafen change
+	}
+	// Never react to bots, ourselves included, or joining to play would trigger again.
+	if s.State.User != nil && v.UserID == s.State.User.ID {
+		return
+	}
+	if v.Member != nil && v.Member.User != nil && v.Member.User.Bot {
+		return
+	}
+
+	key := v.GuildID + ":" + v.UserID
+	joinSounds.Lock()
+	sound := joinSounds.byGuild[v.GuildID][v.UserID]
+	if sound == "" || !effectiveExts(v.GuildID).allows(sound) || time.Since(joinSounds.lastPlayed[key]) < joinSoundCooldown {
+		joinSounds.Unlock()
+		return
+	}
+	if _, busy := playSessions.Load(v.GuildID); busy {
+		joinSounds.Unlock()
+		return
+	}
+	joinSounds.lastPlayed[key] = time.Now()
+	joinSounds.Unlock()
+
+	log.Printf("[joinsound] user=%s joined <#%s> in guild=%s, playing %s", v.UserID, v.ChannelID, v.GuildID, sound)
+	go func() {
+		if err := startPlayback(s, v.GuildID, v.ChannelID, []string{filepath.Join(soundsDir, sound)}, nil); err != nil {
+			log.Printf("[joinsound] playback error: %v", err)
+		}
+	}()
+}
+
+func handleJoinSoundCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	opts := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, o := range sub.Options {
+		opts[o.Name] = o
+	}
+
+	switch sub.Name {
+	case "set":
+		user := opts["user"].UserValue(nil)
+		sound, err := librarySound(i.GuildID, opts["sound"].StringValue())
+		if err != nil {
+			logRespondErr(i, respondPrivate(s, i, err.Error()))
+			return
+		}
+		joinSounds.Lock()
+		if joinSounds.byGuild[i.GuildID] == nil {
+			joinSounds.byGuild[i.GuildID] = make(map[string]string)
+		}
+		joinSounds.byGuild[i.GuildID][user.ID] = sound
+		saveJoinSoundsLocked()
+		joinSounds.Unlock()
+		logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("<@%s> will be greeted with %s.", user.ID, displayName(sound)), nil))
+	case "clear":
+		user := opts["user"].UserValue(nil)
+		joinSounds.Lock()
+		_, had := joinSounds.byGuild[i.GuildID][user.ID]
+		delete(joinSounds.byGuild[i.GuildID], user.ID)
+		if len(joinSounds.byGuild[i.GuildID]) == 0 {
+			delete(joinSounds.byGuild, i.GuildID)
+		}
+		if had {
+			saveJoinSoundsLocked()
+		}
+		joinSounds.Unlock()
+		if !had {
+			logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("<@%s> has no join sound.", user.ID), nil))
+			return
+		}
+		logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("Removed <@%s>'s join sound.", user.ID), nil))
+	case "list":
+		joinSounds.Lock()
+		var lines []string
+		for userID, sound := range joinSounds.byGuild[i.GuildID] {
+			lines = append(lines, fmt.Sprintf("<@%s>: %s", userID, displayName(sound)))
+		}
+		joinSounds.Unlock()
+		if len(lines) == 0 {
+			logRespondErr(i, respondEphemeral(s, i, "No join sounds are set in this server.", nil))
+			return
+		}
+		sort.Strings(lines)
+		logRespondErr(i, respondEphemeral(s, i, tailTruncate(strings.Join(lines, "\n"), 1900), nil))
+	}
+}
+
+func joinSoundCommand() *discordgo.ApplicationCommand {
+	userOpt := &discordgo.ApplicationCommandOption{Type: discordgo.ApplicationCommandOptionUser, Name: "user", Description: "Member to greet", Required: true}
+	return &discordgo.ApplicationCommand{
+		Name:                     "joinsound",
+		Description:              "Greet members with a sound when they join voice",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Set a member's join sound",
+				Options: []*discordgo.ApplicationCommandOption{
+					userOpt,
+					{Type: discordgo.ApplicationCommandOptionString, Name: "sound", Description: "File path inside the sounds directory", Required: true},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "clear",
+				Description: "Remove a member's join sound",
+				Options:     []*discordgo.ApplicationCommandOption{userOpt},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show this server's join sounds",
+			},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// lastChannels remembers the voice channel each guild last played in.
+var lastChannels = struct {
+	sync.Mutex
+	byGuild map[string]string
+}{byGuild: make(map[string]string)}
+
+func rememberChannel(guildID, channelID string) {
+	lastChannels.Lock()
+	lastChannels.byGuild[guildID] = channelID
+	lastChannels.Unlock()
+}
+
+// lastChannel returns the guild's last voice channel, or nil if there isn't
+// one or it has since been deleted.
+func lastChannel(s *discordgo.Session, guildID string) *discordgo.Channel {
+	lastChannels.Lock()
+	id := lastChannels.byGuild[guildID]
+	lastChannels.Unlock()
+	if id == "" {
+		return nil
+	}
+	ch, err := channelInfo(s, id)
+	if err != nil || ch.GuildID != guildID {
+		lastChannels.Lock()
+		if lastChannels.byGuild[guildID] == id {
+			delete(lastChannels.byGuild, guildID)
+		}
+		lastChannels.Unlock()
+		return nil
+	}
+	return ch
+}
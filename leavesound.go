@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// leaveSoundMax cuts a long LEAVE_SOUND short, so a wrong file can't keep the
+// bot in the channel.
+const leaveSoundMax = 10 * time.Second
+
+// pendingLeave is a session on its way out of voice: waiting out
+// IDLE_TIMEOUT after its queue ran out (see idle.go), then playing
+// LEAVE_SOUND. The session has already left playSessions, so a new one may
+// start meanwhile; it cuts the wait or the outro short and waits for done
+// before joining voice.
+type pendingLeave struct {
+	idle      bool          // wait IDLE_TIMEOUT before leaving
+	outro     bool          // play LEAVE_SOUND before disconnecting
+	stop      chan struct{} // closed when a new session starts
+	stopOnce  sync.Once
+	leave     chan struct{} // closed to end the idle wait and leave now
+	leaveOnce sync.Once
+	done      chan struct{} // closed once the voice connection is gone or kept
+	// kept is the voice connection left open for the new session, set
+	// before done is closed; nil if it was disconnected.
+	kept *discordgo.VoiceConnection
+}
+
+// pendingLeaves holds each guild's *pendingLeave until it has left.
+var pendingLeaves sync.Map
+
+// waitLeave cuts short any session leaving voice in guildID and returns once
+// it's gone. A connection that was idling is kept if it's live in channelID,
+// where the new session picks it up, and disconnected otherwise.
+func waitLeave(guildID, channelID string) {
+	val, ok := pendingLeaves.Load(guildID)
+	if !ok {
+		return
+	}
+	l := val.(*pendingLeave)
+	l.stopOnce.Do(func() { close(l.stop) })
+	log.Printf("[leavesound] guild=%s: cutting the way out short for a new session", guildID)
+	<-l.done
+	if l.kept != nil && !voiceLiveIn(l.kept, channelID) {
+		disconnectVoice(l.kept)
+	}
+}
+
+// wantOutro marks the session to play LEAVE_SOUND before it disconnects. stop
+// then leaves the voice connection open for the lifecycle to play it on,
+// unless a new session replaces this one first; see handOff.
+func (gp *guildPlayback) wantOutro() {
+	if leaveSound == "" {
+		return
+	}
+	gp.mu.Lock()
+	gp.outro = true
+	gp.mu.Unlock()
+}
+
+// takeLeave returns how the session leaves voice if it doesn't just
+// disconnect, or nil, and clears the outro mark, so the outro is played at
+// most once per session and can't set off another. queueEnded asks for the
+// IDLE_TIMEOUT wait. Either takes over the voice connection and the session
+// leaves playSessions, so a pick meanwhile starts a new session rather than
+// queueing onto this one; see waitLeave.
+func (gp *guildPlayback) takeLeave(queueEnded bool) *pendingLeave {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	// Ordered against stopNewPlayback, so shutdown's endIdle sees every wait.
+	lifecycles.Lock()
+	defer lifecycles.Unlock()
+	l := &pendingLeave{
+		idle:  queueEnded && idleTimeout > 0 && !draining.Load(),
+		outro: gp.outro && !shuttingDown.Load(),
+	}
+	gp.outro = false
+	if gp.handedOff || (!l.idle && !l.outro) {
+		return nil
+	}
+	gp.vc = nil
+	l.stop, l.leave, l.done = make(chan struct{}), make(chan struct{}), make(chan struct{})
+	pendingLeaves.Store(gp.guildID, l)
+	playSessions.CompareAndDelete(gp.guildID, gp)
+	return l
+}
+
+// leaveVoice waits out IDLE_TIMEOUT and plays the outro on vc as l asks,
+// then disconnects it, unless a new session started during the wait.
+func (gp *guildPlayback) leaveVoice(vc *discordgo.VoiceConnection, l *pendingLeave) {
+	defer func() {
+		pendingLeaves.CompareAndDelete(gp.guildID, l)
+		close(l.done)
+	}()
+	if l.idle && !gp.idleWait(vc, l) {
+		l.kept = vc
+		return
+	}
+	if l.outro && !shuttingDown.Load() {
+		gp.playOutro(vc, l.stop)
+	}
+	disconnectVoice(vc)
+}
+
+// playOutro plays LEAVE_SOUND on vc and waits for it to end, or for stop to
+// close. It's played outside the queue, so it's never announced, recorded or
+// resumed. A missing file or failed encode is logged and skipped.
+func (gp *guildPlayback) playOutro(vc *discordgo.VoiceConnection, stop <-chan struct{}) {
+	guildID := gp.guildID
+	path := filepath.Join(soundsDir, leaveSound)
+	if _, err := os.Stat(path); err != nil {
+		log.Printf("[leavesound] guild=%s: skipping LEAVE_SOUND: %v", guildID, err)
+		return
+	}
+	enc, err := encodeTrack(path, trackOptions{eq: eqProfile(guildID), night: nightFilter(guildID), bitrate: gp.bitrate})
+	if err != nil {
+		log.Printf("[leavesound] guild=%s: skipping LEAVE_SOUND: %v", guildID, err)
+		return
+	}
+	defer enc.Cleanup()
+
+	st := newStreamer(enc, vc)
+	done := make(chan error, 1)
+	go func() { done <- st.run() }()
+	select {
+	case err = <-done:
+	case <-time.After(leaveSoundMax):
+		st.control(streamStop)
+		err = <-done
+	case <-stop:
+		st.control(streamStop)
+		err = <-done
+	}
+	log.Printf("[leavesound] guild=%s: played %s (%v)", guildID, leaveSound, err)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func setLeaveSound(t *testing.T, name string) {
+	t.Helper()
+	old := leaveSound
+	leaveSound = name
+	t.Cleanup(func() { leaveSound = old })
+}
+
+// A due outro takes the session out of playSessions, and a new session cuts
+// it short and waits for it to leave voice.
+func TestOutroMakesWayForNewSession(t *testing.T) {
+	setLeaveSound(t, "bye.mp3")
+	gp := &guildPlayback{guildID: "1"}
+	playSessions.Store("1", gp)
+	t.Cleanup(func() { playSessions.Delete("1") })
+
+	gp.wantOutro()
+	o := gp.takeLeave(false)
+	if o == nil {
+		t.Fatal("no outro after wantOutro")
+	}
+	if _, ok := playSessions.Load("1"); ok {
+		t.Error("the session stayed in playSessions during its outro")
+	}
+	if gp.takeLeave(false) != nil {
+		t.Error("the outro was due twice")
+	}
+
+	left := make(chan struct{})
+	go func() {
+		<-o.stop // the outro's stream ends early
+		close(left)
+		pendingLeaves.CompareAndDelete("1", o)
+		close(o.done)
+	}()
+	waited := make(chan struct{})
+	go func() {
+		waitLeave("1", "42")
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("waitLeave didn't return")
+	}
+	select {
+	case <-left:
+	default:
+		t.Error("waitLeave returned before the outro was done")
+	}
+	waitLeave("1", "42") // nothing pending
+}
+
+// A session replaced before its lifecycle ends plays no outro under the new one.
+func TestHandOffCancelsOutro(t *testing.T) {
+	setLeaveSound(t, "bye.mp3")
+	gp := &guildPlayback{guildID: "1"}
+	gp.wantOutro()
+	if vc := gp.handOff("42"); vc != nil {
+		t.Fatal("handOff kept a voice connection it didn't have")
+	}
+	if gp.takeLeave(false) != nil {
+		t.Error("an outro was due after handOff")
+	}
+}
+
+func setIdleTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := idleTimeout
+	idleTimeout = d
+	t.Cleanup(func() { idleTimeout = old })
+}
+
+// With IDLE_TIMEOUT, a session whose queue ran out waits in voice; a stop
+// from /stop or shutdown doesn't.
+func TestIdleOnlyAfterQueueEnds(t *testing.T) {
+	setIdleTimeout(t, time.Minute)
+	gp := &guildPlayback{guildID: "1"}
+	if gp.takeLeave(false) != nil {
+		t.Error("a stopped session waited in voice")
+	}
+	l := gp.takeLeave(true)
+	if l == nil || !l.idle || l.outro {
+		t.Fatalf("queue end: got %+v, want an idle wait without an outro", l)
+	}
+	t.Cleanup(func() { pendingLeaves.Delete("1") })
+
+	if !leaveIdle("1") {
+		t.Fatal("leaveIdle found no idle wait")
+	}
+	select {
+	case <-l.leave:
+	default:
+		t.Error("leaveIdle didn't end the wait")
+	}
+	leaveIdle("1") // closing twice must not panic
+
+	setIdleTimeout(t, 0)
+	if gp.takeLeave(true) != nil {
+		t.Error("waited in voice with IDLE_TIMEOUT=0")
+	}
+}
+
+// A new session starting during the idle wait takes the connection over
+// instead of the bot leaving and joining again.
+func TestIdleHandsConnectionToNewSession(t *testing.T) {
+	setIdleTimeout(t, time.Minute)
+	gp := &guildPlayback{guildID: "1"}
+	l := gp.takeLeave(true)
+	vc := &discordgo.VoiceConnection{}
+	left := make(chan struct{})
+	go func() {
+		gp.leaveVoice(vc, l)
+		close(left)
+	}()
+	l.stopOnce.Do(func() { close(l.stop) })
+	select {
+	case <-left:
+	case <-time.After(time.Second):
+		t.Fatal("the idle wait didn't end when a new session started")
+	}
+	if l.kept != vc {
+		t.Error("the voice connection wasn't kept for the new session")
+	}
+	if _, ok := pendingLeaves.Load("1"); ok {
+		t.Error("the idle wait stayed in pendingLeaves")
+	}
+}
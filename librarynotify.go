@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// libraryPollInterval is how often the library is rescanned for changes.
+	// A summary goes out after the first scan that finds nothing new, so a
+	// bulk copy is reported once, when it has finished.
+	libraryPollInterval = 30 * time.Second
+
+	// libraryNotifyNames is how many file names a summary lists per kind.
+	libraryNotifyNames = 10
+)
+
+// startLibraryNotify posts a summary to LIBRARY_NOTIFY_CHANNEL whenever files
+// are added to or removed from the library. Only shard 0 posts, so a sharded
+// deployment sends each summary once.
+func startLibraryNotify(s *discordgo.Session) {
+	if libraryNotifyChannel == "" || shardID != 0 {
+		return
+	}
+	go func() {
+		last, _, err := scanLibrary(soundsDir, allowedExts)
+		seeded := err == nil
+		if err != nil {
+			log.Printf("[librarynotify] initial scan failed: %v", err)
+		}
+		pending := map[string]int{}
+		for {
+			time.Sleep(libraryPollInterval)
+			cur, _, err := scanLibrary(soundsDir, allowedExts)
+			if err != nil {
+				log.Printf("[librarynotify] scan failed: %v", err)
+				continue
+			}
+			if !seeded {
+				// The library was missing; don't report all of it as new.
+				last, seeded = cur, true
+				continue
+			}
+			added, removed := diffListings(last, cur)
+			last = cur
+			netChanges(pending, added, removed)
+			if len(added) > 0 || len(removed) > 0 || len(pending) == 0 {
+				continue // still changing, or nothing to report
+			}
+			postLibraryChanges(s, pending)
+			clear(pending)
+		}
+	}()
+}
+
+// diffListings compares two sorted listings from scanLibrary.
+func diffListings(old, cur []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case j == len(cur) || (i < len(old) && old[i] < cur[j]):
+			removed = append(removed, old[i])
+			i++
+		case i == len(old) || cur[j] < old[i]:
+			added = append(added, cur[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// netChanges adds one scan's changes to pending, which is +1 for a file
+// added since the last summary and -1 for one removed; a file that came and
+// went cancels out and is dropped.
+func netChanges(pending map[string]int, added, removed []string) {
+	for _, rel := range added {
+		pending[rel]++
+	}
+	for _, rel := range removed {
+		pending[rel]--
+	}
+	for rel, n := range pending {
+		if n == 0 {
+			delete(pending, rel)
+		}
+	}
+}
+
+func postLibraryChanges(s *discordgo.Session, pending map[string]int) {
+	var added, removed []string
+	for rel, n := range pending {
+		if n > 0 {
+			added = append(added, rel)
+		} else {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	var counts []string
+	if len(added) > 0 {
+		counts = append(counts, plural(len(added), "file")+" added")
+	}
+	if len(removed) > 0 && len(added) > 0 {
+		counts = append(counts, fmt.Sprintf("%d removed", len(removed)))
+	} else if len(removed) > 0 {
+		counts = append(counts, plural(len(removed), "file")+" removed")
+	}
+	summary := strings.Join(counts, ", ")
+	msg := "📚 Library changed: " + summary
+	msg += fileList("Added", added)
+	msg += fileList("Removed", removed)
+
+	log.Printf("[librarynotify] %s", summary)
+	if _, err := s.ChannelMessageSend(libraryNotifyChannel, tailTruncate(msg, 2000)); err != nil {
+		log.Printf("[librarynotify] couldn't post to channel %s: %v", libraryNotifyChannel, err)
+	}
+}
+
+// fileList formats up to libraryNotifyNames names under a heading.
+func fileList(heading string, files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n**" + heading + ":**")
+	for _, rel := range files[:min(len(files), libraryNotifyNames)] {
+		b.WriteString("\n- " + tailTruncate(rel, 100))
+	}
+	if extra := len(files) - libraryNotifyNames; extra > 0 {
+		fmt.Fprintf(&b, "\n…and %d more", extra)
+	}
+	return b.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
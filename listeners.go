@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func handleListenersCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	channelID := ""
+	if val, ok := playSessions.Load(i.GuildID); ok {
+		gp := val.(*guildPlayback)
+		gp.mu.Lock()
+		if gp.vc != nil {
+			channelID = gp.vc.ChannelID
+		}
+		gp.mu.Unlock()
+	}
+	if channelID == "" {
+		logRespondErr(i, respondPrivate(s, i, "I'm not connected to a voice channel."))
+		return
+	}
+
+	guild, err := s.State.Guild(i.GuildID)
+	if err != nil {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Couldn't read voice states: %v", err)))
+		return
+	}
+
+	// Copy under the lock; State.Member takes it again and RWMutex isn't reentrant.
+	s.State.RLock()
+	var states []*discordgo.VoiceState
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID == channelID {
+			states = append(states, vs)
+		}
+	}
+	s.State.RUnlock()
+
+	var names []string
+	for _, vs := range states {
+		member := vs.Member
+		if member == nil || member.User == nil {
+			member, _ = s.State.Member(i.GuildID, vs.UserID)
+		}
+		if member != nil && member.User != nil {
+			if member.User.Bot {
+				continue
+			}
+			names = append(names, member.DisplayName())
+			continue
+		}
+		// Not cached; a mention still renders as their name.
+		names = append(names, "<@"+vs.UserID+">")
+	}
+
+	if len(names) == 0 {
+		logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("Nobody is listening in <#%s>.", channelID), nil))
+		return
+	}
+	msg := fmt.Sprintf("%d listening in <#%s>: %s", len(names), channelID, strings.Join(names, ", "))
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
@@ -2,18 +2,27 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,10 +33,22 @@ import (
 
 const (
 	pageSize = 25 // Discord select menus support max 25 options
+
+	// frameDuration is the Opus frame size in ms. discordgo sends one packet
+	// every 20 ms and advances the RTP timestamp by 20 ms' worth of samples,
+	// so other sizes would be paced and stamped wrong.
+	frameDuration = 20
+
+	defaultMaxQueue = 100 // tracks; see MAX_QUEUE
 )
 
+// Hides operator commands from regular members by default; servers can override in Integrations settings.
+var adminPermissions int64 = discordgo.PermissionAdministrator
+
 var (
-	allowedExts = map[string]struct{}{
+	// Audio file types the library lists; ALLOWED_EXTS overrides it and
+	// /extensions per guild (see effectiveExts)
+	allowedExts = extSet{
 		".mp3":  {},
 		".wav":  {},
 		".flac": {},
@@ -38,6 +59,7 @@ var (
 	soundsDir = getenv("SOUNDS_DIR", "./sounds")
 
 	// Per user+guild ephemeral browser state
+	browserGen    atomic.Uint64 // last browserState.Gen handed out
 	browserStates = struct {
 		sync.Mutex
 		data map[string]*browserState
@@ -45,47 +67,396 @@ var (
 
 	// Playback sessions per guild
 	playSessions sync.Map // map[guildID]*guildPlayback
+
+	// Optional public channel per guild for now-playing/finished notices
+	announceChannels map[string]string // map[guildID]channelID
+
+	// Channel to post a summary to when files are added to or removed from
+	// the library (LIBRARY_NOTIFY_CHANNEL; "" = off); see librarynotify.go
+	libraryNotifyChannel string
+
+	// Registered names for the main commands; see loadConfig
+	soundsCmdName = "sounds"
+	stopCmdName   = "stop"
+
+	// Discord's CHAT_INPUT command name rule (names must also be lowercase)
+	commandNameRe = regexp.MustCompile(`^[-_\p{L}\p{N}\p{Devanagari}\p{Thai}]{1,32}$`)
+
+	// Bot operator; unlocks owner-only commands like /diag
+	ownerID string
+
+	// Join voice self-deafened. We only send audio, and discordgo skips its UDP
+	// receive loop entirely for deafened connections.
+	joinDeafened = true
+
+	// Use dca.NewStream instead of the custom streamer (LEGACY_STREAM=true)
+	legacyStream = false
+
+	// /restart re-execs the binary; false exits with restartExitCode for a wrapper to restart
+	restartExec = true
+
+	// On SIGINT/SIGTERM, let current tracks finish before exiting, for up to
+	// drainTimeout (GRACEFUL_DRAIN=true, GRACEFUL_DRAIN_TIMEOUT=seconds); see drain.go
+	gracefulDrain = false
+	drainTimeout  = 5 * time.Minute
+
+	// Where persistent state (schedules, ...) is kept
+	dataDir = getenv("DATA_DIR", "./data")
+
+	// Default voice channel per guild for /schedule; see loadConfig
+	scheduleChannels map[string]string
+
+	// Default DJ role per guild and what it gates (DJ_ROLE, DJ_SCOPE); /djrole
+	// overrides both per guild, see djrole.go
+	djRoleEnv map[string]string
+	djScope   = djScopeControls
+
+	// Listen address of the HTTP control API, e.g. 127.0.0.1:8080 (empty = off)
+	controlAddr string
+	// Bearer token the control API requires, if set
+	controlToken string
+
+	// Minimum time between one user's join sounds
+	joinSoundCooldown = 5 * time.Minute
+
+	// Most tracks waiting after the current one; see MAX_QUEUE
+	maxQueue = defaultMaxQueue
+
+	// Fade tracks in/out to avoid a harsh start or a click at the end (0 = off)
+	fadeInMS  = 0
+	fadeOutMS = 0
+
+	// Strip leading/trailing silence while encoding (TRIM_SILENCE=true); a
+	// sidecar's trim_silence overrides it per file
+	trimSilence = false
+
+	// Adjust each track's volume towards loudnessTarget (LUFS) using cached
+	// ebur128 measurements; see gain.go
+	normalizeLoudness = false
+	loudnessTarget    = -18.0
+
+	// Custom status shown while nothing is playing ("" = none)
+	idleStatus string
+	// Static activity shown while nothing is playing, e.g. "Watching /sounds";
+	// takes precedence over idleStatus when presenceText is set
+	presenceType = discordgo.ActivityTypeGame
+	presenceText string
+
+	// Encode the next queued track while the current one plays (GAPLESS=false to disable)
+	gapless = true
+
+	// Let the browser's Cancel button also stop playback it started (CANCEL_STOPS_PLAYBACK=true)
+	cancelStopsPlayback = false
+
+	// Play a selected sound straight into the guild's last channel (SKIP_CHANNEL_PICKER=true)
+	skipChannelPicker = false
+
+	// Always play a selection in the picker's own voice channel, with no channel
+	// picker (REQUIRE_SAME_VC=true); takes precedence over SKIP_CHANNEL_PICKER
+	requireSameVC = false
+
+	// Add ⏸️ ⏭️ ⏹️ reactions to public now-playing notices as controls (REACTION_CONTROLS=true)
+	reactionControls = false
+
+	// Gateway intents beyond what enabled features need (GATEWAY_INTENTS); see intents.go
+	extraIntents discordgo.Intent
+
+	// Make /stop ask for confirmation first (CONFIRM_STOP=true), for busy shared servers
+	confirmStop = false
+
+	// Fade the track out over half a second on /stop instead of cutting it (FADE_OUT_STOP=true)
+	fadeOutStop = false
+
+	// Keep the now-playing notice's progress bar updated (PROGRESS_UPDATES=false to disable)
+	progressUpdates = true
+
+	// Times one track may restart its encoder after ffmpeg crashes mid-stream
+	// (FFMPEG_CRASH_RETRIES; 0 = give up and move on)
+	ffmpegCrashRetries = 2
+
+	// Sound to play before leaving voice on /stop or when the queue ends,
+	// relative to SOUNDS_DIR (LEAVE_SOUND; "" = none); see leavesound.go
+	leaveSound = ""
+
+	// Post "Queue finished" when a playlist runs out (ANNOUNCE_QUEUE_END=false to disable)
+	announceQueueEnd = true
+
+	// Stay in voice this long after the queue runs out (IDLE_TIMEOUT; 0 =
+	// leave at once); see idle.go
+	idleTimeout time.Duration
+
+	// Reply to commands privately (EPHEMERAL_RESPONSES=false makes pickers and
+	// confirmations public; their buttons still only work for the invoker)
+	ephemeralResponses = true
+
+	// Rejoin and continue what each guild was playing when the bot went down
+	// (RESUME_ON_START=true); see resume.go
+	resumeOnStart = false
+
+	// Send Opus sources as-is instead of re-encoding them (OPUS_PASSTHROUGH=true)
+	opusPassthrough = false
+
+	// This process's shard; see loadConfig
+	shardID    = 0
+	shardCount = 1
+
+	// Encoder buffering; see loadConfig
+	bufferedFrames = dca.StdEncodeOptions.BufferedFrames // frames buffered ahead of the stream
+	packetLoss     = dca.StdEncodeOptions.PacketLoss     // expected loss in percent, tunes the encoder's resilience
+	audioChannels  = dca.StdEncodeOptions.Channels       // 1 = mono, 2 = stereo; the sample rate stays at Discord's 48kHz
 )
 
 type browserState struct {
-	Files        []string // sorted, relative to soundsDir
-	Page         int
-	SelectedFile string
+	AllFiles    []string // library listing narrowed by Type, sorted, relative to soundsDir
+	Files       []string // AllFiles narrowed by Query; what the picker pages over
+	Query       string   // active search term ("" = no filter)
+	Type        string   // extension filter from the type option ("" = all)
+	Dir         string   // folder filter: only files directly in it ("." = top level, "" = all)
+	LibrarySize int      // files in the library before any filter
+	Page        int
+	Selected    []string // files picked in the menu, in list order; see selectedFiles
+	StartedBy   string   // ID of the voice_select interaction that started playback
+	InvokerID   string   // user who opened the browser; only they may use it
+	Gen         uint64   // embedded in custom IDs so an older menu's buttons are rejected
+	GuildID     string
+
+	Index  map[string]audioFile // mod time and size per file, captured with the listing
+	SortBy string               // "name" (default), "mtime" (newest first) or "size" (largest first)
+	Tags   string               // /find fields that chose AllFiles, for the header ("" = none)
+}
+
+// sortLabels names each sort order for the picker header and buttons.
+var sortLabels = map[string]string{
+	"name":  "A–Z",
+	"mtime": "Newest",
+	"size":  "Largest",
+}
+
+// sortFiles reorders the library and re-applies the active search, which also
+// returns to the first page.
+func (st *browserState) sortFiles(by string) {
+	st.SortBy = by
+	// AllFiles may share its backing array with Files; sort a copy.
+	files := append([]string(nil), st.AllFiles...)
+	sort.SliceStable(files, func(a, b int) bool {
+		fa, fb := st.Index[files[a]], st.Index[files[b]]
+		switch by {
+		case "mtime":
+			if !fa.ModTime.Equal(fb.ModTime) {
+				return fa.ModTime.After(fb.ModTime)
+			}
+		case "size":
+			if fa.Size != fb.Size {
+				return fa.Size > fb.Size
+			}
+		}
+		return files[a] < files[b]
+	})
+	st.AllFiles = files
+	if !st.applySearch(st.Query) {
+		st.applySearch("")
+	}
+}
+
+// applySearch narrows Files to entries containing query (case-insensitive),
+// within Dir if set, and resets to the first page. It reports false, leaving
+// state unchanged, if nothing matches.
+func (st *browserState) applySearch(query string) bool {
+	query = strings.TrimSpace(query)
+	if query == "" && st.Dir == "" {
+		st.Query = ""
+		st.Files = st.AllFiles
+		st.Page = 0
+		return true
+	}
+	needle := strings.ToLower(query)
+	var matched []string
+	for _, f := range st.AllFiles {
+		if st.Dir != "" && path.Dir(f) != st.Dir {
+			continue
+		}
+		if strings.Contains(strings.ToLower(f), needle) {
+			matched = append(matched, f)
+		}
+	}
+	if len(matched) == 0 {
+		return false
+	}
+	st.Query = query
+	st.Files = matched
+	st.Page = 0
+	return true
+}
+
+// setDir switches the folder filter, keeping the search if it still matches
+// there. It reports false, leaving state unchanged, if the folder has no files.
+func (st *browserState) setDir(dir string) bool {
+	old := st.Dir
+	st.Dir = dir
+	if st.applySearch(st.Query) || st.applySearch("") {
+		return true
+	}
+	st.Dir = old
+	return false
+}
+
+func (st *browserState) maxPage() int {
+	if len(st.Files) == 0 {
+		return 0
+	}
+	return (len(st.Files) - 1) / pageSize
 }
 
 type guildPlayback struct {
 	mu       sync.Mutex
 	guildID  string
 	vc       *discordgo.VoiceConnection
-	enc      *dca.EncodeSession
+	enc      trackSource
+	streamer *streamer             // custom streamer (default)
+	stream   *dca.StreamingSession // dca.NewStream fallback when LEGACY_STREAM=true
 	doneChan chan error
 	playing  string
+	queue    []string               // tracks to play after the current one
+	origin   *discordgo.Interaction // interaction that started playback, for followups
+	stopped  bool                   // set by stop() so the lifecycle doesn't announce "finished"
+
+	paused      bool
+	silenceStop chan struct{} // closed to end the silence feeder started by pause()
+
+	nowPlaying *discordgo.Message // public now-playing notice carrying control reactions, if any
+
+	channelID  string // voice channel the bot plays in
+	eq         string // equalizer profile for tracks encoded from now on
+	startAt    int    // seconds to skip in the first track (resume, /playpath start); run sets it to -1 once used
+	trackStart int    // seconds the current track started at, for its position
+	handedOff  bool   // vc was passed on to a new session; don't disconnect it
+	night      string // night mode chain the current track was encoded with
+	outro      bool   // play LEAVE_SOUND before disconnecting; see leavesound.go
+	bitrate    int    // kbps for the guild's boost level and the channel; see bitrate.go
+
+	pitch pitchShift // /pitch, for tracks encoded from now on
+
+	queueChanged chan struct{} // wakes trackProgress to refresh the next-up preview
+}
+
+// elapsedLocked is how far into the current track playback is, including any
+// resumed-from offset. Call with gp.mu held.
+func (gp *guildPlayback) elapsedLocked() time.Duration {
+	var pos time.Duration
+	if gp.streamer != nil {
+		pos = gp.streamer.position()
+	} else if gp.stream != nil {
+		pos = gp.stream.PlaybackPosition()
+	}
+	return pos + time.Duration(gp.trackStart)*time.Second
 }
 
 func (gp *guildPlayback) stop() {
 	gp.mu.Lock()
-	defer gp.mu.Unlock()
+	gp.stopped = true
+
+	if gp.silenceStop != nil {
+		close(gp.silenceStop)
+		gp.silenceStop = nil
+	}
 
 	// Best-effort stop: kill ffmpeg and disconnect VC.
 	if gp.enc != nil {
 		gp.enc.Cleanup()
 		gp.enc = nil
 	}
-	if gp.vc != nil {
-		_ = gp.vc.Speaking(false)
-		_ = gp.vc.Disconnect()
+	// Killing ffmpeg ends an active stream, but a paused one only sends silence.
+	if gp.streamer != nil {
+		gp.streamer.control(streamStop)
+	}
+	// A paused stream isn't reading, so it would never report done. Restart it
+	// against the cleaned-up encoder so it hits EOF and the lifecycle can exit.
+	if gp.paused && gp.stream != nil {
+		gp.paused = false
+		gp.stream.SetPaused(false)
+	}
+	// With an outro due, the lifecycle disconnects after playing it.
+	var leave *discordgo.VoiceConnection
+	if gp.vc != nil && !gp.outro {
+		leave = gp.vc
 		gp.vc = nil
 	}
+	gp.mu.Unlock()
+
+	// Disconnecting can take seconds; don't hold gp.mu, which every command
+	// and progress update on this session takes, for it.
+	if leave != nil {
+		disconnectVoice(leave)
+	}
+}
+
+// handOff stops playback for a new session starting in channelID. If this
+// session's voice connection is live in that same channel it's kept open and
+// returned, so the new session can use it without leaving and rejoining.
+func (gp *guildPlayback) handOff(channelID string) *discordgo.VoiceConnection {
+	gp.mu.Lock()
+	vc := gp.vc
+	keep := vc != nil && !gp.stopped && voiceLiveIn(vc, channelID)
+	if keep {
+		gp.vc = nil
+		gp.handedOff = true
+	} else {
+		// The new session joins voice next, so don't leave the connection
+		// open for an outro under it.
+		gp.outro = false
+	}
+	gp.mu.Unlock()
+	gp.stop()
+	if !keep {
+		return nil
+	}
+	return vc
+}
+
+// voiceLiveIn reports whether vc is connected and ready in channelID.
+func voiceLiveIn(vc *discordgo.VoiceConnection, channelID string) bool {
+	vc.RLock()
+	defer vc.RUnlock()
+	return vc.Ready && vc.ChannelID == channelID && vc.OpusSend != nil
+}
+
+// liveVoiceConnection returns the guild's existing voice connection if it's
+// ready in channelID, or nil.
+func liveVoiceConnection(s *discordgo.Session, guildID, channelID string) *discordgo.VoiceConnection {
+	s.RLock()
+	vc := s.VoiceConnections[guildID]
+	s.RUnlock()
+	if vc == nil || !voiceLiveIn(vc, channelID) {
+		return nil
+	}
+	return vc
 }
 
 func main() {
+	registerOnly := flag.Bool("register-only", false, "register slash commands and exit without starting the bot")
+	unregister := flag.Bool("unregister", false, "delete all of the bot's slash commands and exit")
+	cmdGuild := flag.String("guild", "", "with -register-only/-unregister: act on this guild's commands instead of the global ones")
+	flag.Parse()
+	if *registerOnly && *unregister {
+		log.Fatal("-register-only and -unregister are mutually exclusive")
+	}
+	if runHarness() {
+		return
+	}
+
 	// Load .env (if present). Ignore error so missing .env is non-fatal.
 	_ = godotenv.Load() // looks for ".env" in the current working directory
 
-	token := os.Getenv("DISCORD_TOKEN")
+	token, source, err := loadToken()
+	if err != nil {
+		log.Fatal(err)
+	}
 	if token == "" {
 		log.Fatal("DISCORD_TOKEN is not set. Put it in your environment or create a .env file with DISCORD_TOKEN=yourtoken")
 	}
+	log.Printf("Using bot token from %s", source)
+
+	loadConfig()
 
 	if _, err := os.Stat(soundsDir); os.IsNotExist(err) {
 		log.Printf("Warning: sounds directory %q does not exist (create it and add audio files)", soundsDir)
@@ -96,59 +467,403 @@ func main() {
 		log.Fatalf("failed to create discord session: %v", err)
 	}
 
-	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildVoiceStates
+	// Deploy-time modes: plain REST calls, no gateway connection.
+	if *registerOnly || *unregister {
+		me, err := dg.User("@me")
+		if err != nil {
+			log.Fatalf("failed to look up application ID: %v", err)
+		}
+		var failed int
+		if *unregister {
+			failed = unregisterCommands(dg, me.ID, *cmdGuild)
+		} else {
+			failed = registerCommands(dg, me.ID, *cmdGuild)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	dg.Identify.Intents = gatewayIntents()
+	if reactionControls {
+		dg.AddHandler(onReactionAdd)
+	}
+
+	// Sharding: run one process per shard with SHARD_ID=0..SHARD_COUNT-1. Discord
+	// pins each guild to a single shard, so per-guild state (playSessions etc.)
+	// stays local to the process that owns the guild.
+	dg.ShardID, dg.ShardCount = shardID, shardCount
 
 	dg.AddHandler(onInteractionCreate)
+	dg.AddHandler(onGuildDelete)
+	dg.AddHandler(onVoiceStateUpdate)
+	dg.AddHandler(onReady)
+	dg.AddHandler(onResumed)
+	dg.AddHandler(onDisconnect)
 
 	if err := dg.Open(); err != nil {
 		log.Fatalf("failed to open session: %v", err)
 	}
 	defer dg.Close()
 
-	// Register slash commands
+	// Register slash commands. Commands are global to the application, so with
+	// sharding only shard 0 registers them; every shard still handles them.
 	appID := dg.State.User.ID
-	commands := []*discordgo.ApplicationCommand{
+	if shardID == 0 {
+		registerCommands(dg, appID, "")
+	}
+
+	loadSchedules(dg)
+	loadJoinSounds()
+	loadGuildExts()
+	loadShuffleOnAdd()
+	loadGuildEQ()
+	loadNightMode()
+	loadDisabledCommands()
+	loadDJRoles()
+	loadCategories()
+	startGainAnalysis()
+	startLibraryNotify(dg)
+	sweepTempOnStart()
+	startControlServer(dg)
+	// After the loaders, so resumed tracks get the guild's EQ, night mode and
+	// gains; in the background, so a slow voice join doesn't hold up startup.
+	go resumePlayback(dg)
+
+	var names []string
+	for _, cmd := range slashCommands() {
+		names = append(names, "/"+cmd.Name)
+	}
+	log.Printf("Bot is running (shard %d/%d). Commands: %s", shardID, shardCount, strings.Join(names, ", "))
+	if shardID == 0 {
+		logInviteURL(dg)
+	}
+	restart := waitForSignal()
+	if gracefulDrain && !restart {
+		drainPlayback()
+	}
+
+	// Cleanup on shutdown. Record what was playing first: stopping the
+	// sessions empties them.
+	stopNewPlayback()
+	saveResumeState()
+	shuttingDown.Store(true)
+	endIdle()
+	log.Println("Shutting down: stopping active playbacks")
+	playSessions.Range(func(key, value any) bool {
+		if gp, ok := value.(*guildPlayback); ok {
+			gp.stop()
+		}
+		return true
+	})
+	// Each session leaves voice as it ends; disconnects give up after voiceDisconnectTimeout.
+	if !waitPlaybacks(voiceDisconnectTimeout+time.Second, nil) {
+		log.Println("Shutting down: some sessions didn't finish cleaning up")
+	}
+
+	if restart {
+		_ = dg.Close()
+		restartProcess()
+	}
+}
+
+// slashCommands is the full set of commands the bot registers.
+func slashCommands() []*discordgo.ApplicationCommand {
+	minStart := 0.0
+	return []*discordgo.ApplicationCommand{
 		{
-			Name:        "sounds",
+			Name:        soundsCmdName,
 			Description: "Browse and play a local sound file",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "type",
+					Description: "Only list files of this type",
+					Choices:     fileTypeChoices(),
+				},
+			},
 		},
 		{
-			Name:        "stop",
+			Name:        stopCmdName,
 			Description: "Stop playback and leave the voice channel",
 		},
+		{
+			Name:        "pause",
+			Description: "Pause playback (the bot stays in the channel)",
+		},
+		{
+			Name:        "resume",
+			Description: "Resume paused playback",
+		},
+		{
+			Name:        "recent",
+			Description: "Browse sounds newest first",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "type",
+					Description: "Only list files of this type",
+					Choices:     fileTypeChoices(),
+				},
+			},
+		},
+		{
+			Name:        "playall",
+			Description: "Queue the whole library, or the sounds matching a search",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "query",
+					Description: "Only sounds whose path contains this text",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "type",
+					Description: "Only sounds of this type",
+					Choices:     fileTypeChoices(),
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "shuffle",
+					Description: "Shuffle instead of playing in name order",
+				},
+			},
+		},
+		{
+			Name:        "ping",
+			Description: "Show the bot's connection latency",
+		},
+		{
+			Name:        "listeners",
+			Description: "List who is in the voice channel the bot is playing to",
+		},
+		{
+			Name:        "myhistory",
+			Description: "DM yourself a list of the sounds you've played",
+		},
+		{
+			Name:                     "diag",
+			Description:              "Owner only: play a test tone in your voice channel and report each stage",
+			DefaultMemberPermissions: &adminPermissions,
+		},
+		scheduleCommand(),
+		joinSoundCommand(),
+		previewCommand(),
+		queueCommand(),
+		playNextCommand(),
+		eqCommand(),
+		nightModeCommand(),
+		findCommand(),
+		extensionsCommand(),
+		maintenanceCommand(),
+		commandsCommand(),
+		djRoleCommand(),
+		exportCommand(),
+		categoryCommand(),
+		categoriesCommand(),
+		pitchCommand(),
+		playAttachmentCommand(),
+		sessionsCommand(),
+		{
+			Name:                     "stopall",
+			Description:              "Owner only: stop playback in every server",
+			DefaultMemberPermissions: &adminPermissions,
+		},
+		{
+			Name:                     "restart",
+			Description:              "Owner only: stop all playback and restart the bot",
+			DefaultMemberPermissions: &adminPermissions,
+		},
+		{
+			Name:                     "settings",
+			Description:              "Show the bot's effective configuration",
+			DefaultMemberPermissions: &adminPermissions,
+		},
+		{
+			Name:                     "playpath",
+			Description:              "Owner only: play any file on the host, even outside the library",
+			DefaultMemberPermissions: &adminPermissions,
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "path", Description: "Absolute path, or relative to the sounds directory", Required: true},
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "start", Description: "Seconds to start at, instead of the file's skip_intro", MinValue: &minStart},
+			},
+		},
 	}
+}
 
-	for _, cmd := range commands {
-		if _, err := dg.ApplicationCommandCreate(appID, "", cmd); err != nil {
+// registerCommands creates or updates every command, globally when guildID is
+// empty (can take up to an hour to propagate) or in one guild (instant).
+// Returns the number of failures.
+func registerCommands(s *discordgo.Session, appID, guildID string) int {
+	failed := 0
+	for _, cmd := range slashCommands() {
+		if _, err := s.ApplicationCommandCreate(appID, guildID, cmd); err != nil {
 			log.Printf("Failed to register command /%s: %v", cmd.Name, err)
+			failed++
+			continue
 		}
+		log.Printf("Registered command /%s (%s)", cmd.Name, commandScope(guildID))
 	}
+	return failed
+}
 
-	log.Printf("Bot is running. Commands: /sounds, /stop")
-	waitForSignal()
+// unregisterCommands deletes every command the application has in the given
+// scope, including ones this build no longer defines. Returns the number of failures.
+func unregisterCommands(s *discordgo.Session, appID, guildID string) int {
+	cmds, err := s.ApplicationCommands(appID, guildID)
+	if err != nil {
+		log.Printf("Failed to list commands (%s): %v", commandScope(guildID), err)
+		return 1
+	}
+	failed := 0
+	for _, cmd := range cmds {
+		if err := s.ApplicationCommandDelete(appID, guildID, cmd.ID); err != nil {
+			log.Printf("Failed to delete command /%s: %v", cmd.Name, err)
+			failed++
+			continue
+		}
+		log.Printf("Deleted command /%s (%s)", cmd.Name, commandScope(guildID))
+	}
+	return failed
+}
 
-	// Cleanup on shutdown
-	log.Println("Shutting down: stopping active playbacks")
-	playSessions.Range(func(key, value any) bool {
-		if gp, ok := value.(*guildPlayback); ok {
-			gp.stop()
+func commandScope(guildID string) string {
+	if guildID == "" {
+		return "global"
+	}
+	return "guild " + guildID
+}
+
+// onGuildDelete drops everything we hold for a guild the bot was kicked from
+// or that was deleted; otherwise the session would linger on a dead connection.
+func onGuildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+	if g.Unavailable {
+		// Outage, not a removal; the guild comes back with a GuildCreate.
+		return
+	}
+	if val, ok := playSessions.LoadAndDelete(g.ID); ok {
+		val.(*guildPlayback).stop()
+		log.Printf("[guild] removed from guild=%s, stopped playback", g.ID)
+	}
+	if n := dropGuildSchedules(g.ID); n > 0 {
+		log.Printf("[guild] removed from guild=%s, dropped %d schedule(s)", g.ID, n)
+	}
+
+	suffix := ":" + g.ID
+	browserStates.Lock()
+	for key := range browserStates.data {
+		if strings.HasSuffix(key, suffix) {
+			delete(browserStates.data, key)
 		}
-		return true
-	})
+	}
+	browserStates.Unlock()
 }
 
 func onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	defer recoverInteraction(s, i)
+
+	// Everything here is per guild (voice, playback, browser keys); a DM has
+	// no GuildID and nothing we could join.
+	if i.GuildID == "" {
+		log.Printf("[interaction] ignoring %s from a DM (user=%s)", interactionLabel(i), interactionUserID(i))
+		if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+			logRespondErr(i, respondPrivate(s, i, "This bot only works in servers."))
+		}
+		return
+	}
+
+	if draining.Load() {
+		if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+			logRespondErr(i, respondPrivate(s, i, "The bot is restarting and will be back shortly; try again in a minute."))
+		}
+		return
+	}
+
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
 		data := i.ApplicationCommandData()
+		if commandDisabled(i.GuildID, data.Name) {
+			logRespondErr(i, respondPrivate(s, i, "This command is disabled here."))
+			return
+		}
+		if !checkDJ(s, i, djCommandScope(data.Name), "/"+data.Name) {
+			return
+		}
 		switch data.Name {
-		case "sounds":
+		case soundsCmdName:
 			handleSoundsCommand(s, i)
-		case "stop":
+		case stopCmdName:
 			handleStopCommand(s, i)
+		case "pause":
+			handlePauseCommand(s, i)
+		case "resume":
+			handleResumeCommand(s, i)
+		case "diag":
+			handleDiagCommand(s, i)
+		case "listeners":
+			handleListenersCommand(s, i)
+		case "recent":
+			handleRecentCommand(s, i)
+		case "playall":
+			handlePlayAllCommand(s, i)
+		case "restart":
+			handleRestartCommand(s, i)
+		case "myhistory":
+			handleMyHistoryCommand(s, i)
+		case "schedule":
+			handleScheduleCommand(s, i)
+		case "joinsound":
+			handleJoinSoundCommand(s, i)
+		case "extensions":
+			handleExtensionsCommand(s, i)
+		case "preview":
+			handlePreviewCommand(s, i)
+		case "playpath":
+			handlePlayPathCommand(s, i)
+		case "queue":
+			handleQueueCommand(s, i)
+		case "playnext":
+			handlePlayNextCommand(s, i)
+		case "ping":
+			handlePingCommand(s, i)
+		case "eq":
+			handleEQCommand(s, i)
+		case "find":
+			handleFindCommand(s, i)
+		case "nightmode":
+			handleNightModeCommand(s, i)
+		case "settings":
+			handleSettingsCommand(s, i)
+		case "stopall":
+			handleStopAllCommand(s, i)
+		case "sessions":
+			handleSessionsCommand(s, i)
+		case "maintenance":
+			handleMaintenanceCommand(s, i)
+		case "commands":
+			handleCommandsCommand(s, i)
+		case "djrole":
+			handleDJRoleCommand(s, i)
+		case "export":
+			handleExportCommand(s, i)
+		case "category":
+			handleCategoryCommand(s, i)
+		case "categories":
+			handleCategoriesCommand(s, i)
+		case "pitch":
+			handlePitchCommand(s, i)
+		case playAttachmentCmdName:
+			handlePlayAttachmentCommand(s, i)
 		}
 	case discordgo.InteractionMessageComponent:
+		data := i.MessageComponentData()
+		if id, _ := splitCustomID(data.CustomID); !checkDJ(s, i, djComponentScope(id), id) {
+			return
+		}
 		handleComponent(s, i)
+	case discordgo.InteractionModalSubmit:
+		handleModalSubmit(s, i)
 	}
 }
 
@@ -156,142 +871,284 @@ func intPtr(i int) *int { return &i }
 
 // /sounds -> ephemeral paginated file picker
 func handleSoundsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	files, err := listAudioFiles(soundsDir)
+	openBrowser(s, i, "name")
+}
+
+// handleRecentCommand opens the browser with the newest files first.
+func handleRecentCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	openBrowser(s, i, "mtime")
+}
+
+func openBrowser(s *discordgo.Session, i *discordgo.InteractionCreate, sortBy string) {
+	files, index, err := scanLibrary(soundsDir, effectiveExts(i.GuildID))
 	if err != nil {
-		respondEphemeral(s, i, fmt.Sprintf("Error scanning sounds: %v", err), nil)
+		logRespondErr(i, respondPrivate(s, i, scanErrorMessage(err)))
 		return
 	}
 	if len(files) == 0 {
-		respondEphemeral(s, i, "No audio files found in "+soundsDir, nil)
+		logRespondErr(i, respondPrivate(s, i, "No audio files found in "+soundsDir))
 		return
 	}
+	librarySize := len(files)
 
-	key := browserKey(i)
-	browserStates.Lock()
-	browserStates.data[key] = &browserState{
-		Files: files,
-		Page:  0,
+	var fileType string
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "type" {
+			fileType = opt.StringValue()
+		}
+	}
+	if fileType != "" {
+		files = filterByType(files, fileType)
+		if len(files) == 0 {
+			logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("No %s files found in %s", fileType, soundsDir)))
+			return
+		}
 	}
-	state := browserStates.data[key]
-	browserStates.Unlock()
 
-	content := "Select a sound to play"
+	state := newBrowser(i, &browserState{
+		AllFiles:    files,
+		Type:        fileType,
+		LibrarySize: librarySize,
+		Index:       index,
+	}, sortBy)
+	content := pickerContent(state)
 	components := buildSoundPickerComponents(state)
-	respondEphemeral(s, i, content, components)
+	logRespondErr(i, respondEphemeral(s, i, content, components))
+}
+
+// newBrowser makes state the caller's current browser, replacing any older
+// one, and sorts its files. state only needs the listing and its filters.
+func newBrowser(i *discordgo.InteractionCreate, state *browserState, sortBy string) *browserState {
+	state.Gen = browserGen.Add(1)
+	state.GuildID = i.GuildID
+	state.InvokerID = interactionUserID(i)
+	browserStates.Lock()
+	defer browserStates.Unlock()
+	browserStates.data[browserKey(i)] = state
+	state.sortFiles(sortBy)
+	return state
 }
 
 func handleStopCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	gid := i.GuildID
-	val, ok := playSessions.Load(gid)
-	if !ok {
-		respondEphemeral(s, i, "Nothing is playing.", nil)
+	if _, ok := playSessions.Load(i.GuildID); !ok {
+		if leaveIdle(i.GuildID) {
+			logRespondErr(i, respondEphemeral(s, i, "Left the voice channel.", nil))
+			return
+		}
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing."))
+		return
+	}
+	if confirmStop {
+		logRespondErr(i, respondEphemeral(s, i, "Stop playback for everyone in the voice channel?", buildStopConfirmComponents()))
 		return
 	}
+	stopGuildPlayback(i.GuildID)
+	logRespondErr(i, respondEphemeral(s, i, "Stopped playback and left the voice channel.", nil))
+}
+
+// stopGuildPlayback stops and forgets the guild's session, reporting whether
+// there was one.
+func stopGuildPlayback(guildID string) bool {
+	val, ok := playSessions.LoadAndDelete(guildID)
+	if !ok {
+		return false
+	}
 	gp := val.(*guildPlayback)
-	gp.stop()
-	playSessions.Delete(gid)
-	respondEphemeral(s, i, "Stopped playback and left the voice channel.", nil)
+	gp.wantOutro()
+	gp.fadeStop()
+	return true
 }
 
-func handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	data := i.MessageComponentData()
-	key := browserKey(i)
+func buildStopConfirmComponents() []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: "stop_confirm", Label: "Stop", Style: discordgo.DangerButton},
+				discordgo.Button{CustomID: "stop_cancel", Label: "Keep playing", Style: discordgo.SecondaryButton},
+			},
+		},
+	}
+}
 
-	switch data.CustomID {
-	case "sounds_prev", "sounds_next", "sounds_cancel":
-		browserStates.Lock()
-		state, ok := browserStates.data[key]
-		browserStates.Unlock()
-		if !ok {
-			respondUpdate(s, i, "Session expired. Run /sounds again.", nil)
-			return
+// playInUserChannel plays the selection in the invoker's voice channel
+// (REQUIRE_SAME_VC). If they aren't in one, the selection is kept and a Retry
+// button checks again once they've joined.
+func playInUserChannel(s *discordgo.Session, i *discordgo.InteractionCreate, state *browserState) {
+	if vs, err := s.State.VoiceState(i.GuildID, interactionUserID(i)); err == nil && vs.ChannelID != "" {
+		playSelection(s, i, state, vs.ChannelID)
+		return
+	}
+	content := fmt.Sprintf("Selected: %s\nJoin a voice channel first, then press Retry.", strings.Join(state.Selected, ", "))
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{CustomID: browserID(state, "voice_retry"), Label: "Retry", Style: discordgo.PrimaryButton},
+				discordgo.Button{CustomID: browserID(state, "back_to_sounds"), Label: "Back", Style: discordgo.SecondaryButton},
+			},
+		},
+	}
+	logRespondErr(i, respondUpdate(s, i, content, components))
+}
+
+// selectedFiles maps the picker's values (indexes into files) back to paths.
+// Discord doesn't promise to return values in the order they were clicked, so
+// the result is always in list order, i.e. the order the menu shows them in,
+// with duplicates dropped.
+func selectedFiles(files []string, vals []string) ([]string, error) {
+	idxs := make([]int, 0, len(vals))
+	for _, v := range vals {
+		idx, err := strconv.Atoi(v)
+		if err != nil || idx < 0 || idx >= len(files) {
+			return nil, fmt.Errorf("invalid selection %q", v)
 		}
-		switch data.CustomID {
-		case "sounds_prev":
-			if state.Page > 0 {
-				state.Page--
-			}
-			respondUpdate(s, i, "Select a sound to play", buildSoundPickerComponents(state))
-		case "sounds_next":
-			maxPage := (len(state.Files) - 1) / pageSize
-			if state.Page < maxPage {
-				state.Page++
-			}
-			respondUpdate(s, i, "Select a sound to play", buildSoundPickerComponents(state))
-		case "sounds_cancel":
-			// End the ephemeral browser
-			browserStates.Lock()
-			delete(browserStates.data, key)
-			browserStates.Unlock()
-			respondUpdate(s, i, "Cancelled.", []discordgo.MessageComponent{})
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	idxs = slices.Compact(idxs)
+	selected := make([]string, len(idxs))
+	for n, idx := range idxs {
+		selected[n] = files[idx]
+	}
+	return selected, nil
+}
+
+// selectionTracks expands the picked sounds (relative to soundsDir) into the
+// tracks to play, in playing order, and describes them for the user. A
+// non-empty problem is the message to show instead, e.g. when a playlist
+// can't be read or the tracks wouldn't fit in the queue.
+func selectionTracks(guildID string, selected []string) (tracks []string, what, problem string) {
+	for _, relPath := range selected {
+		fullPath := filepath.Join(soundsDir, relPath)
+		if !isPlaylist(relPath) {
+			tracks = append(tracks, fullPath)
+			continue
 		}
-	case "sound_select":
-		// selection value = index into state.Files
-		browserStates.Lock()
-		state, ok := browserStates.data[key]
-		browserStates.Unlock()
-		if !ok {
-			respondUpdate(s, i, "Session expired. Run /sounds again.", nil)
-			return
+		entries, err := loadPlaylist(fullPath, effectiveExts(guildID))
+		if err != nil {
+			log.Printf("[playSelection] playlist %s: %v", fullPath, err)
+			return nil, "", fmt.Sprintf("Could not load playlist %s: %v", relPath, err)
 		}
-		vals := data.Values
-		if len(vals) == 0 {
-			respondUpdate(s, i, "No selection received. Try again.", buildSoundPickerComponents(state))
-			return
+		if len(entries) == 0 {
+			return nil, "", fmt.Sprintf("Playlist %s has no playable entries.", relPath)
 		}
-		idx, err := strconv.Atoi(vals[0])
-		if err != nil || idx < 0 || idx >= len(state.Files) {
-			respondUpdate(s, i, "Invalid selection. Try again.", buildSoundPickerComponents(state))
-			return
+		tracks = append(tracks, entries...)
+	}
+
+	what = strings.Join(selected, ", ")
+	// The first track plays right away; only the rest wait in the queue.
+	if pending := len(tracks) - 1; pending > maxQueue {
+		return nil, "", fmt.Sprintf("Queue is full (%d max): %s would queue %d tracks.", maxQueue, what, pending)
+	}
+	if len(tracks) > 1 {
+		what = fmt.Sprintf("%s (%d tracks)", what, len(tracks))
+	}
+	// Several picks play in list order; only a lone playlist is shuffled.
+	if len(selected) == 1 && isPlaylist(selected[0]) && shuffleOnAdd(guildID) {
+		shuffleTracks(tracks)
+		what += ", shuffled"
+	}
+	return tracks, what, ""
+}
+
+// playSelection starts the browser's selected sounds (or playlists) in
+// channelID, one after the other, and turns the menu into a "Joining…" notice.
+func playSelection(s *discordgo.Session, i *discordgo.InteractionCreate, state *browserState, channelID string) {
+	tracks, what, problem := selectionTracks(i.GuildID, state.Selected)
+	if problem != "" {
+		logRespondErr(i, respondUpdate(s, i, problem, nil))
+		return
+	}
+
+	state.StartedBy = i.Interaction.ID
+	msg := fmt.Sprintf("Joining <#%s> and playing: %s\nUse /%s to stop and disconnect.", channelID, what, stopCmdName)
+	components := []discordgo.MessageComponent{}
+	if cancelStopsPlayback {
+		components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{CustomID: browserID(state, "sounds_cancel"), Label: "Cancel", Style: discordgo.DangerButton},
+				},
+			},
 		}
-		state.SelectedFile = state.Files[idx]
-		// Move to voice channel selection view
-		components := buildVoiceChannelPickerComponents(s, i.GuildID)
-		content := fmt.Sprintf("Selected: %s\nSelect a voice channel to join and play.", state.SelectedFile)
-		respondUpdate(s, i, content, components)
-	case "back_to_sounds":
-		browserStates.Lock()
-		state, ok := browserStates.data[key]
-		browserStates.Unlock()
-		if !ok {
-			respondUpdate(s, i, "Session expired. Run /sounds again.", nil)
+	}
+	if err := respondUpdate(s, i, msg, components); err != nil {
+		logRespondErr(i, err)
+		return
+	}
+
+	// Only once the reply is in, so the announce followups come after it and a
+	// failure can replace it.
+	go func() {
+		err := startPlayback(s, i.GuildID, channelID, tracks, i.Interaction)
+		if err == nil {
 			return
 		}
-		state.SelectedFile = ""
-		respondUpdate(s, i, "Select a sound to play", buildSoundPickerComponents(state))
-	case "voice_select":
-		// Start playback
-		browserStates.Lock()
-		state, ok := browserStates.data[key]
-		browserStates.Unlock()
-		if !ok || state.SelectedFile == "" {
-			respondUpdate(s, i, "Session expired or no sound selected. Run /sounds again.", nil)
+		log.Printf("playback error: %v", err)
+		content := playErrorMessage(err)
+		if _, eerr := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:    &content,
+			Components: &[]discordgo.MessageComponent{},
+		}); eerr != nil {
+			log.Printf("[playSelection] couldn't report the error: %v", eerr)
+		}
+	}()
+}
+
+func handleModalSubmit(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ModalSubmitData()
+	id, gen := splitCustomID(data.CustomID)
+
+	state, ok := lookupBrowser(s, i, gen)
+	if !ok {
+		return
+	}
+
+	switch id {
+	case "sounds_jump_modal":
+		raw := strings.TrimSpace(modalValue(data, "page"))
+		page, err := strconv.Atoi(raw)
+		if err != nil || page < 1 || page > state.maxPage()+1 {
+			msg := fmt.Sprintf("Invalid page %q. Enter a number from 1 to %d.", raw, state.maxPage()+1)
+			logRespondErr(i, respondUpdate(s, i, msg, buildSoundPickerComponents(state)))
 			return
 		}
-		vals := data.Values
-		if len(vals) == 0 {
-			respondUpdate(s, i, "No channel selected.", buildVoiceChannelPickerComponents(s, i.GuildID))
+		state.Page = page - 1
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	case "sounds_search_modal":
+		query := modalValue(data, "query")
+		if !state.applySearch(query) {
+			msg := fmt.Sprintf("No sounds match %q.\n%s", strings.TrimSpace(query), pickerContent(state))
+			logRespondErr(i, respondUpdate(s, i, msg, buildSoundPickerComponents(state)))
 			return
 		}
-		channelID := vals[0]
-		relPath := state.SelectedFile
-		fullPath := filepath.Join(soundsDir, relPath)
+		logRespondErr(i, respondUpdate(s, i, pickerContent(state), buildSoundPickerComponents(state)))
+	default:
+		unknownComponent(s, i, id)
+	}
+}
 
-		go func() {
-			if err := startPlayback(s, i.GuildID, channelID, fullPath); err != nil {
-				log.Printf("playback error: %v", err)
+// modalValue returns the submitted value of the text input with the given custom ID.
+func modalValue(data discordgo.ModalSubmitInteractionData, customID string) string {
+	for _, c := range data.Components {
+		row, ok := c.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, rc := range row.Components {
+			if in, ok := rc.(*discordgo.TextInput); ok && in.CustomID == customID {
+				return in.Value
 			}
-		}()
-		msg := fmt.Sprintf("Joining <#%s> and playing: %s\nUse /stop to stop and disconnect.", channelID, relPath)
-		respondUpdate(s, i, msg, []discordgo.MessageComponent{})
-	default:
-		// Unknown component
-		respondUpdate(s, i, "Unsupported interaction.", nil)
+		}
 	}
+	return ""
 }
 
 // Quick decode probe (verifies the file can be read/decoded)
 func probeDecode(file string) error {
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return err
+	}
+	defer releaseFFmpeg()
 	var stderr bytes.Buffer
 	cmd := exec.Command(
 		"ffmpeg",
@@ -318,6 +1175,10 @@ func probeDecode(file string) error {
 // Opus encode probe (verifies ffmpeg has an opus encoder like libopus)
 // dca typically relies on ffmpeg producing opus frames when RawOutput=true.
 func probeOpusEncode(file string) error {
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return err
+	}
+	defer releaseFFmpeg()
 	var stderr bytes.Buffer
 	cmd := exec.Command(
 		"ffmpeg",
@@ -345,132 +1206,486 @@ func probeOpusEncode(file string) error {
 	return nil
 }
 
-func startPlayback(s *discordgo.Session, guildID, channelID, filePath string) error {
+// startPlayback joins channelID and plays tracks in order. Only the first track
+// is probed up front; the rest are queued on the guild's session.
+func startPlayback(s *discordgo.Session, guildID, channelID string, tracks []string, origin *discordgo.Interaction) error {
+	return startPlaybackAt(s, guildID, channelID, tracks, origin, -1)
+}
+
+// startPlaybackAt is startPlayback beginning startSec seconds into the first
+// track. A negative startSec starts at the track's skip_intro, like any track.
+func startPlaybackAt(s *discordgo.Session, guildID, channelID string, tracks []string, origin *discordgo.Interaction, startSec int) error {
+	if len(tracks) == 0 {
+		return newPlayError(errCodeNothingToPlay, errors.New("nothing to play"))
+	}
+	if draining.Load() {
+		return newPlayError(errCodeShuttingDown, errors.New("draining for shutdown"))
+	}
+	filePath := tracks[0]
+	if startSec < 0 {
+		startSec = introStart(filePath)
+	}
 	log.Printf("[startPlayback] requested: guild=%s channel=%s file=%s", guildID, channelID, filePath)
 
 	// Try to log channel info (type/name)
-	if ch, err := s.State.Channel(channelID); err == nil && ch != nil {
-		log.Printf("[startPlayback] channel info: name=%q type=%v", ch.Name, ch.Type)
+	ch, err := channelInfo(s, channelID)
+	if err == nil {
+		log.Printf("[startPlayback] channel info: name=%q type=%v bitrate=%d", ch.Name, ch.Type, ch.Bitrate)
+	} else {
+		log.Printf("[startPlayback] channel info unavailable: %v", err)
 	}
 
-	// File check
-	info, err := os.Stat(filePath)
-	if err != nil {
-		log.Printf("[startPlayback] file stat error: %v", err)
-		return fmt.Errorf("file not accessible: %w", err)
+	// A reader can only be read once, so it can't be probed; ffmpeg will
+	// report a bad stream when playback starts.
+	probe := !isReaderTrack(filePath)
+
+	// File check (playlist entries may be URLs, which ffmpeg opens itself)
+	if probe && !isURL(filePath) {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			log.Printf("[startPlayback] file stat error: %v", err)
+			return newPlayError(errCodeFileMissing, fmt.Errorf("file not accessible: %w", err))
+		}
+		log.Printf("[startPlayback] file exists: %s (size=%d bytes)", filePath, info.Size())
 	}
-	log.Printf("[startPlayback] file exists: %s (size=%d bytes)", filePath, info.Size())
 
 	// ffmpeg presence
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		log.Printf("[startPlayback] ffmpeg not found in PATH: %v", err)
-		return fmt.Errorf("ffmpeg not found in PATH: %w", err)
+		return newPlayError(errCodeFFmpegMissing, fmt.Errorf("ffmpeg not found in PATH: %w", err))
 	}
 	log.Printf("[startPlayback] ffmpeg found on PATH")
 
 	// Probes
-	if err := probeDecode(filePath); err != nil {
+	if !probe {
+		log.Printf("[startPlayback] skipping probes for reader source %s", trackLabel(filePath))
+	} else if err := probeDecode(filePath); err != nil {
 		log.Printf("[startPlayback] decode probe error: %v", err)
-		return err
-	}
-	if err := probeOpusEncode(filePath); err != nil {
+		return newPlayError(errCodeDecodeFailed, err)
+	} else if err := probeOpusEncode(filePath); err != nil {
 		log.Printf("[startPlayback] opus encode probe error: %v", err)
 		log.Printf("[startPlayback] Tip: your ffmpeg likely lacks libopus. Install a full build (e.g., winget install Gyan.FFmpeg or choco install ffmpeg).")
-		return err
+		return newPlayError(errCodeOpusMissing, err)
 	}
 
-	// Stop existing session in this guild if any
+	// Stop existing session in this guild if any, keeping its voice connection
+	// when it's already in the right channel.
+	var vc *discordgo.VoiceConnection
 	if val, ok := playSessions.Load(guildID); ok {
 		old := val.(*guildPlayback)
 		log.Printf("[startPlayback] stopping existing playback for guild=%s", guildID)
-		old.stop()
+		vc = old.handOff(channelID)
 		playSessions.Delete(guildID)
 	}
-
-	// Join voice: mute=false, deaf=false
-	log.Printf("[startPlayback] joining voice channel %s in guild %s", channelID, guildID)
-	vc, err := s.ChannelVoiceJoin(guildID, channelID, false, false)
-	if err != nil {
-		log.Printf("[startPlayback] ChannelVoiceJoin error: %v", err)
-		return fmt.Errorf("failed to join voice channel: %w", err)
+	// A session that just ended may still be idling in voice or playing
+	// LEAVE_SOUND; cut it short and let it leave, or hand its connection
+	// over, before joining.
+	waitLeave(guildID, channelID)
+	if vc == nil {
+		vc = liveVoiceConnection(s, guildID, channelID)
 	}
-	log.Printf("[startPlayback] joined voice; waiting for readiness")
 
-	// Wait for the voice connection to be ready
-	for i := 0; i < 50; i++ {
-		if vc.Ready && vc.OpusSend != nil {
-			break
+	// Spawning ffmpeg and joining voice each take a few hundred ms, so start the
+	// encoder now and let it warm up while we join; run picks it up.
+	first := trackOptions{eq: eqProfile(guildID), night: nightFilter(guildID), start: startSec, bitrate: channelBitrate(ch, guildBitrate(s, guildID))}
+	pre := prefetchTrack(filePath, first)
+	joinStart := time.Now()
+
+	if vc == nil && s.State.User != nil {
+		// Without Connect/Speak the join just times out; say why up front.
+		const need = discordgo.PermissionVoiceConnect | discordgo.PermissionVoiceSpeak
+		if perms, err := s.UserChannelPermissions(s.State.User.ID, channelID); err == nil && perms&need != need {
+			pre.discard()
+			return newPlayError(errCodeNoPermission, fmt.Errorf("missing Connect/Speak in channel %s", channelID))
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
-	if !vc.Ready || vc.OpusSend == nil {
-		log.Printf("[startPlayback] voice connection not ready after wait: Ready=%v OpusSendNil=%v", vc.Ready, vc.OpusSend == nil)
-		_ = vc.Disconnect()
-		return fmt.Errorf("voice connection not ready (Ready=%v, OpusSend nil=%v)", vc.Ready, vc.OpusSend == nil)
+	if vc != nil {
+		log.Printf("[startPlayback] reusing voice connection in channel %s for guild %s", channelID, guildID)
+	} else {
+		// Join voice: never muted; self-deafened unless JOIN_DEAFENED=false
+		log.Printf("[startPlayback] joining voice channel %s in guild %s (deaf=%v)", channelID, guildID, joinDeafened)
+		var err error
+		vc, err = joinVoice(s, guildID, channelID)
+		if err != nil {
+			log.Printf("[startPlayback] %v", err)
+			pre.discard()
+			return err
+		}
+		log.Printf("[startPlayback] voice connection ready after %s", time.Since(joinStart).Round(time.Millisecond))
+	}
+
+	// Save playback session
+	gp := &guildPlayback{
+		guildID:   guildID,
+		channelID: channelID,
+		vc:        vc,
+		origin:    origin,
+		queue:     append([]string(nil), tracks[1:]...),
+		eq:        first.eq,
+		startAt:   startSec,
+		bitrate:   first.bitrate,
 	}
-	log.Printf("[startPlayback] voice connection ready")
+	if !startLifecycle(gp) {
+		log.Printf("[startPlayback] shutting down; not starting playback for guild=%s", guildID)
+		pre.discard()
+		disconnectVoice(vc)
+		return newPlayError(errCodeShuttingDown, errors.New("shutting down"))
+	}
+	rememberChannel(guildID, channelID)
+
+	log.Printf("[startPlayback] launching playback lifecycle goroutine")
+
+	// Use a single goroutine for the entire playback lifecycle.
+	go gp.run(s, channelID, filePath, pre)
 
-	// Encoder options
-	opts := dca.StdEncodeOptions
-	opts.RawOutput = false // <-- THE FIX: Let dca handle Opus encoding.
-	opts.Bitrate = 128     // kbps
-	//opts.Volume = 256      // This is the default volume, good to have explicitly.
+	log.Printf("[startPlayback] started playback for guild=%s channel=%s file=%s queued=%d", guildID, channelID, filePath, len(tracks)-1)
+	return nil
+}
 
-	log.Printf("[startPlayback] starting encoder for file %s", filePath)
-	enc, err := dca.EncodeFile(filePath, opts)
+// channelInfo looks up a channel in the state cache, falling back to a REST
+// fetch on a miss. With only the Guilds/GuildVoiceStates intents the cache can
+// be incomplete, so the fetched channel is added to it for next time.
+func channelInfo(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if ch, err := s.State.Channel(channelID); err == nil {
+		return ch, nil
+	}
+	ch, err := s.Channel(channelID)
 	if err != nil {
-		log.Printf("[startPlayback] EncodeFile error: %v", err)
-		_ = vc.Disconnect()
-		return fmt.Errorf("failed to start ffmpeg/dca encode for %q: %w", filePath, err)
+		return nil, fmt.Errorf("fetch channel %s: %w", channelID, err)
 	}
-	log.Printf("[startPlayback] encoder started successfully")
+	if err := s.State.ChannelAdd(ch); err != nil {
+		log.Printf("[channelInfo] could not cache channel %s: %v", channelID, err)
+	}
+	return ch, nil
+}
 
-	done := make(chan error, 1)
+// voiceNoOpusGrace is how long a connection may report Ready without an
+// opus send channel before waitVoiceReady gives up on it.
+const voiceNoOpusGrace = time.Second
 
-	// Save playback session
-	gp := &guildPlayback{
-		guildID:  guildID,
-		vc:       vc,
-		enc:      enc,
-		doneChan: done,
-		playing:  filePath,
+var (
+	errVoiceNotReady = errors.New("voice connection not ready")
+	// discordgo can mark a connection Ready while OpusSend is still nil, e.g.
+	// a reused connection whose UDP socket didn't reopen. Frames would have
+	// nowhere to go, so the bot would sit in the channel without sound.
+	errVoiceNoOpus = errors.New("voice connection is ready but has no audio channel")
+)
+
+// waitVoiceReady polls until vc can send audio. It returns errVoiceNoOpus once
+// vc has been Ready without an opus channel for voiceNoOpusGrace, and
+// errVoiceNotReady if it isn't ready by the timeout.
+func waitVoiceReady(vc *discordgo.VoiceConnection, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	var readySince time.Time
+	for {
+		vc.RLock()
+		ready, opus := vc.Ready, vc.OpusSend != nil
+		vc.RUnlock()
+		if ready && opus {
+			return nil
+		}
+		if !ready {
+			readySince = time.Time{}
+		} else if readySince.IsZero() {
+			readySince = time.Now()
+		} else if time.Since(readySince) >= voiceNoOpusGrace {
+			return errVoiceNoOpus
+		}
+		if time.Now().After(deadline) {
+			if ready {
+				return errVoiceNoOpus
+			}
+			return errVoiceNotReady
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	playSessions.Store(guildID, gp)
+}
 
-	log.Printf("[startPlayback] launching playback lifecycle goroutine")
+// joinVoice joins channelID and waits until audio can be sent. A connection
+// that comes up without an opus channel is dropped and joined once more,
+// which clears the race; failures come back as playErrors.
+func joinVoice(s *discordgo.Session, guildID, channelID string) (*discordgo.VoiceConnection, error) {
+	for attempt := 1; ; attempt++ {
+		vc, err := s.ChannelVoiceJoin(guildID, channelID, false, joinDeafened)
+		if err != nil {
+			return nil, newPlayError(errCodeVoiceJoin, fmt.Errorf("failed to join voice channel: %w", err))
+		}
+		err = waitVoiceReady(vc, 5*time.Second)
+		if err == nil {
+			return vc, nil
+		}
+		disconnectVoice(vc)
+		if errors.Is(err, errVoiceNoOpus) && attempt == 1 {
+			log.Printf("[voice] guild=%s: %v; joining again", guildID, err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		if errors.Is(err, errVoiceNoOpus) {
+			return nil, newPlayError(errCodeVoiceNoAudio, err)
+		}
+		return nil, newPlayError(errCodeVoiceTimeout, err)
+	}
+}
 
-	// Use a single goroutine for the entire playback lifecycle.
-	go func() {
-		// Defer cleanup tasks to run when this goroutine finishes.
-		defer func() {
-			log.Printf("[startPlayback] stream lifecycle finished, cleaning up...")
-			_ = vc.Speaking(false)
+// run plays filePath and then drains the queue, one track at a time, on the
+// same voice connection. It disconnects once the queue is empty (after
+// IDLE_TIMEOUT) or stop() is called. pre, if not nil, is filePath's encoder
+// already starting up.
+func (gp *guildPlayback) run(s *discordgo.Session, channelID, filePath string, pre *prefetchedTrack) {
+	defer playbacks.Done()
+	vc := gp.vc
+	queueEnded := false
+	// Registered first so it runs after the cleanup below.
+	defer gp.recoverPlayback(s)
+
+	// Defer cleanup tasks to run when this goroutine finishes.
+	defer func() {
+		log.Printf("[playback] stream lifecycle finished, cleaning up...")
+		gp.mu.Lock()
+		handedOff := gp.handedOff
+		gp.mu.Unlock()
+		if !handedOff {
+			if l := gp.takeLeave(queueEnded); l != nil {
+				updatePresence(s)
+				gp.leaveVoice(vc, l)
+			} else {
+				disconnectVoice(vc)
+			}
+		}
+		playSessions.CompareAndDelete(gp.guildID, gp)
+		saveResumeState()
+		updatePresence(s)
+		log.Printf("[playback] playback session cleaned up for guild=%s", gp.guildID)
+	}()
+
+	// Set speaking status
+	if err := vc.Speaking(true); err != nil {
+		log.Printf("[playback] vc.Speaking(true) error: %v", err)
+	}
+
+	// Next track, encoding while the current one plays (see GAPLESS)
+	defer func() { pre.discard() }()
+
+	played := 1
+	for {
+		finished := false
+		var enc trackSource
+		var err error
+		gp.mu.Lock()
+		to := trackOptions{eq: gp.eq, night: nightFilter(gp.guildID), start: gp.startAt, pitch: gp.pitch, bitrate: gp.bitrate}
+		gp.startAt = -1
+		gp.mu.Unlock()
+		if to.start < 0 {
+			to.start = introStart(filePath)
+		}
+		if pre != nil && pre.path == filePath && pre.opts == to {
+			waitStart := time.Now()
+			enc, err = pre.wait()
+			log.Printf("[playback] prefetched encoder for %s started in %s; playback waited %s for it",
+				trackLabel(filePath), pre.startup.Round(time.Millisecond), time.Since(waitStart).Round(time.Millisecond))
+			if errors.Is(err, errFFmpegBusy) && !isReaderTrack(filePath) {
+				// The previous track's slot is free now.
+				enc, err = encodeTrack(filePath, to)
+			}
+		} else {
+			pre.discard()
+			enc, err = encodeTrack(filePath, to)
+		}
+		pre = nil
+		if err != nil {
+			log.Printf("[playback] skipping %s: %v", filePath, err)
+		} else {
+			done := make(chan error, 1)
+			gp.mu.Lock()
+			if gp.stopped {
+				gp.mu.Unlock()
+				enc.Cleanup()
+				return
+			}
+			gp.enc = enc
+			gp.doneChan = done
+			gp.playing = filePath
+			gp.trackStart = to.start
+			gp.night = to.night
+			// The dca.NewStream function is a blocking call that streams audio.
+			// It will send an error to the 'done' channel when it's finished.
+			mon := &underrunMonitor{OpusReader: enc, guildID: gp.guildID}
+			if legacyStream {
+				gp.stream = dca.NewStream(mon, vc, done)
+			} else {
+				st := newStreamer(mon, vc)
+				gp.streamer = st
+				go func() { done <- st.run() }()
+			}
+			if gapless && len(gp.queue) > 0 {
+				next := gp.queue[0]
+				pre = prefetchTrack(next, trackOptions{eq: gp.eq, night: nightFilter(gp.guildID), start: introStart(next), pitch: gp.pitch, bitrate: gp.bitrate})
+			}
+			gp.mu.Unlock()
+
+			saveResumeState()
+			updatePresence(s)
+			recordPlay(gp.origin, gp.guildID, filePath)
+			progressDone := make(chan struct{})
+			line := fmt.Sprintf("Now playing in <#%s>: %s", channelID, trackLabel(filePath))
+			gp.mu.Lock()
+			content := noticeContent(line, "", gp.nextUpLocked())
+			gp.mu.Unlock()
+			if n, ok := announceNotice(s, gp.guildID, gp.origin, content); ok {
+				n.text, n.shown = line, content
+				if n.origin == nil && reactionControls {
+					gp.mu.Lock()
+					gp.nowPlaying = n.msg
+					gp.mu.Unlock()
+					go addControlReactions(s, n.msg)
+				}
+				go trackProgress(s, gp, n, filePath, progressDone)
+			}
+
+			// Wait for the 'done' channel to receive the result from the stream.
+			err = <-done
+			// The encoder is still mid-track, so resume it on a fresh connection.
+			for attempt := 1; !legacyStream && isVoiceSendErr(err) && attempt <= voiceReconnectAttempts; attempt++ {
+				log.Printf("[playback] %v; reconnecting to voice (attempt %d/%d)", err, attempt, voiceReconnectAttempts)
+				newVC, rerr := gp.reconnect(s, channelID)
+				if rerr != nil {
+					log.Printf("[playback] reconnect failed: %v", rerr)
+					break
+				}
+				vc = newVC
+				st := newStreamer(mon, vc)
+				gp.mu.Lock()
+				// Carry the position over so elapsed time doesn't restart at zero.
+				if gp.streamer != nil {
+					st.framesSent.Store(gp.streamer.framesSent.Load())
+				}
+				gp.streamer = st
+				gp.mu.Unlock()
+				go func() { done <- st.run() }()
+				err = <-done
+			}
+			// The encoder ended early; start it again where the stream got to.
+			for attempt := 1; !legacyStream && err == io.EOF && attempt <= ffmpegCrashRetries; attempt++ {
+				stderr, crash := encoderCrash(enc)
+				if crash == nil {
+					break
+				}
+				gp.mu.Lock()
+				pos := gp.elapsedLocked()
+				gp.mu.Unlock()
+				log.Printf("[playback] ffmpeg crashed %s into %s: %v; stderr:\n%s", pos.Round(time.Second), trackLabel(filePath), crash, tailTruncate(stderr, 1500))
+				if isURL(filePath) || isReaderTrack(filePath) {
+					break
+				}
+				if _, serr := os.Stat(filePath); serr != nil {
+					log.Printf("[playback] not restarting %s: %v", trackLabel(filePath), serr)
+					break
+				}
+				log.Printf("[playback] restarting the encoder at %s (attempt %d/%d)", pos.Round(time.Second), attempt, ffmpegCrashRetries)
+				to.start = int(pos.Seconds())
+				newEnc, eerr := encodeTrack(filePath, to)
+				if eerr != nil {
+					log.Printf("[playback] restart failed: %v", eerr)
+					break
+				}
+				mon.summary(trackLabel(filePath))
+				enc.Cleanup()
+				enc = newEnc
+				mon = &underrunMonitor{OpusReader: enc, guildID: gp.guildID}
+				st := newStreamer(mon, vc)
+				gp.mu.Lock()
+				if gp.stopped {
+					gp.mu.Unlock()
+					err = errStreamStopped
+					break
+				}
+				gp.enc = enc
+				gp.streamer = st
+				gp.trackStart = to.start
+				gp.mu.Unlock()
+				go func() { done <- st.run() }()
+				err = <-done
+			}
+			// A crash ends the stream with EOF too; don't report it as finished.
+			if err == io.EOF {
+				if _, crash := encoderCrash(enc); crash != nil {
+					err = fmt.Errorf("ffmpeg crashed: %w", crash)
+				}
+			}
+			close(progressDone)
+			if errors.Is(err, errStreamStopped) {
+				log.Printf("[playback] stream stopped")
+			} else if errors.Is(err, errStreamSkipped) {
+				log.Printf("[playback] track skipped")
+			} else if err != nil && err != io.EOF {
+				log.Printf("[playback] stream finished with an unexpected error: %v", err)
+			} else {
+				log.Printf("[playback] stream finished successfully (EOF)")
+				finished = true
+			}
+			mon.summary(trackLabel(filePath))
 			enc.Cleanup()
-			_ = vc.Disconnect()
-			playSessions.Delete(guildID)
-			log.Printf("[startPlayback] playback session cleaned up for guild=%s", guildID)
-		}()
-
-		// Set speaking status
-		if err := vc.Speaking(true); err != nil {
-			log.Printf("[startPlayback] vc.Speaking(true) error: %v", err)
 		}
 
-		// The dca.NewStream function is a blocking call that streams audio.
-		// It will send an error to the 'done' channel when it's finished.
-		dca.NewStream(enc, vc, done)
+		gp.mu.Lock()
+		gp.nowPlaying = nil
+		gp.enc = nil
+		gp.stream = nil
+		gp.streamer = nil
+		gp.paused = false
+		stopped := gp.stopped
+		next := ""
+		if !stopped && len(gp.queue) > 0 {
+			next = gp.queue[0]
+			gp.queue = gp.queue[1:]
+		}
+		gp.mu.Unlock()
 
-		// Wait for the 'done' channel to receive the result from NewStream.
-		err = <-done
-		if err != nil && err != io.EOF {
-			log.Printf("[startPlayback] stream finished with an unexpected error: %v", err)
-		} else {
-			log.Printf("[startPlayback] stream finished successfully (EOF)")
+		if stopped {
+			return
 		}
-	}()
+		if finished {
+			announce(s, gp.guildID, gp.origin, "Finished playing: "+trackLabel(filePath))
+		}
+		if next == "" {
+			queueEnded = true
+			gp.wantOutro()
+			// Only worth saying for a playlist; a single track already got "Finished playing".
+			if finished && played > 1 && announceQueueEnd {
+				announce(s, gp.guildID, gp.origin, fmt.Sprintf("Queue finished (%d tracks).", played))
+			}
+			return
+		}
+		filePath = next
+		played++
+	}
+}
 
-	log.Printf("[startPlayback] started playback for guild=%s channel=%s file=%s", guildID, channelID, filePath)
-	return nil
+// pickerContent is the message text above the sound picker.
+func pickerContent(state *browserState) string {
+	var filters []string
+	if state.Type != "" {
+		filters = append(filters, "type: "+state.Type)
+	}
+	if state.Tags != "" {
+		filters = append(filters, state.Tags)
+	}
+	if state.Dir == "." {
+		filters = append(filters, "folder: top level")
+	} else if state.Dir != "" {
+		filters = append(filters, "folder: "+state.Dir)
+	}
+	if state.Query != "" {
+		filters = append(filters, fmt.Sprintf("search: %q", state.Query))
+	}
+	content := "Select a sound to play"
+	if len(filters) > 0 {
+		content = fmt.Sprintf("Select a sound to play (%s, %d of %d)", strings.Join(filters, ", "), len(state.Files), state.LibrarySize)
+	}
+	if state.SortBy != "" && state.SortBy != "name" {
+		content += "\nSorted: " + sortLabels[state.SortBy] + " first"
+	}
+	return content
 }
 
 func buildSoundPickerComponents(state *browserState) []discordgo.MessageComponent {
@@ -482,20 +1697,17 @@ func buildSoundPickerComponents(state *browserState) []discordgo.MessageComponen
 	if end > len(state.Files) {
 		end = len(state.Files)
 	}
+	labels := pickerLabels(state.Files[start:end])
 	options := make([]discordgo.SelectMenuOption, 0, end-start)
 	for idx := start; idx < end; idx++ {
-		label := displayName(state.Files[idx])
-		// Ensure label under 100 chars
-		if len(label) > 100 {
-			label = label[:100]
-		}
+		label := labels[idx-start]
 		options = append(options, discordgo.SelectMenuOption{
 			Label: label,
 			Value: strconv.Itoa(idx),
 		})
 	}
 
-	maxPage := (len(state.Files) - 1) / pageSize
+	maxPage := state.maxPage()
 	prevDisabled := state.Page <= 0
 	nextDisabled := state.Page >= maxPage
 
@@ -503,10 +1715,10 @@ func buildSoundPickerComponents(state *browserState) []discordgo.MessageComponen
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
 				discordgo.SelectMenu{
-					CustomID:    "sound_select",
-					Placeholder: "Pick a sound",
+					CustomID:    browserID(state, "sound_select"),
+					Placeholder: "Pick one or more sounds",
 					MinValues:   intPtr(1),
-					MaxValues:   1,
+					MaxValues:   max(len(options), 1),
 					Options:     options,
 				},
 			},
@@ -514,116 +1726,195 @@ func buildSoundPickerComponents(state *browserState) []discordgo.MessageComponen
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
 				discordgo.Button{
-					CustomID: "sounds_prev",
+					CustomID: browserID(state, "sounds_prev"),
 					Label:    "Prev",
 					Style:    discordgo.SecondaryButton,
 					Disabled: prevDisabled,
 				},
 				discordgo.Button{
-					CustomID: "sounds_next",
+					CustomID: browserID(state, "sounds_next"),
 					Label:    "Next",
 					Style:    discordgo.SecondaryButton,
 					Disabled: nextDisabled,
 				},
 				discordgo.Button{
-					CustomID: "sounds_cancel",
+					CustomID: browserID(state, "sounds_jump"),
+					Label:    fmt.Sprintf("Page %d/%d", state.Page+1, maxPage+1),
+					Style:    discordgo.SecondaryButton,
+					Disabled: maxPage == 0,
+				},
+				discordgo.Button{
+					CustomID: browserID(state, "sounds_search"),
+					Label:    "Search",
+					Style:    discordgo.PrimaryButton,
+				},
+				discordgo.Button{
+					CustomID: browserID(state, "sounds_cancel"),
 					Label:    "Cancel",
 					Style:    discordgo.DangerButton,
 				},
 			},
 		},
+		discordgo.ActionsRow{Components: buildSortButtons(state)},
 	}
 }
 
-func buildVoiceChannelPickerComponents(s *discordgo.Session, guildID string) []discordgo.MessageComponent {
-	chans, err := s.GuildChannels(guildID)
-	if err != nil {
-		// In case of error, return only a back button
-		return []discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						CustomID: "back_to_sounds",
-						Label:    "Back",
-						Style:    discordgo.SecondaryButton,
-					},
-				},
-			},
+// buildSortButtons offers each sort order; the active one is highlighted.
+func buildSortButtons(state *browserState) []discordgo.MessageComponent {
+	var buttons []discordgo.MessageComponent
+	for _, by := range []string{"name", "mtime", "size"} {
+		active := state.SortBy == by || (state.SortBy == "" && by == "name")
+		style := discordgo.SecondaryButton
+		if active {
+			style = discordgo.PrimaryButton
 		}
+		buttons = append(buttons, discordgo.Button{
+			CustomID: browserID(state, "sounds_sort_"+by),
+			Label:    "Sort: " + sortLabels[by],
+			Style:    style,
+			Disabled: active,
+		})
 	}
-
-	var voiceChans []*discordgo.Channel
-	for _, c := range chans {
-		if c.Type == discordgo.ChannelTypeGuildVoice || c.Type == discordgo.ChannelTypeGuildStageVoice {
-			voiceChans = append(voiceChans, c)
-		}
+	if state.Dir != "" {
+		buttons = append(buttons, discordgo.Button{
+			CustomID: browserID(state, "sounds_dir_all"),
+			Label:    "All folders",
+			Style:    discordgo.SecondaryButton,
+		})
+	} else {
+		_, playing := nowPlayingDir(state.GuildID)
+		buttons = append(buttons, discordgo.Button{
+			CustomID: browserID(state, "sounds_dir_playing"),
+			Label:    "Now playing folder",
+			Style:    discordgo.SecondaryButton,
+			Disabled: !playing,
+		})
 	}
+	return buttons
+}
 
-	// Sort by position/name
-	sort.SliceStable(voiceChans, func(i, j int) bool {
-		if voiceChans[i].Position == voiceChans[j].Position {
-			return strings.ToLower(voiceChans[i].Name) < strings.ToLower(voiceChans[j].Name)
-		}
-		return voiceChans[i].Position < voiceChans[j].Position
-	})
-
-	// Build up to 25 options
-	max := len(voiceChans)
-	if max > pageSize {
-		max = pageSize
+// nowPlayingDir returns the library folder of the guild's current track, in
+// the browser's relative form ("." for the top level). ok is false if nothing
+// is playing or the track isn't from the library (e.g. a playlist URL).
+func nowPlayingDir(guildID string) (dir string, ok bool) {
+	val, found := playSessions.Load(guildID)
+	if !found {
+		return "", false
 	}
-	options := make([]discordgo.SelectMenuOption, 0, max)
-	for idx := 0; idx < max; idx++ {
-		c := voiceChans[idx]
-		label := c.Name
-		if len(label) > 100 {
-			label = label[:100]
-		}
-		options = append(options, discordgo.SelectMenuOption{
-			Label: label,
-			Value: c.ID,
-		})
+	gp := val.(*guildPlayback)
+	gp.mu.Lock()
+	playing := gp.playing
+	gp.mu.Unlock()
+	if playing == "" || isURL(playing) {
+		return "", false
+	}
+	rel, err := filepath.Rel(soundsDir, playing)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
 	}
+	return path.Dir(filepath.ToSlash(rel)), true
+}
 
-	rows := []discordgo.MessageComponent{
+// buildCancelConfirmComponents asks whether Cancel should stop playback too.
+func buildCancelConfirmComponents(state *browserState) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
-				discordgo.SelectMenu{
-					CustomID:    "voice_select",
-					Placeholder: "Pick a voice channel",
-					MinValues:   intPtr(1),
-					MaxValues:   1,
-					Options:     options,
-				},
+				discordgo.Button{CustomID: browserID(state, "sounds_cancel_stop"), Label: "Stop playback", Style: discordgo.DangerButton},
+				discordgo.Button{CustomID: browserID(state, "sounds_cancel_close"), Label: "Just close", Style: discordgo.SecondaryButton},
 			},
 		},
+	}
+}
+
+// browserPlayback returns the guild's playback session if this browser started
+// it, or nil if nothing is playing or someone else has since replaced it.
+func browserPlayback(guildID string, state *browserState) *guildPlayback {
+	if state.StartedBy == "" {
+		return nil
+	}
+	val, ok := playSessions.Load(guildID)
+	if !ok {
+		return nil
+	}
+	gp := val.(*guildPlayback)
+	if gp.origin == nil || gp.origin.ID != state.StartedBy {
+		return nil
+	}
+	return gp
+}
+
+// buildVoiceChannelPickerComponents offers Discord's channel select restricted
+// to voice and stage channels; Discord lists and searches them, so servers
+// with more than 25 voice channels need no paging.
+func buildVoiceChannelPickerComponents(s *discordgo.Session, guildID string, state *browserState) []discordgo.MessageComponent {
+	backRow := []discordgo.MessageComponent{
+		discordgo.Button{
+			CustomID: browserID(state, "back_to_sounds"),
+			Label:    "Back",
+			Style:    discordgo.SecondaryButton,
+		},
+	}
+	if last := lastChannel(s, guildID); last != nil {
+		backRow = append([]discordgo.MessageComponent{discordgo.Button{
+			CustomID: browserID(state, "voice_last"),
+			Label:    tailTruncate("Play in last channel: "+last.Name, 80),
+			Style:    discordgo.PrimaryButton,
+		}}, backRow...)
+	}
+
+	rows := []discordgo.MessageComponent{
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					CustomID: "back_to_sounds",
-					Label:    "Back",
-					Style:    discordgo.SecondaryButton,
+				discordgo.SelectMenu{
+					MenuType:     discordgo.ChannelSelectMenu,
+					CustomID:     browserID(state, "voice_select"),
+					Placeholder:  "Pick a voice channel",
+					MinValues:    intPtr(1),
+					MaxValues:    1,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
 				},
 			},
 		},
+		discordgo.ActionsRow{Components: backRow},
 	}
 
 	return rows
 }
 
-func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) {
-	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+// respondPrivate replies only the invoker can see, whatever EPHEMERAL_RESPONSES
+// says. It's for errors, refusals and anything that shows host details.
+func respondPrivate(s *discordgo.Session, i *discordgo.InteractionCreate, content string) error {
+	return interactionRespond(s, i, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content:    content,
-			Flags:      discordgo.MessageFlagsEphemeral,
-			Components: components,
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+}
+
+// respondEphemeral replies only the invoker can see, unless EPHEMERAL_RESPONSES
+// is off, in which case the reply is public. Mentions in it never ping.
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) error {
+	var flags discordgo.MessageFlags
+	if ephemeralResponses {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	return interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           flags,
+			Components:      components,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
 		},
 	})
 }
 
-func respondUpdate(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) {
-	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+func respondUpdate(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) error {
+	return interactionRespond(s, i, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseUpdateMessage,
 		Data: &discordgo.InteractionResponseData{
 			Content:    content,
@@ -632,18 +1923,197 @@ func respondUpdate(s *discordgo.Session, i *discordgo.InteractionCreate, content
 	})
 }
 
+func respondModal(s *discordgo.Session, i *discordgo.InteractionCreate, customID, title string, inputs ...discordgo.TextInput) error {
+	rows := make([]discordgo.MessageComponent, 0, len(inputs))
+	for _, in := range inputs {
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{in}})
+	}
+	return interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   customID,
+			Title:      title,
+			Components: rows,
+		},
+	})
+}
+
+// Single choke point for interaction responses. Discord's component validation
+// errors are opaque ("Invalid Form Body"), so dump the components we sent.
+func interactionRespond(s *discordgo.Session, i *discordgo.InteractionCreate, resp *discordgo.InteractionResponse) error {
+	err := s.InteractionRespond(i.Interaction, resp)
+	if err != nil && resp.Data != nil && len(resp.Data.Components) > 0 {
+		payload, jerr := json.Marshal(resp.Data.Components)
+		if jerr != nil {
+			payload = []byte(jerr.Error())
+		}
+		log.Printf("[interactionRespond] component send failed for %s: %v; components:\n%s", interactionLabel(i), err, payload)
+	}
+	return err
+}
+
+// logRespondErr logs a failed response with enough context to find the caller.
+func logRespondErr(i *discordgo.InteractionCreate, err error) {
+	if err != nil {
+		log.Printf("[respond] failed to respond to %s guild=%s: %v", interactionLabel(i), i.GuildID, err)
+	}
+}
+
+func interactionLabel(i *discordgo.InteractionCreate) string {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		return "command=/" + i.ApplicationCommandData().Name
+	case discordgo.InteractionMessageComponent:
+		return "custom_id=" + i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		return "modal custom_id=" + i.ModalSubmitData().CustomID
+	default:
+		return "interaction type=" + i.Type.String()
+	}
+}
+
+// announce posts a notice to the guild's announce channel so everyone can see it.
+// Falls back to an ephemeral followup on the originating interaction when no
+// channel is configured or the bot can't post there.
+//
+// It returns the public channel message, or nil when the notice went out as an
+// (ephemeral) followup or not at all.
+func announce(s *discordgo.Session, guildID string, origin *discordgo.Interaction, content string) *discordgo.Message {
+	if n, ok := announceNotice(s, guildID, origin, content); ok && n.origin == nil {
+		return n.msg
+	}
+	return nil
+}
+
+// announceNotice is announce, but also returns the private followup it fell
+// back to, so the notice can be edited later. ok is false if nothing was sent.
+func announceNotice(s *discordgo.Session, guildID string, origin *discordgo.Interaction, content string) (n nowPlayingNotice, ok bool) {
+	n.text = content
+	if cid, ok := announceChannels[guildID]; ok {
+		msg, err := s.ChannelMessageSendComplex(cid, &discordgo.MessageSend{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err == nil {
+			n.msg = msg
+			return n, true
+		}
+		log.Printf("[announce] failed to post in channel %s for guild=%s, falling back to followup: %v", cid, guildID, err)
+	}
+	if origin == nil {
+		return n, false
+	}
+	msg, err := s.FollowupMessageCreate(origin, true, &discordgo.WebhookParams{
+		Content: content,
+		Flags:   discordgo.MessageFlagsEphemeral,
+	})
+	if err != nil {
+		log.Printf("[announce] followup failed for guild=%s: %v", guildID, err)
+		return n, false
+	}
+	n.msg, n.origin = msg, origin
+	return n, true
+}
+
+// browserID tags a component custom ID with the browser's generation.
+func browserID(state *browserState, base string) string {
+	return componentID(base + ":" + strconv.FormatUint(state.Gen, 10))
+}
+
+// splitCustomID undoes browserID. IDs without a generation return gen 0.
+func splitCustomID(customID string) (base string, gen uint64) {
+	customID = resolveComponentID(customID)
+	base, rest, ok := strings.Cut(customID, ":")
+	if !ok {
+		return customID, 0
+	}
+	gen, _ = strconv.ParseUint(rest, 10, 64)
+	return base, gen
+}
+
+// lookupBrowser returns the caller's browser state if the interaction came from
+// its current menu. Otherwise it answers the interaction and returns false.
+func lookupBrowser(s *discordgo.Session, i *discordgo.InteractionCreate, gen uint64) (*browserState, bool) {
+	browserStates.Lock()
+	state, ok := browserStates.data[browserKey(i)]
+	browserStates.Unlock()
+	if !ok {
+		logRespondErr(i, respondUpdate(s, i, sessionExpiredMsg(), nil))
+		return nil, false
+	}
+	if state.Gen != gen {
+		logRespondErr(i, respondUpdate(s, i, "This menu is outdated, run /"+soundsCmdName+" again.", []discordgo.MessageComponent{}))
+		return nil, false
+	}
+	return state, true
+}
+
+// componentOwner returns the user a clicked menu belongs to: the invoker of
+// the browser with that generation, or else of the command the message
+// answered. Public menus (EPHEMERAL_RESPONSES=false) can be clicked by anyone,
+// and state is per user, so another user's click must not touch it.
+func componentOwner(i *discordgo.InteractionCreate, gen uint64) string {
+	if gen != 0 {
+		browserStates.Lock()
+		for _, st := range browserStates.data {
+			if st.Gen == gen {
+				browserStates.Unlock()
+				return st.InvokerID
+			}
+		}
+		browserStates.Unlock()
+	}
+	if m := i.Message; m != nil && m.InteractionMetadata != nil && m.InteractionMetadata.User != nil {
+		return m.InteractionMetadata.User.ID
+	}
+	return ""
+}
+
+func sessionExpiredMsg() string {
+	return "Session expired. Run /" + soundsCmdName + " again."
+}
+
 func browserKey(i *discordgo.InteractionCreate) string {
-	uid := ""
+	return interactionUserID(i) + ":" + i.GuildID
+}
+
+func interactionUserID(i *discordgo.InteractionCreate) string {
 	if i.Member != nil && i.Member.User != nil {
-		uid = i.Member.User.ID
+		return i.Member.User.ID
 	} else if i.User != nil {
-		uid = i.User.ID
+		return i.User.ID
 	}
-	return uid + ":" + i.GuildID
+	return ""
 }
 
-func listAudioFiles(root string) ([]string, error) {
+// isOwner reports whether the invoking user is the configured OWNER_ID.
+func isOwner(i *discordgo.InteractionCreate) bool {
+	return ownerID != "" && interactionUserID(i) == ownerID
+}
+
+// audioFile is what the browser needs to sort a library entry.
+type audioFile struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// scanLibrary lists playable files (sorted, relative to root) together with
+// their mod time and size, so sorting the browser needs no further stat calls.
+func scanLibrary(root string, exts extSet) ([]string, map[string]audioFile, error) {
+	// WalkDir would report a missing root to the callback below, which skips
+	// it, so a deleted or unmounted library would look merely empty.
+	if info, err := os.Stat(root); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Printf("[library] sounds directory %s is missing", root)
+			return nil, nil, fmt.Errorf("%w: %s", errSoundsDirMissing, root)
+		}
+		return nil, nil, err
+	} else if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", root)
+	}
+
 	var out []string
+	index := make(map[string]audioFile)
 	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			// Skip unreadable subtrees but continue scanning others
@@ -653,20 +2123,131 @@ func listAudioFiles(root string) ([]string, error) {
 			return nil
 		}
 		ext := strings.ToLower(filepath.Ext(d.Name()))
-		if _, ok := allowedExts[ext]; ok {
+		_, isAudio := exts[ext]
+		_, isList := playlistExts[ext]
+		if isAudio || isList {
 			rel, err := filepath.Rel(root, path)
 			if err != nil {
 				rel = d.Name()
 			}
-			out = append(out, filepath.ToSlash(rel))
+			rel = filepath.ToSlash(rel)
+			out = append(out, rel)
+			if info, err := d.Info(); err == nil {
+				index[rel] = audioFile{ModTime: info.ModTime(), Size: info.Size()}
+			}
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	sort.Strings(out)
-	return out, nil
+	return out, index, nil
+}
+
+// errSoundsDirMissing means the library root itself is gone (deleted, or a
+// volume that isn't mounted), as opposed to a problem inside it. Every scan
+// starts from scratch, so the library is back as soon as the directory is.
+var errSoundsDirMissing = errors.New("sounds directory is missing")
+
+// scanErrorMessage turns a scanLibrary error into a reply users can act on.
+func scanErrorMessage(err error) string {
+	if errors.Is(err, errSoundsDirMissing) {
+		return fmt.Sprintf("The sounds folder (%s) is missing or not mounted right now. Ask whoever hosts the bot to restore it, then try again; no restart is needed.", soundsDir)
+	}
+	return fmt.Sprintf("Error scanning sounds: %v", err)
+}
+
+// fileTypeChoices lists the values of the /sounds type option: each audio
+// extension, plus "playlist" for all playlist formats.
+func fileTypeChoices() []*discordgo.ApplicationCommandOptionChoice {
+	exts := make([]string, 0, len(allowedExts))
+	for ext := range allowedExts {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	sort.Strings(exts)
+	exts = append(exts, "playlist")
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(exts))
+	for _, e := range exts {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: e, Value: e})
+	}
+	return choices
+}
+
+// filterByType keeps the files matching a fileTypeChoices value.
+func filterByType(files []string, fileType string) []string {
+	var out []string
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f))
+		if (fileType == "playlist" && isPlaylist(f)) || ext == "."+fileType {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// librarySound cleans a user-supplied path to a single sound in the library,
+// relative to soundsDir, rejecting anything outside it, missing, a playlist,
+// or of a type guildID's /extensions leaves out.
+func librarySound(guildID, rel string) (string, error) {
+	sound := filepath.ToSlash(filepath.Clean(strings.TrimSpace(rel)))
+	if filepath.IsAbs(sound) || sound == ".." || strings.HasPrefix(sound, "../") {
+		return "", fmt.Errorf("the sound must be a path inside the sounds directory")
+	}
+	if _, err := os.Stat(filepath.Join(soundsDir, sound)); err != nil || isPlaylist(sound) {
+		return "", fmt.Errorf("no sound file %q in the library", sound)
+	}
+	if exts := effectiveExts(guildID); !exts.allows(sound) {
+		return "", fmt.Errorf("%q isn't one of this server's file types (%s)", sound, exts)
+	}
+	return sound, nil
+}
+
+// trackLabel returns the display name of a file, relative to soundsDir when possible.
+func trackLabel(filePath string) string {
+	if isReaderTrack(filePath) {
+		return readerLabel(filePath)
+	}
+	if rel, err := filepath.Rel(soundsDir, filePath); err == nil && !strings.HasPrefix(rel, "..") {
+		return displayName(filepath.ToSlash(rel))
+	}
+	if isURL(filePath) {
+		// Drop the query string, e.g. the signature on Discord attachment links.
+		if u, err := url.Parse(filePath); err == nil && path.Base(u.Path) != "/" {
+			return displayName(path.Base(u.Path))
+		}
+	}
+	return displayName(filepath.Base(filePath))
+}
+
+// pickerLabels returns select-menu labels for files. Long labels are cut from
+// the left so the file name survives Discord's 100-char limit, and labels that
+// still look identical (intro.mp3 next to intro.wav, or long paths sharing a
+// tail) fall back to the full path with its extension.
+func pickerLabels(files []string) []string {
+	labels := make([]string, len(files))
+	seen := make(map[string]int, len(files))
+	for idx, f := range files {
+		labels[idx] = tailTruncate(displayName(f), 100)
+		seen[labels[idx]]++
+	}
+	for idx, f := range files {
+		if seen[labels[idx]] > 1 {
+			log.Printf("[picker] ambiguous label %q, showing %q", labels[idx], f)
+			labels[idx] = tailTruncate(f, 100)
+		}
+	}
+	return labels
+}
+
+// tailTruncate keeps the last n runes of s, marking the cut with "…".
+func tailTruncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return "…" + string(r[len(r)-n+1:])
 }
 
 func displayName(rel string) string {
@@ -678,10 +2259,187 @@ func displayName(rel string) string {
 	return base
 }
 
-func waitForSignal() {
+// loadToken returns the bot token and where it came from. DISCORD_TOKEN_FILE
+// (e.g. a Docker/Kubernetes secret mount) wins over DISCORD_TOKEN.
+func loadToken() (token, source string, err error) {
+	if path := os.Getenv("DISCORD_TOKEN_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("reading DISCORD_TOKEN_FILE: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+		if token == "" {
+			return "", "", fmt.Errorf("DISCORD_TOKEN_FILE %s is empty", path)
+		}
+		if os.Getenv("DISCORD_TOKEN") != "" {
+			log.Printf("Both DISCORD_TOKEN_FILE and DISCORD_TOKEN are set; using the file")
+		}
+		return token, "DISCORD_TOKEN_FILE (" + path + ")", nil
+	}
+	return os.Getenv("DISCORD_TOKEN"), "DISCORD_TOKEN", nil
+}
+
+// loadConfig reads optional settings from the environment. Call after godotenv.Load.
+func loadConfig() {
+	ownerID = strings.TrimSpace(os.Getenv("OWNER_ID"))
+
+	// Let servers avoid clashes with other bots, e.g. SOUNDS_CMD_NAME=play
+	soundsCmdName = commandNameFromEnv("SOUNDS_CMD_NAME", soundsCmdName)
+	stopCmdName = commandNameFromEnv("STOP_CMD_NAME", stopCmdName)
+	if soundsCmdName == stopCmdName {
+		log.Fatalf("SOUNDS_CMD_NAME and STOP_CMD_NAME must differ (both are %q)", soundsCmdName)
+	}
+	if name := commandNameClash(); name != "" {
+		log.Fatalf("SOUNDS_CMD_NAME/STOP_CMD_NAME: /%s is already one of the bot's commands; pick another name", name)
+	}
+
+	if p := strings.TrimSpace(os.Getenv("FFMPEG_PATH")); p != "" {
+		if err := configureFFmpeg(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	joinDeafened = getenvBool("JOIN_DEAFENED", joinDeafened)
+	legacyStream = getenvBool("LEGACY_STREAM", legacyStream)
+	opusPassthrough = getenvBool("OPUS_PASSTHROUGH", opusPassthrough)
+	normalizeLoudness = getenvBool("NORMALIZE_LOUDNESS", normalizeLoudness)
+	resumeOnStart = getenvBool("RESUME_ON_START", resumeOnStart)
+	if v := strings.TrimSpace(os.Getenv("LOUDNESS_TARGET")); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < -40 || t > -5 {
+			log.Printf("Warning: LOUDNESS_TARGET=%q must be between -40 and -5 (LUFS); using %.0f", v, loudnessTarget)
+		} else {
+			loudnessTarget = t
+		}
+	}
+	announceQueueEnd = getenvBool("ANNOUNCE_QUEUE_END", announceQueueEnd)
+	ephemeralResponses = getenvBool("EPHEMERAL_RESPONSES", ephemeralResponses)
+	cancelStopsPlayback = getenvBool("CANCEL_STOPS_PLAYBACK", cancelStopsPlayback)
+	confirmStop = getenvBool("CONFIRM_STOP", confirmStop)
+	reactionControls = getenvBool("REACTION_CONTROLS", reactionControls)
+	extraIntents = parseIntents(os.Getenv("GATEWAY_INTENTS"))
+	skipChannelPicker = getenvBool("SKIP_CHANNEL_PICKER", skipChannelPicker)
+	requireSameVC = getenvBool("REQUIRE_SAME_VC", requireSameVC)
+	gapless = getenvBool("GAPLESS", gapless)
+	idleStatus = strings.TrimSpace(os.Getenv("IDLE_STATUS"))
+	presenceText = strings.TrimSpace(os.Getenv("PRESENCE_TEXT"))
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("PRESENCE_TYPE"))); v != "" {
+		if t, ok := presenceTypes[v]; ok {
+			presenceType = t
+		} else {
+			log.Printf("Warning: PRESENCE_TYPE=%q must be playing, listening, watching or competing; using playing", v)
+		}
+	}
+	restartExec = getenvBool("RESTART_EXEC", restartExec)
+	gracefulDrain = getenvBool("GRACEFUL_DRAIN", gracefulDrain)
+	if n := getenvInt("GRACEFUL_DRAIN_TIMEOUT", 0); n > 0 {
+		drainTimeout = time.Duration(n) * time.Second
+	}
+	if v := strings.TrimSpace(os.Getenv("ALLOWED_EXTS")); v != "" {
+		exts, err := parseExtList(v)
+		if err != nil {
+			log.Printf("Warning: ignoring ALLOWED_EXTS=%q: %v", v, err)
+		} else {
+			allowedExts = exts
+		}
+	}
+	controlAddr = strings.TrimSpace(os.Getenv("CONTROL_ADDR"))
+	controlToken = strings.TrimSpace(os.Getenv("CONTROL_TOKEN"))
+	if v := strings.TrimSpace(os.Getenv("IDLE_TIMEOUT")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Warning: IDLE_TIMEOUT=%q is not a duration like 5m; leaving voice as soon as the queue ends", v)
+		} else {
+			idleTimeout = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("JOIN_SOUND_COOLDOWN")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Printf("Warning: JOIN_SOUND_COOLDOWN=%q is not a duration like 5m; using %s", v, joinSoundCooldown)
+		} else {
+			joinSoundCooldown = d
+		}
+	}
+	maxQueue = queueLimit()
+	fadeInMS = max(getenvInt("FADE_IN_MS", fadeInMS), 0)
+	fadeOutMS = max(getenvInt("FADE_OUT_MS", fadeInMS), 0)
+	trimSilence = getenvBool("TRIM_SILENCE", trimSilence)
+	fadeOutStop = getenvBool("FADE_OUT_STOP", fadeOutStop)
+	progressUpdates = getenvBool("PROGRESS_UPDATES", progressUpdates)
+	ffmpegCrashRetries = max(getenvInt("FFMPEG_CRASH_RETRIES", ffmpegCrashRetries), 0)
+	leaveSound = getenv("LEAVE_SOUND", leaveSound)
+
+	shardCount = getenvInt("SHARD_COUNT", 1)
+	shardID = getenvInt("SHARD_ID", 0)
+	if shardCount < 1 || shardID < 0 || shardID >= shardCount {
+		log.Fatalf("invalid sharding config: SHARD_ID=%d SHARD_COUNT=%d (need 0 <= SHARD_ID < SHARD_COUNT)", shardID, shardCount)
+	}
+
+	// ANNOUNCE_CHANNEL=guildID:channelID[,guildID:channelID...]
+	announceChannels = parseGuildChannelMap("ANNOUNCE_CHANNEL", os.Getenv("ANNOUNCE_CHANNEL"))
+	// SCHEDULE_CHANNEL=guildID:channelID[,...], same format
+	scheduleChannels = parseGuildChannelMap("SCHEDULE_CHANNEL", os.Getenv("SCHEDULE_CHANNEL"))
+	// LIBRARY_NOTIFY_CHANNEL=channelID, one channel for every guild
+	libraryNotifyChannel = strings.TrimSpace(os.Getenv("LIBRARY_NOTIFY_CHANNEL"))
+	// DJ_ROLE=guildID:roleID[,...], same format
+	djRoleEnv = parseGuildChannelMap("DJ_ROLE", os.Getenv("DJ_ROLE"))
+	switch v := strings.ToLower(getenv("DJ_SCOPE", djScope)); v {
+	case djScopeControls, djScopePlay, djScopeAll:
+		djScope = v
+	default:
+		log.Printf("Warning: DJ_SCOPE must be controls, play or all; using %s", djScope)
+	}
+
+	if n := getenvInt("MAX_FFMPEG_PROCS", 0); n > 0 {
+		ffmpegSlots = make(chan struct{}, n)
+	}
+	bufferedFrames = getenvInt("BUFFERED_FRAMES", bufferedFrames)
+	if bufferedFrames < 1 {
+		log.Printf("Warning: BUFFERED_FRAMES must be at least 1; using %d", dca.StdEncodeOptions.BufferedFrames)
+		bufferedFrames = dca.StdEncodeOptions.BufferedFrames
+	}
+	packetLoss = getenvInt("OPUS_PACKET_LOSS", packetLoss)
+	if packetLoss < 0 || packetLoss > 100 {
+		log.Printf("Warning: OPUS_PACKET_LOSS must be 0-100 (percent); using %d", dca.StdEncodeOptions.PacketLoss)
+		packetLoss = dca.StdEncodeOptions.PacketLoss
+	}
+	audioChannels = getenvInt("AUDIO_CHANNELS", audioChannels)
+	if audioChannels != 1 && audioChannels != 2 {
+		log.Printf("Warning: AUDIO_CHANNELS must be 1 (mono) or 2 (stereo); using %d", dca.StdEncodeOptions.Channels)
+		audioChannels = dca.StdEncodeOptions.Channels
+	}
+}
+
+// waitForSignal blocks until SIGINT/SIGTERM or a /restart, reporting which.
+func waitForSignal() (restart bool) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	<-sigCh
+	select {
+	case <-sigCh:
+		return false
+	case <-restartCh:
+		return true
+	}
+}
+
+// parseGuildChannelMap parses "guildID:channelID" pairs separated by commas.
+func parseGuildChannelMap(name, v string) map[string]string {
+	out := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		gid, cid, ok := strings.Cut(pair, ":")
+		gid, cid = strings.TrimSpace(gid), strings.TrimSpace(cid)
+		if !ok || gid == "" || cid == "" {
+			log.Printf("Warning: ignoring malformed %s entry %q (expected guildID:channelID)", name, pair)
+			continue
+		}
+		out[gid] = cid
+	}
+	return out
 }
 
 func getenv(k, def string) string {
@@ -690,3 +2448,68 @@ func getenv(k, def string) string {
 	}
 	return def
 }
+
+func commandNameFromEnv(k, def string) string {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	if !commandNameRe.MatchString(v) || strings.ToLower(v) != v {
+		log.Printf("Warning: %s=%q is not a valid command name (1-32 lowercase letters, digits, - or _); using %q", k, v, def)
+		return def
+	}
+	return v
+}
+
+// commandNameClash returns a slash command name registered twice, e.g. when
+// STOP_CMD_NAME=pause takes a built-in command's name, or "". Discord would
+// reject the whole registration.
+func commandNameClash() string {
+	seen := map[string]bool{}
+	for _, cmd := range slashCommands() {
+		if cmd.Type != 0 && cmd.Type != discordgo.ChatApplicationCommand {
+			continue // context menu commands have names of their own
+		}
+		if seen[cmd.Name] {
+			return cmd.Name
+		}
+		seen[cmd.Name] = true
+	}
+	return ""
+}
+
+func getenvBool(k string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a boolean; using %v", k, v, def)
+		return def
+	}
+	return b
+}
+
+// queueLimit reads MAX_QUEUE; unset or negative means defaultMaxQueue.
+func queueLimit() int {
+	n := getenvInt("MAX_QUEUE", defaultMaxQueue)
+	if n < 0 {
+		log.Printf("Warning: MAX_QUEUE must not be negative; using %d", defaultMaxQueue)
+		return defaultMaxQueue
+	}
+	return n
+}
+
+func getenvInt(k string, def int) int {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a number; using %d", k, v, def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// TestMain keeps handler logs (and the stack traces of deliberate panics) out
+// of the output unless the tests run with -v.
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// testLibrary points soundsDir and dataDir at a fresh temp dir for the test
+// and creates the given files (relative to soundsDir) in it, empty.
+func testLibrary(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	oldSounds, oldData := soundsDir, dataDir
+	soundsDir, dataDir = filepath.Join(dir, "sounds"), filepath.Join(dir, "data")
+	t.Cleanup(func() { soundsDir, dataDir = oldSounds, oldData })
+	if err := os.MkdirAll(soundsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		writeTestFile(t, f, "")
+	}
+	return soundsDir
+}
+
+// writeTestFile writes content to rel inside soundsDir.
+func writeTestFile(t *testing.T, rel, content string) {
+	t.Helper()
+	path := filepath.Join(soundsDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testPlaylist writes an m3u playlist of n new tracks and returns its name.
+func testPlaylist(t *testing.T, name string, n int) string {
+	t.Helper()
+	var lines []string
+	for k := range n {
+		track := fmt.Sprintf("%s-%03d.mp3", strings.TrimSuffix(name, ".m3u"), k)
+		writeTestFile(t, track, "")
+		lines = append(lines, track)
+	}
+	writeTestFile(t, name, strings.Join(lines, "\n")+"\n")
+	return name
+}
+
+func setMaxQueue(t *testing.T, n int) {
+	t.Helper()
+	old := maxQueue
+	maxQueue = n
+	t.Cleanup(func() { maxQueue = old })
+}
+
+func TestQueueLimit(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want int
+	}{
+		{"", defaultMaxQueue},
+		{"5", 5},
+		{"0", 0},
+		{"-1", defaultMaxQueue},
+		{"lots", defaultMaxQueue},
+	} {
+		t.Setenv("MAX_QUEUE", tc.env)
+		if got := queueLimit(); got != tc.want {
+			t.Errorf("MAX_QUEUE=%q: got %d, want %d", tc.env, got, tc.want)
+		}
+	}
+}
+
+// The track that starts playing doesn't count against MAX_QUEUE, so a
+// playlist of limit+1 tracks fills the queue exactly.
+func TestSelectionTracksQueueLimit(t *testing.T) {
+	t.Setenv("MAX_QUEUE", "")
+	for _, tc := range []struct {
+		name    string
+		limit   int
+		entries int
+		ok      bool
+	}{
+		{"below the limit", 3, 3, true},
+		{"at the limit", 3, 4, true},
+		{"one over the limit", 3, 5, false},
+		{"no queue", 0, 1, true},
+		{"no queue, two tracks", 0, 2, false},
+		{"unset, at the limit", queueLimit(), defaultMaxQueue + 1, true},
+		{"unset, one over the limit", queueLimit(), defaultMaxQueue + 2, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testLibrary(t)
+			setMaxQueue(t, tc.limit)
+			list := testPlaylist(t, "mix.m3u", tc.entries)
+
+			tracks, _, problem := selectionTracks("1", []string{list})
+			if tc.ok {
+				if problem != "" || len(tracks) != tc.entries {
+					t.Fatalf("got %d tracks and %q, want all %d", len(tracks), problem, tc.entries)
+				}
+				return
+			}
+			if tracks != nil || !strings.HasPrefix(problem, "Queue is full") {
+				t.Fatalf("got %d tracks and %q, want the queue to be full", len(tracks), problem)
+			}
+			if want := fmt.Sprintf("would queue %d tracks", tc.entries-1); !strings.Contains(problem, want) {
+				t.Errorf("problem %q doesn't say it %s", problem, want)
+			}
+		})
+	}
+}
+
+func TestScanLibraryMissingRoot(t *testing.T) {
+	root := testLibrary(t, "a.mp3", "sub/b.mp3")
+	files, _, err := scanLibrary(root, allowedExts)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("before removal: got %v, %v", files, err)
+	}
+
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err = scanLibrary(root, allowedExts)
+	if !errors.Is(err, errSoundsDirMissing) || files != nil {
+		t.Fatalf("removed root: got %v, %v; want errSoundsDirMissing", files, err)
+	}
+	if msg := scanErrorMessage(err); !strings.Contains(msg, "missing or not mounted") {
+		t.Errorf("removed root: message %q doesn't say the folder is missing", msg)
+	}
+
+	// Nothing is cached: the library is back as soon as the folder is.
+	writeTestFile(t, "a.mp3", "")
+	if files, _, err = scanLibrary(root, allowedExts); err != nil || len(files) != 1 {
+		t.Fatalf("restored root: got %v, %v", files, err)
+	}
+
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(root, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = scanLibrary(root, allowedExts); err == nil || errors.Is(err, errSoundsDirMissing) {
+		t.Fatalf("root is a file: got %v, want a not-a-directory error", err)
+	}
+}
+
+// A menu built before the library disappeared still resolves its picks, but
+// playing them reports the problem instead of queueing nothing.
+func TestSelectionAfterRootRemoved(t *testing.T) {
+	root := testLibrary(t)
+	list := testPlaylist(t, "mix.m3u", 3)
+	files, _, err := scanLibrary(root, allowedExts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	picked, err := selectedFiles(files, []string{strconv.Itoa(slices.Index(files, list))})
+	if err != nil || len(picked) != 1 || picked[0] != list {
+		t.Fatalf("selectedFiles: got %v, %v", picked, err)
+	}
+
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPlaylist(filepath.Join(root, list), allowedExts); err == nil {
+		t.Error("loadPlaylist on a removed root: got no error")
+	}
+	tracks, _, problem := selectionTracks("1", picked)
+	if tracks != nil || !strings.HasPrefix(problem, "Could not load playlist "+list) {
+		t.Errorf("selectionTracks on a removed root: got %v, %q", tracks, problem)
+	}
+}
+
+// Entries whose files are gone are skipped rather than failing the playlist.
+func TestLoadPlaylistSkipsMissingEntries(t *testing.T) {
+	root := testLibrary(t)
+	list := testPlaylist(t, "mix.m3u", 3)
+	if err := os.Remove(filepath.Join(root, "mix-001.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := loadPlaylist(filepath.Join(root, list), allowedExts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(root, "mix-000.mp3"), filepath.Join(root, "mix-002.mp3")}
+	if !slices.Equal(entries, want) {
+		t.Errorf("got %v, want %v", entries, want)
+	}
+}
+
+func setShuffleOnAdd(t *testing.T, guildID string) {
+	t.Helper()
+	shuffleOnAddGuilds.Lock()
+	shuffleOnAddGuilds.enabled[guildID] = true
+	shuffleOnAddGuilds.Unlock()
+	t.Cleanup(func() {
+		shuffleOnAddGuilds.Lock()
+		delete(shuffleOnAddGuilds.enabled, guildID)
+		shuffleOnAddGuilds.Unlock()
+	})
+}
+
+// Several picks play in the order the menu lists them, whatever order
+// Discord returns the values in, with playlists expanded where they sit.
+func TestSelectionOrderOfPicks(t *testing.T) {
+	root := testLibrary(t, "a.mp3", "c.mp3")
+	list := testPlaylist(t, "b.m3u", 2)
+	setShuffleOnAdd(t, "1") // only a lone playlist is shuffled
+	files, _, err := scanLibrary(root, allowedExts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := func(name string) string { return strconv.Itoa(slices.Index(files, name)) }
+
+	picked, err := selectedFiles(files, []string{idx("c.mp3"), idx(list), idx("a.mp3"), idx("c.mp3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.mp3", list, "c.mp3"}; !slices.Equal(picked, want) {
+		t.Fatalf("selectedFiles: got %v, want %v", picked, want)
+	}
+
+	tracks, what, problem := selectionTracks("1", picked)
+	if problem != "" {
+		t.Fatal(problem)
+	}
+	want := []string{"a.mp3", "b-000.mp3", "b-001.mp3", "c.mp3"}
+	for n := range want {
+		want[n] = filepath.Join(root, want[n])
+	}
+	if !slices.Equal(tracks, want) {
+		t.Errorf("tracks: got %v, want %v", tracks, want)
+	}
+	if strings.Contains(what, "shuffled") {
+		t.Errorf("several picks were reported shuffled: %q", what)
+	}
+}
+
+func TestSelectionOrderOfLonePlaylist(t *testing.T) {
+	root := testLibrary(t)
+	list := testPlaylist(t, "mix.m3u", 20)
+	inOrder, err := loadPlaylist(filepath.Join(root, list), allowedExts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks, what, _ := selectionTracks("1", []string{list})
+	if !slices.Equal(tracks, inOrder) || strings.Contains(what, "shuffled") {
+		t.Errorf("shuffle-on-add off: got %v (%q), want playlist order", tracks, what)
+	}
+
+	setShuffleOnAdd(t, "1")
+	tracks, what, _ = selectionTracks("1", []string{list})
+	if !strings.HasSuffix(what, ", shuffled") {
+		t.Errorf("shuffle-on-add on: %q doesn't say shuffled", what)
+	}
+	// 20 tracks coming out in order by chance is a 1 in 20! event.
+	if slices.Equal(tracks, inOrder) {
+		t.Error("shuffle-on-add on: the playlist kept its order")
+	}
+	sorted := slices.Clone(tracks)
+	slices.Sort(sorted)
+	if !slices.Equal(sorted, inOrder) {
+		t.Errorf("shuffle-on-add on: got %v, want a permutation of %v", tracks, inOrder)
+	}
+}
+
+// "Play this attachment" takes the first playable attachment under the size
+// limit.
+func TestPickAttachment(t *testing.T) {
+	var (
+		notes = &discordgo.MessageAttachment{Filename: "notes.txt", Size: 10}
+		huge  = &discordgo.MessageAttachment{Filename: "huge.mp3", Size: attachmentMaxSize + 1}
+		list  = &discordgo.MessageAttachment{Filename: "mix.m3u", Size: 10}
+		song  = &discordgo.MessageAttachment{Filename: "Song.MP3", Size: 1 << 20}
+		clip  = &discordgo.MessageAttachment{Filename: "clip.ogg", Size: 1 << 10}
+	)
+	for _, tc := range []struct {
+		name   string
+		msg    *discordgo.Message
+		exts   extSet
+		want   *discordgo.MessageAttachment
+		reason string
+	}{
+		{"no message", nil, allowedExts, nil, "no attachments"},
+		{"no attachments", &discordgo.Message{}, allowedExts, nil, "no attachments"},
+		{"first playable", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{notes, huge, list, song, clip}}, allowedExts, song, ""},
+		{"too big", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{notes, huge}}, allowedExts, nil, "limit"},
+		{"nothing playable", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{notes, list}}, allowedExts, nil, "no audio attachment"},
+		{"server file types", &discordgo.Message{Attachments: []*discordgo.MessageAttachment{song, clip}}, extSet{".ogg": {}}, clip, ""},
+	} {
+		got, reason := pickAttachment(tc.msg, tc.exts)
+		if got != tc.want || !strings.Contains(reason, tc.reason) {
+			t.Errorf("%s: got %v, %q; want %v, %q", tc.name, got, reason, tc.want, tc.reason)
+		}
+	}
+}
+
+func TestTrackLabel(t *testing.T) {
+	root := testLibrary(t)
+	for _, tc := range []struct{ path, want string }{
+		{filepath.Join(root, "a.mp3"), "a"},
+		{filepath.Join(root, "sub", "b.ogg"), "sub/b"},
+		{"https://cdn.discordapp.com/attachments/1/2/song.mp3?ex=1&hm=abc", "song"},
+		{"https://example.com/live/stream.opus", "stream"},
+	} {
+		if got := trackLabel(tc.path); got != tc.want {
+			t.Errorf("trackLabel(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+// queueOnSession is what attachments and enqueueAll share: a stopping
+// session, like no session, takes nothing, so the caller starts a new one.
+func TestQueueOnSession(t *testing.T) {
+	setMaxQueue(t, 2)
+	if _, live := queueOnSession("1", []string{"a"}); live {
+		t.Fatal("no session: got live")
+	}
+	gp := &guildPlayback{guildID: "1"}
+	playSessions.Store("1", gp)
+	t.Cleanup(func() { playSessions.Delete("1") })
+
+	for _, tc := range []struct {
+		tracks []string
+		queued int
+	}{{[]string{"a"}, 1}, {[]string{"b", "c"}, 1}, {[]string{"d"}, 0}} {
+		if queued, live := queueOnSession("1", tc.tracks); !live || queued != tc.queued {
+			t.Errorf("queueing %v: got %d, %v; want %d queued", tc.tracks, queued, live, tc.queued)
+		}
+	}
+	if want := []string{"a", "b"}; !slices.Equal(gp.queue, want) {
+		t.Errorf("queue %v, want %v", gp.queue, want)
+	}
+
+	gp.queue = nil
+	gp.stopped = true
+	if queued, live := queueOnSession("1", []string{"a"}); live || queued != 0 || len(gp.queue) != 0 {
+		t.Errorf("stopped session: got %d, %v, queue %v; want it left alone", queued, live, gp.queue)
+	}
+}
+
+// enqueueAll appends behind what's already waiting, in order, up to the limit.
+func TestEnqueueAllOrder(t *testing.T) {
+	setMaxQueue(t, 4)
+	gp := &guildPlayback{guildID: "1", queue: []string{"waiting"}}
+	playSessions.Store("1", gp)
+	t.Cleanup(func() { playSessions.Delete("1") })
+	i := testInteraction(discordgo.InteractionApplicationCommand, "1", discordgo.ApplicationCommandInteractionData{Name: "playall"})
+
+	msg := enqueueAll(nil, i, "", []string{"x", "y"})
+	if want := []string{"waiting", "x", "y"}; !slices.Equal(gp.queue, want) || msg != "Queued 2 sounds." {
+		t.Fatalf("got %v (%q), want %v", gp.queue, msg, want)
+	}
+	msg = enqueueAll(nil, i, "", []string{"p", "q", "r"})
+	if want := []string{"waiting", "x", "y", "p"}; !slices.Equal(gp.queue, want) {
+		t.Fatalf("got %v, want %v", gp.queue, want)
+	}
+	if !strings.HasPrefix(msg, "Queued 1 of 3") {
+		t.Errorf("truncated batch: message %q", msg)
+	}
+	if msg = enqueueAll(nil, i, "", []string{"z"}); !strings.HasPrefix(msg, "Queue is full") || len(gp.queue) != 4 {
+		t.Errorf("full queue: got %v (%q)", gp.queue, msg)
+	}
+
+	// A stopping session isn't queued onto, full or not; a new one starts,
+	// which here means joining the invoker's voice channel, and there's none.
+	gp.stopped = true
+	if msg = enqueueAll(nil, i, "", []string{"z"}); !strings.HasPrefix(msg, "Join a voice channel first") || len(gp.queue) != 4 {
+		t.Errorf("stopped session: got %v (%q), want a new session", gp.queue, msg)
+	}
+}
+
+// Only shard 0 measures loudness; the others load whatever it has saved.
+func TestGainsMeasuredOnShardZero(t *testing.T) {
+	old, oldDir := shardID, dataDir
+	shardID, dataDir = 1, t.TempDir()
+	t.Cleanup(func() { shardID, dataDir = old, oldDir })
+
+	queueGain("/music/a.mp3")
+	gains.Lock()
+	pending := gains.pending["/music/a.mp3"]
+	gains.Unlock()
+	if pending {
+		t.Fatal("shard 1 queued a file for measuring")
+	}
+
+	saved := map[string]gainEntry{"/music/a.mp3": {Size: 1, LUFS: -20}}
+	if err := saveJSON(gainsFile, saved); err != nil {
+		t.Fatal(err)
+	}
+	loadGains()
+	gains.Lock()
+	got := gains.entries["/music/a.mp3"]
+	gains.entries = make(map[string]gainEntry)
+	gains.Unlock()
+	if got.LUFS != -20 {
+		t.Fatalf("loaded LUFS = %v, want -20", got.LUFS)
+	}
+}
+
+// The track status carries no timestamps, which Discord drops from bots anyway.
+func TestTrackActivity(t *testing.T) {
+	act := trackActivity(presenceTrack{path: "/music/song.mp3"})
+	if act.Type != discordgo.ActivityTypeListening || act.State != "" {
+		t.Errorf("playing: got type %v state %q", act.Type, act.State)
+	}
+	if act.Timestamps != (discordgo.TimeStamps{}) {
+		t.Errorf("playing: got timestamps %+v, want none", act.Timestamps)
+	}
+	if act := trackActivity(presenceTrack{path: "/music/song.mp3", paused: true}); act.State != "Paused" {
+		t.Errorf("paused: got state %q, want Paused", act.State)
+	}
+}
+
+func TestDJScopes(t *testing.T) {
+	commands := map[string]string{
+		stopCmdName:           djScopeControls,
+		"pause":               djScopeControls,
+		"queue":               djScopeControls,
+		"eq":                  djScopeControls,
+		"playall":             djScopePlay,
+		"category":            djScopePlay,
+		playAttachmentCmdName: djScopePlay,
+		soundsCmdName:         "",
+		"find":                "",
+		"djrole":              "",
+	}
+	for name, want := range commands {
+		if got := djCommandScope(name); got != want {
+			t.Errorf("djCommandScope(%q) = %q, want %q", name, got, want)
+		}
+	}
+	components := map[string]string{
+		"stop_confirm":       djScopeControls,
+		"sounds_cancel_stop": djScopeControls,
+		"sound_select":       djScopePlay,
+		"voice_select":       djScopePlay,
+		"voice_retry":        djScopePlay,
+		"sounds_next":        "",
+		"sounds_search":      "",
+	}
+	for id, want := range components {
+		if got := djComponentScope(id); got != want {
+			t.Errorf("djComponentScope(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestDJSettingGates(t *testing.T) {
+	tests := []struct {
+		setting, scope string
+		want           bool
+	}{
+		{djScopeControls, djScopeControls, true},
+		{djScopeControls, djScopePlay, false},
+		{djScopePlay, djScopePlay, true},
+		{djScopePlay, djScopeControls, false},
+		{djScopeAll, djScopeControls, true},
+		{djScopeAll, djScopePlay, true},
+		{djScopeAll, "", false},
+	}
+	for _, tc := range tests {
+		if got := (djSetting{Role: "9", Scope: tc.setting}).gates(tc.scope); got != tc.want {
+			t.Errorf("scope %s gates %q = %v, want %v", tc.setting, tc.scope, got, tc.want)
+		}
+	}
+}
+
+func TestDJAllowed(t *testing.T) {
+	oldOwner, oldEnv := ownerID, djRoleEnv
+	ownerID, djRoleEnv = "1", map[string]string{"env": "8"}
+	t.Cleanup(func() { ownerID, djRoleEnv = oldOwner, oldEnv })
+	djRoles.Lock()
+	djRoles.byGuild["set"] = djSetting{Role: "9", Scope: djScopeControls}
+	djRoles.Unlock()
+	t.Cleanup(func() {
+		djRoles.Lock()
+		delete(djRoles.byGuild, "set")
+		djRoles.Unlock()
+	})
+
+	tests := []struct {
+		name    string
+		guildID string
+		userID  string
+		roles   []string
+		admin   bool
+		scope   string
+		want    bool
+	}{
+		{"no DJ role", "other", "5", nil, false, djScopeControls, true},
+		{"ungated scope", "set", "5", nil, false, djScopePlay, true},
+		{"member without the role", "set", "5", []string{"7"}, false, djScopeControls, false},
+		{"member with the role", "set", "5", []string{"7", "9"}, false, djScopeControls, true},
+		{"admin", "set", "5", nil, true, djScopeControls, true},
+		{"owner", "set", "1", nil, false, djScopeControls, true},
+		{"DJ_ROLE fallback denies", "env", "5", nil, false, djScope, false},
+		{"DJ_ROLE fallback allows", "env", "5", []string{"8"}, false, djScope, true},
+	}
+	for _, tc := range tests {
+		if _, got := djAllowed(tc.guildID, tc.userID, tc.roles, tc.admin, tc.scope); got != tc.want {
+			t.Errorf("%s: allowed = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDiffListings(t *testing.T) {
+	tests := []struct {
+		name           string
+		old, cur       []string
+		added, removed []string
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"a", "b"}, nil, nil},
+		{"from empty", nil, []string{"a", "b"}, []string{"a", "b"}, nil},
+		{"to empty", []string{"a", "b"}, nil, nil, []string{"a", "b"}},
+		{"interleaved", []string{"a", "c", "e"}, []string{"b", "c", "d"}, []string{"b", "d"}, []string{"a", "e"}},
+		{"renamed", []string{"x/old.mp3"}, []string{"x/new.mp3"}, []string{"x/new.mp3"}, []string{"x/old.mp3"}},
+	}
+	for _, tc := range tests {
+		added, removed := diffListings(tc.old, tc.cur)
+		if !slices.Equal(added, tc.added) || !slices.Equal(removed, tc.removed) {
+			t.Errorf("%s: got +%v -%v, want +%v -%v", tc.name, added, removed, tc.added, tc.removed)
+		}
+	}
+}
+
+// Changes accumulate across scans until a summary, and a file that came and
+// went in between isn't reported at all.
+func TestNetChanges(t *testing.T) {
+	pending := map[string]int{}
+	netChanges(pending, []string{"a", "b"}, []string{"old"})
+	netChanges(pending, []string{"c"}, []string{"b"})
+	netChanges(pending, []string{"old"}, nil)
+	want := map[string]int{"a": 1, "c": 1}
+	if len(pending) != len(want) {
+		t.Fatalf("pending = %v, want %v", pending, want)
+	}
+	for rel, n := range want {
+		if pending[rel] != n {
+			t.Errorf("pending = %v, want %v", pending, want)
+			break
+		}
+	}
+}
+
+// Leaving a guild drops its schedules, and only its.
+func TestDropGuildSchedules(t *testing.T) {
+	oldDir := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = oldDir })
+	schedules.Lock()
+	schedules.items[901] = &scheduledSound{ID: 901, GuildID: "gone"}
+	schedules.items[902] = &scheduledSound{ID: 902, GuildID: "gone"}
+	schedules.items[903] = &scheduledSound{ID: 903, GuildID: "kept"}
+	schedules.timers[901] = time.AfterFunc(time.Hour, func() {})
+	schedules.Unlock()
+	t.Cleanup(func() {
+		schedules.Lock()
+		delete(schedules.items, 903)
+		schedules.Unlock()
+	})
+
+	if n := dropGuildSchedules("gone"); n != 2 {
+		t.Errorf("dropped %d schedule(s), want 2", n)
+	}
+	schedules.Lock()
+	_, kept := schedules.items[903]
+	left, timers := len(schedules.items), len(schedules.timers)
+	schedules.Unlock()
+	if !kept || left != 1 || timers != 0 {
+		t.Errorf("after the drop: %d schedule(s) (other guild kept: %v), %d timer(s); want 1, true, 0", left, kept, timers)
+	}
+}
+
+func TestCommandNameClash(t *testing.T) {
+	oldSounds, oldStop := soundsCmdName, stopCmdName
+	t.Cleanup(func() { soundsCmdName, stopCmdName = oldSounds, oldStop })
+	tests := []struct {
+		sounds, stop, want string
+	}{
+		{"sounds", "stop", ""},
+		{"play", "leave", ""},
+		{"sounds", "pause", "pause"},
+		{"queue", "stop", "queue"},
+	}
+	for _, tc := range tests {
+		soundsCmdName, stopCmdName = tc.sounds, tc.stop
+		if got := commandNameClash(); got != tc.want {
+			t.Errorf("/%s and /%s: clash %q, want %q", tc.sounds, tc.stop, got, tc.want)
+		}
+	}
+}
+
+// Join sounds and schedules only take files the guild's /extensions allow.
+func TestLibrarySoundGuildExtensions(t *testing.T) {
+	testLibrary(t, "a.mp3", "b.wav", "list.m3u")
+	guildExts.Lock()
+	guildExts.byGuild["mp3only"] = []string{".mp3"}
+	guildExts.Unlock()
+	t.Cleanup(func() {
+		guildExts.Lock()
+		delete(guildExts.byGuild, "mp3only")
+		guildExts.Unlock()
+	})
+
+	tests := []struct {
+		guildID, rel string
+		ok           bool
+	}{
+		{"default", "a.mp3", true},
+		{"default", "b.wav", true},
+		{"mp3only", "a.mp3", true},
+		{"mp3only", "b.wav", false},
+		{"default", "list.m3u", false},
+		{"default", "missing.mp3", false},
+		{"default", "../a.mp3", false},
+	}
+	for _, tc := range tests {
+		_, err := librarySound(tc.guildID, tc.rel)
+		if (err == nil) != tc.ok {
+			t.Errorf("guild %s, %s: err = %v, want ok %v", tc.guildID, tc.rel, err, tc.ok)
+		}
+	}
+}
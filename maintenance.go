@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// staleTempAge is how old a temp file must be before a sweep removes it. Live
+// ones (a /preview being transcoded) are seconds old.
+const staleTempAge = time.Hour
+
+// tempDir is where the bot's temp files go, kept apart from everything else in
+// the system temp directory so sweeps only ever touch our own files.
+func tempDir() string {
+	return filepath.Join(os.TempDir(), "tunetalk")
+}
+
+// createTemp is os.CreateTemp in tempDir.
+func createTemp(pattern string) (*os.File, error) {
+	if err := os.MkdirAll(tempDir(), 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(tempDir(), pattern)
+}
+
+// sweepTemp deletes temp files older than maxAge, which a crash or kill left
+// behind, and reports how many went and the space they took.
+func sweepTemp(maxAge time.Duration) (removed int, freed int64, err error) {
+	entries, err := os.ReadDir(tempDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(tempDir(), e.Name())); err != nil {
+			log.Printf("[maintenance] couldn't remove %s: %v", e.Name(), err)
+			continue
+		}
+		removed++
+		freed += info.Size()
+	}
+	return removed, freed, nil
+}
+
+// sweepTempOnStart clears what the previous run may have leaked.
+func sweepTempOnStart() {
+	removed, freed, err := sweepTemp(staleTempAge)
+	if err != nil {
+		log.Printf("[maintenance] temp sweep failed: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("[maintenance] removed %d stale temp file(s), %s", removed, formatBytes(freed))
+	}
+}
+
+// dirUsage is the total size and count of the regular files under dir.
+func dirUsage(dir string) (size int64, files int) {
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// handleMaintenanceCommand sweeps the bot's temp files. It shows host paths and
+// the sweep covers the whole host, not one server, so it's owner only.
+func handleMaintenanceCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isOwner(i) {
+		log.Printf("[AUDIT] /maintenance denied for user=%s guild=%s", interactionUserID(i), i.GuildID)
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "cleanup" {
+		return
+	}
+	removed, freed, err := sweepTemp(staleTempAge)
+	if err != nil {
+		log.Printf("[AUDIT] /maintenance cleanup by user=%s failed: %v", interactionUserID(i), err)
+		logRespondErr(i, respondPrivate(s, i, "Cleanup failed: "+err.Error()))
+		return
+	}
+	log.Printf("[AUDIT] /maintenance cleanup by user=%s guild=%s: removed %d file(s), %s", interactionUserID(i), i.GuildID, removed, formatBytes(freed))
+
+	tmpSize, tmpFiles := dirUsage(tempDir())
+	dataSize, dataFiles := dirUsage(dataDir)
+	msg := fmt.Sprintf("Removed %d temp file(s) older than %s, freeing %s.\n"+
+		"Temp files now: %d (%s) in `%s`\n"+
+		"Saved state: %d file(s) (%s) in `%s`",
+		removed, staleTempAge, formatBytes(freed),
+		tmpFiles, formatBytes(tmpSize), tempDir(),
+		dataFiles, formatBytes(dataSize), dataDir)
+	logRespondErr(i, respondPrivate(s, i, msg))
+}
+
+func maintenanceCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:                     "maintenance",
+		Description:              "Bot housekeeping",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cleanup",
+				Description: "Delete leftover temp files and report disk usage",
+			},
+		},
+	}
+}
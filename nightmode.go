@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const nightModeFile = "nightmode.json"
+
+const (
+	// nightVolume scales every track while night mode is on.
+	nightVolume = 0.5
+	// nightCompressor evens out loud moments; makeup gain brings the quiet
+	// parts back up before nightVolume lowers everything.
+	nightCompressor = "acompressor=threshold=-24dB:ratio=4:attack=5:release=250:makeup=2"
+)
+
+// nightSetting is one guild's night mode.
+type nightSetting struct {
+	Compress bool `json:"compress"`
+}
+
+// guildNight holds the guilds with night mode on, persisted in DATA_DIR.
+var guildNight = struct {
+	sync.Mutex
+	byGuild map[string]nightSetting
+}{byGuild: make(map[string]nightSetting)}
+
+func loadNightMode() {
+	guildNight.Lock()
+	defer guildNight.Unlock()
+	if err := loadJSON(shardFileName(nightModeFile), &guildNight.byGuild); err != nil {
+		log.Printf("[nightmode] couldn't load night mode settings: %v", err)
+	}
+	if guildNight.byGuild == nil {
+		guildNight.byGuild = make(map[string]nightSetting)
+	}
+}
+
+// nightFilter is the guild's night mode filter chain, or "" when it's off.
+func nightFilter(guildID string) string {
+	guildNight.Lock()
+	defer guildNight.Unlock()
+	ns, ok := guildNight.byGuild[guildID]
+	if !ok {
+		return ""
+	}
+	vol := fmt.Sprintf("volume=%.2f", nightVolume)
+	if ns.Compress {
+		return nightCompressor + "," + vol
+	}
+	return vol
+}
+
+// nightModeLabel describes the guild's night mode for /settings.
+func nightModeLabel(guildID string) string {
+	guildNight.Lock()
+	defer guildNight.Unlock()
+	ns, ok := guildNight.byGuild[guildID]
+	switch {
+	case !ok:
+		return "off"
+	case ns.Compress:
+		return fmt.Sprintf("on (%d%%, compressor)", int(nightVolume*100))
+	default:
+		return fmt.Sprintf("on (%d%%)", int(nightVolume*100))
+	}
+}
+
+func handleNightModeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var state string
+	compress := true
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "state":
+			state = opt.StringValue()
+		case "compressor":
+			compress = opt.BoolValue()
+		}
+	}
+
+	guildNight.Lock()
+	if state == "on" {
+		guildNight.byGuild[i.GuildID] = nightSetting{Compress: compress}
+	} else {
+		delete(guildNight.byGuild, i.GuildID)
+	}
+	if err := saveJSON(shardFileName(nightModeFile), guildNight.byGuild); err != nil {
+		log.Printf("[nightmode] couldn't save night mode settings: %v", err)
+	}
+	guildNight.Unlock()
+	log.Printf("[nightmode] guild=%s state=%s compressor=%v", i.GuildID, state, compress)
+
+	msg := "Night mode is **off**."
+	if state == "on" {
+		msg = fmt.Sprintf("Night mode is **on**: volume at %d%%", int(nightVolume*100))
+		if compress {
+			msg += ", with loud moments compressed."
+		} else {
+			msg += "."
+		}
+	}
+	if _, ok := playSessions.Load(i.GuildID); ok {
+		msg += " It applies from the next track."
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+func nightModeCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        "nightmode",
+		Description: "Quieter playback for late-night listening",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "state",
+				Description: "On or off",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "on", Value: "on"},
+					{Name: "off", Value: "off"},
+				},
+			},
+			{Type: discordgo.ApplicationCommandOptionBoolean, Name: "compressor", Description: "Also even out loud moments (default: on)"},
+		},
+	}
+}
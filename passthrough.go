@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonas747/ogg"
+	"github.com/matthew-balzan/dca"
+)
+
+// errNotPassthrough means the source can't be sent as-is and must be transcoded.
+var errNotPassthrough = errors.New("source is not passthrough-compatible opus")
+
+// ffmpegExitWait is how long Error gives ffmpeg to exit once its output has
+// ended before killing it.
+var ffmpegExitWait = 2 * time.Second
+
+// probeOpus reports whether the first audio stream of filePath is Opus at
+// 48kHz with at most two channels, i.e. something Discord can play without a
+// transcode. Any probe failure counts as "no".
+func probeOpus(filePath string) bool {
+	if acquireFFmpeg(ffmpegSlotWait) != nil {
+		return false
+	}
+	defer releaseFFmpeg()
+	out, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,channels",
+		"-of", "default=noprint_wrappers=1",
+		filePath,
+	).Output()
+	if err != nil {
+		log.Printf("[passthrough] ffprobe %s: %v", filePath, err)
+		return false
+	}
+
+	info := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), "="); ok {
+			info[k] = strings.TrimSpace(v)
+		}
+	}
+	switch info["channels"] {
+	case "1", "2":
+	default:
+		return false
+	}
+	return info["codec_name"] == "opus" && info["sample_rate"] == "48000"
+}
+
+// passthroughSource streams the Opus packets of a file as-is. ffmpeg only
+// remuxes (-c:a copy) into Ogg so any container works, and we demux the pages.
+type passthroughSource struct {
+	cmd      *exec.Cmd
+	dec      *ogg.PacketDecoder
+	stderr   bytes.Buffer
+	first    []byte
+	duration time.Duration
+
+	cleanup  sync.Once
+	killed   atomic.Bool // Cleanup killed ffmpeg
+	waitOnce sync.Once
+	waitErr  error
+	exited   chan struct{} // closed once wait has returned
+}
+
+// startPassthrough starts the remux and reads the first audio packet to make
+// sure ffmpeg is running and the packets use a frame size we can send.
+func startPassthrough(filePath string) (*passthroughSource, error) {
+	return startOggOpus(filePath,
+		"-i", filePath,
+		"-map", "0:a:0", "-c:a", "copy",
+	)
+}
+
+// startOggOpus runs ffmpeg with args, which must produce Opus, and reads the
+// packets back from an Ogg pipe. label names the source in errors. Only
+// frameDuration packets are accepted: discordgo sends one every 20 ms and
+// stamps them as 20 ms of audio, so longer ones would play back broken.
+func startOggOpus(label string, args ...string) (*passthroughSource, error) {
+	args = append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+	cmd := exec.Command("ffmpeg", append(args, "-f", "ogg", "pipe:1")...)
+	p := &passthroughSource{cmd: cmd, exited: make(chan struct{})}
+	cmd.Stderr = &p.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	p.dec = ogg.NewPacketDecoder(ogg.NewDecoder(stdout))
+
+	first, err := p.OpusFrame()
+	if err != nil {
+		p.Cleanup()
+		return nil, fmt.Errorf("remux %q: %v; stderr:\n%s", label, err, strings.TrimSpace(p.stderr.String()))
+	}
+	p.duration = opusPacketDuration(first)
+	if p.duration != frameDuration*time.Millisecond {
+		p.Cleanup()
+		return nil, fmt.Errorf("%w: %s packets", errNotPassthrough, p.duration)
+	}
+	p.first = first
+	return p, nil
+}
+
+func (p *passthroughSource) OpusFrame() ([]byte, error) {
+	if p.first != nil {
+		frame := p.first
+		p.first = nil
+		return frame, nil
+	}
+	for {
+		packet, _, err := p.dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+		// Skip the Ogg Opus identification and comment headers.
+		if len(packet) == 0 || bytes.HasPrefix(packet, []byte("OpusHead")) || bytes.HasPrefix(packet, []byte("OpusTags")) {
+			continue
+		}
+		return packet, nil
+	}
+}
+
+func (p *passthroughSource) FrameDuration() time.Duration {
+	return p.duration
+}
+
+// Cleanup kills ffmpeg; a blocked OpusFrame returns once the pipe closes.
+func (p *passthroughSource) Cleanup() {
+	p.cleanup.Do(func() {
+		if p.cmd.Process != nil {
+			p.killed.Store(true)
+			_ = p.cmd.Process.Kill()
+		}
+		p.wait()
+	})
+}
+
+// wait reaps ffmpeg and records how it exited, once, for Cleanup and Error.
+func (p *passthroughSource) wait() {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		close(p.exited)
+	})
+}
+
+// Error waits for ffmpeg once the pipe has closed and returns how it exited,
+// matching dca.EncodeSession so encoderCrash can tell a crash from EOF. An
+// ffmpeg that doesn't exit within ffmpegExitWait is killed, and dying from
+// Cleanup's kill isn't a crash.
+func (p *passthroughSource) Error() error {
+	go p.wait()
+	select {
+	case <-p.exited:
+	case <-time.After(ffmpegExitWait):
+		log.Printf("[passthrough] ffmpeg still running %s after its output ended; killing it", ffmpegExitWait)
+		p.Cleanup()
+	}
+	var exitErr *exec.ExitError
+	if p.killed.Load() && errors.As(p.waitErr, &exitErr) && exitErr.ExitCode() == -1 {
+		return nil
+	}
+	return p.waitErr
+}
+
+// FFMPEGMessages returns what ffmpeg wrote to stderr.
+func (p *passthroughSource) FFMPEGMessages() string {
+	return p.stderr.String()
+}
+
+// opusPacketDuration decodes the TOC byte of an Opus packet (RFC 6716 §3.1)
+// and returns how much audio it carries, or 0 if the packet is malformed.
+func opusPacketDuration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return 0
+	}
+	toc := packet[0]
+	config := toc >> 3
+
+	var frame time.Duration
+	switch {
+	case config < 12: // SILK
+		frame = [...]time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // hybrid
+		frame = [...]time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frame = [...]time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	frames := 1
+	switch toc & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = int(packet[1] & 0x3f)
+	}
+	return frame * time.Duration(frames)
+}
+
+// passthroughOK is the stream-level check: nothing that needs filtering, and a
+// source that probes as plain Opus.
+func passthroughOK(filePath string, opts *dca.EncodeOptions) bool {
+	return opts.Volume == 1 && opts.AudioFilter == "" && probeOpus(filePath)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonas747/ogg"
+)
+
+// oggOpusStream is an Ogg Opus stream of n 20 ms packets, as ffmpeg's remux
+// hands them to a passthroughSource.
+func oggOpusStream(tb testing.TB, n int) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	enc := ogg.NewEncoder(1, &buf)
+	if err := enc.EncodeBOS(0, []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")); err != nil {
+		tb.Fatal(err)
+	}
+	if err := enc.Encode(0, []byte("OpusTags")); err != nil {
+		tb.Fatal(err)
+	}
+	packet := make([]byte, 320)
+	packet[0] = 31 << 3 // CELT fullband, one 20 ms frame
+	for k := range n {
+		if err := enc.Encode(int64(k+1)*960, packet); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := enc.EncodeEOS(); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkPassthroughFrames measures only the in-process half of
+// passthrough: demuxing Ogg pages into the Opus packets sent to Discord, for
+// a minute of audio. BenchmarkEncodeTrack compares the whole pipeline.
+func BenchmarkPassthroughFrames(b *testing.B) {
+	const frames = 3000 // 60s of 20 ms packets
+	stream := oggOpusStream(b, frames)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	for range b.N {
+		p := &passthroughSource{dec: ogg.NewPacketDecoder(ogg.NewDecoder(bytes.NewReader(stream)))}
+		n := 0
+		for {
+			frame, err := p.OpusFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			if opusPacketDuration(frame) != 20*time.Millisecond {
+				b.Fatalf("frame %d: got a %s packet", n, opusPacketDuration(frame))
+			}
+			n++
+		}
+		if n != frames {
+			b.Fatalf("got %d frames, want %d", n, frames)
+		}
+	}
+}
+
+// BenchmarkEncodeTrack reads every frame of the same minute-long Opus file
+// through encodeTrack, remuxed by passthrough and transcoded by dca. Frames
+// are read as fast as ffmpeg makes them, so the time per op is mostly
+// ffmpeg's work. It needs a real ffmpeg with libopus on PATH.
+func BenchmarkEncodeTrack(b *testing.B) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		b.Skip("ffmpeg not on PATH")
+	}
+	path := filepath.Join(b.TempDir(), "tone.opus")
+	gen := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "sine=frequency=440:duration=60",
+		"-ac", "2", "-ar", "48000", "-c:a", "libopus", "-b:a", "96k", path)
+	if out, err := gen.CombinedOutput(); err != nil {
+		b.Skipf("can't make an Opus file (ffmpeg without libopus?): %v\n%s", err, out)
+	}
+	oldIn, oldOut, oldTrim, oldPass := fadeInMS, fadeOutMS, trimSilence, opusPassthrough
+	fadeInMS, fadeOutMS, trimSilence = 0, 0, false
+	b.Cleanup(func() { fadeInMS, fadeOutMS, trimSilence, opusPassthrough = oldIn, oldOut, oldTrim, oldPass })
+
+	for _, mode := range []struct {
+		name        string
+		passthrough bool
+	}{{"passthrough", true}, {"transcode", false}} {
+		b.Run(mode.name, func(b *testing.B) {
+			opusPassthrough = mode.passthrough
+			for range b.N {
+				enc, err := encodeTrack(path, trackOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				inner := enc.(*slotSource).trackSource
+				if _, ok := inner.(*passthroughSource); ok != mode.passthrough {
+					b.Fatalf("got a %T source", inner)
+				}
+				n := 0
+				for ; ; n++ {
+					if _, err := enc.OpusFrame(); err != nil {
+						break
+					}
+				}
+				enc.Cleanup()
+				if n < 2900 {
+					b.Fatalf("got %d frames, want about 3000", n)
+				}
+			}
+		})
+	}
+}
+
+// fakeFFmpeg puts an ffmpeg on PATH that ignores its arguments and runs
+// script, a shell snippet in which $STREAM is an Ogg Opus stream of the given
+// number of packets, e.g. `cat "$STREAM"; exit 1`.
+func fakeFFmpeg(t *testing.T, packets int, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	stream := filepath.Join(dir, "stream.ogg")
+	if err := os.WriteFile(stream, oggOpusStream(t, packets), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script = "#!/bin/sh\nSTREAM='" + stream + "'\n" + script + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// readToEOF plays p out the way the streamer does.
+func readToEOF(t *testing.T, p *passthroughSource) {
+	t.Helper()
+	for n := 0; ; n++ {
+		_, err := p.OpusFrame()
+		if err == io.EOF {
+			if n != 5 {
+				t.Fatalf("got %d frames, want 5", n)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// EOF alone doesn't tell the end of the track from an ffmpeg crash; its exit
+// status does, whichever of Error and Cleanup runs first.
+func TestEncoderCrash(t *testing.T) {
+	old := ffmpegExitWait
+	ffmpegExitWait = 200 * time.Millisecond
+	t.Cleanup(func() { ffmpegExitWait = old })
+
+	for _, tc := range []struct {
+		name         string
+		tail         string
+		cleanupFirst bool
+		crash        bool
+	}{
+		{name: "clean exit", tail: "exit 0"},
+		{name: "crash", tail: "echo 'Error while decoding stream' >&2; exit 1", crash: true},
+		{name: "crash, cleaned up first", tail: "echo 'Error while decoding stream' >&2; exit 1", cleanupFirst: true, crash: true},
+		{name: "clean exit, cleaned up first", tail: "exit 0", cleanupFirst: true},
+		{name: "hangs after its output", tail: "exec 1>&-; exec sleep 30"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeFFmpeg(t, 5, `cat "$STREAM"; `+tc.tail)
+			p, err := startOggOpus("track.opus")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Cleanup()
+			readToEOF(t, p)
+			if tc.cleanupFirst {
+				time.Sleep(50 * time.Millisecond) // let it exit on its own first
+				p.Cleanup()
+			}
+
+			start := time.Now()
+			stderr, crash := encoderCrash(p)
+			if took := time.Since(start); took > 2*time.Second {
+				t.Errorf("encoderCrash took %s", took)
+			}
+			if (crash != nil) != tc.crash {
+				t.Fatalf("got crash %v, want a crash: %v", crash, tc.crash)
+			}
+			if tc.crash && !strings.Contains(stderr, "Error while decoding stream") {
+				t.Errorf("crash stderr %q doesn't carry ffmpeg's message", stderr)
+			}
+		})
+	}
+}
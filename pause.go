@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// An Opus frame of silence. Feeding these while paused keeps the voice
+// connection active, so Discord doesn't treat us as idle and resume is seamless.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+func handlePauseCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	val, ok := playSessions.Load(i.GuildID)
+	if !ok {
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing."))
+		return
+	}
+	if !val.(*guildPlayback).pause() {
+		logRespondErr(i, respondPrivate(s, i, "Playback is already paused."))
+		return
+	}
+	logRespondErr(i, respondEphemeral(s, i, "Paused. Use /resume to continue.", nil))
+	updatePresence(s)
+}
+
+func handleResumeCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	val, ok := playSessions.Load(i.GuildID)
+	if !ok {
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing."))
+		return
+	}
+	if !val.(*guildPlayback).resume() {
+		logRespondErr(i, respondPrivate(s, i, "Playback isn't paused."))
+		return
+	}
+	logRespondErr(i, respondEphemeral(s, i, "Resumed.", nil))
+	updatePresence(s)
+}
+
+// pause stops pulling frames from the encoder and swaps in silence frames
+// (the custom streamer does this itself; the legacy stream needs a feeder).
+// It reports false if nothing is streaming or playback is already paused.
+func (gp *guildPlayback) pause() bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if gp.paused || gp.stopped || gp.vc == nil {
+		return false
+	}
+	if gp.streamer != nil {
+		gp.streamer.control(streamPause)
+		gp.paused = true
+		log.Printf("[pause] paused playback for guild=%s", gp.guildID)
+		return true
+	}
+	if gp.stream == nil {
+		return false
+	}
+	// Legacy dca stream: stop it and feed silence ourselves.
+	gp.stream.SetPaused(true)
+	gp.paused = true
+	gp.silenceStop = make(chan struct{})
+	go sendSilence(gp.vc, gp.silenceStop)
+	log.Printf("[pause] paused playback for guild=%s", gp.guildID)
+	return true
+}
+
+// resume stops the silence feeder and restarts the stream where it left off.
+func (gp *guildPlayback) resume() bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if !gp.paused {
+		return false
+	}
+	if gp.streamer != nil {
+		gp.streamer.control(streamResume)
+		gp.paused = false
+		log.Printf("[pause] resumed playback for guild=%s", gp.guildID)
+		return true
+	}
+	if gp.stream == nil {
+		return false
+	}
+	close(gp.silenceStop)
+	gp.silenceStop = nil
+	gp.paused = false
+	gp.stream.SetPaused(false)
+	log.Printf("[pause] resumed playback for guild=%s", gp.guildID)
+	return true
+}
+
+// skip ends the current track; the lifecycle moves on to the next queued one.
+// It reports false if nothing is streaming.
+func (gp *guildPlayback) skip() bool {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if gp.stopped {
+		return false
+	}
+	if gp.streamer != nil {
+		gp.streamer.control(streamSkip)
+		gp.paused = false
+		log.Printf("[pause] skipped track for guild=%s", gp.guildID)
+		return true
+	}
+	if gp.stream == nil || gp.enc == nil {
+		return false
+	}
+	// Legacy dca stream: end the encoder so the stream hits EOF.
+	if gp.paused {
+		close(gp.silenceStop)
+		gp.silenceStop = nil
+		gp.paused = false
+		gp.stream.SetPaused(false)
+	}
+	gp.enc.Cleanup()
+	log.Printf("[pause] skipped track for guild=%s", gp.guildID)
+	return true
+}
+
+// sendSilence writes silence frames until stop is closed. discordgo's sender
+// paces OpusSend, so this naturally runs at one frame per 20ms.
+func sendSilence(vc *discordgo.VoiceConnection, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case vc.OpusSend <- silenceFrame:
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handlePingCommand reports gateway heartbeat latency and, while the bot is
+// playing in this guild, how promptly the voice connection takes frames.
+func handlePingCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var b strings.Builder
+	if hb := s.HeartbeatLatency(); hb > 0 {
+		fmt.Fprintf(&b, "Gateway heartbeat: %s", hb.Round(time.Millisecond))
+	} else {
+		b.WriteString("Gateway heartbeat: not measured yet")
+	}
+
+	if val, ok := playSessions.Load(i.GuildID); ok {
+		gp := val.(*guildPlayback)
+		gp.mu.Lock()
+		channelID, st := gp.channelID, gp.streamer
+		gp.mu.Unlock()
+		fmt.Fprintf(&b, "\nVoice: connected to <#%s>", channelID)
+		// discordgo doesn't expose the voice UDP round trip. Frames queue up
+		// when the sender falls behind, so how long one waits to be taken
+		// beyond its own duration is what listeners hear as lag.
+		if st != nil {
+			wait := time.Duration(st.sendWait.Load())
+			fmt.Fprintf(&b, "; frames wait %s to send (one per %s is normal)",
+				wait.Round(time.Millisecond), st.frame)
+		}
+	} else {
+		b.WriteString("\nVoice: not connected in this server")
+	}
+	logRespondErr(i, respondEphemeral(s, i, b.String(), nil))
+}
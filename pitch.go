@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxPitchShift is the furthest /pitch goes either way, in semitones: an
+// octave, which is also as far as atempo can compensate in one step.
+const maxPitchShift = 12
+
+// pitchShift is a session's /pitch setting.
+type pitchShift struct {
+	semitones int  // 0 = unchanged
+	keepTempo bool // shift pitch only; false speeds up or slows down too, like a turntable
+}
+
+// rubberband reports whether ffmpeg has the rubberband filter, which shifts
+// pitch more cleanly than resampling. Checked once, on first use.
+var rubberband = sync.OnceValue(func() bool {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-filters").Output()
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[1] == "rubberband" {
+			return true
+		}
+	}
+	return false
+})
+
+// filter is the ffmpeg filter chain for the shift, or "" when there's none.
+// Without rubberband, the audio is resampled to 48kHz and relabelled at the
+// shifted rate, which changes pitch and tempo together; atempo then undoes
+// the tempo change when it should be kept.
+func (p pitchShift) filter() string {
+	if p.semitones == 0 {
+		return ""
+	}
+	ratio := math.Pow(2, float64(p.semitones)/12)
+	if p.keepTempo && rubberband() {
+		return fmt.Sprintf("rubberband=pitch=%.6f", ratio)
+	}
+	chain := fmt.Sprintf("aresample=48000,asetrate=%d,aresample=48000", int(math.Round(48000*ratio)))
+	if p.keepTempo {
+		chain += fmt.Sprintf(",atempo=%.6f", 1/ratio)
+	}
+	return chain
+}
+
+func (p pitchShift) String() string {
+	if p.semitones == 0 {
+		return "unchanged"
+	}
+	s := fmt.Sprintf("%+d semitone(s)", p.semitones)
+	if !p.keepTempo {
+		s += ", tempo follows"
+	}
+	return s
+}
+
+func handlePitchCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	val, ok := playSessions.Load(i.GuildID)
+	if !ok {
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing. Start something first; the pitch lasts until the bot leaves."))
+		return
+	}
+	gp := val.(*guildPlayback)
+
+	p := pitchShift{keepTempo: true}
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "semitones":
+			p.semitones = int(opt.IntValue())
+		case "keep_tempo":
+			p.keepTempo = opt.BoolValue()
+		}
+	}
+	if p.semitones < -maxPitchShift || p.semitones > maxPitchShift {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Semitones must be between -%d and %d.", maxPitchShift, maxPitchShift)))
+		return
+	}
+	if p.semitones == 0 {
+		p.keepTempo = true
+	}
+
+	gp.mu.Lock()
+	gp.pitch = p
+	gp.mu.Unlock()
+	log.Printf("[pitch] guild=%s user=%s: %s", i.GuildID, interactionUserID(i), p)
+
+	msg := "Pitch back to normal from the next track."
+	if p.semitones != 0 {
+		msg = fmt.Sprintf("Pitch set to %s from the next track, until the bot leaves.", p)
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+func pitchCommand() *discordgo.ApplicationCommand {
+	minShift, maxShift := float64(-maxPitchShift), float64(maxPitchShift)
+	return &discordgo.ApplicationCommand{
+		Name:        "pitch",
+		Description: "Transpose playback up or down",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "semitones", Description: "Semitones to shift, e.g. 2 or -3 (0 = normal)", Required: true, MinValue: &minShift, MaxValue: maxShift},
+			{Type: discordgo.ApplicationCommandOptionBoolean, Name: "keep_tempo", Description: "Keep the original speed (default: on)"},
+		},
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handlePlayAllCommand queues the whole library, or the part matching the
+// query/type options, sorted or shuffled. Scanning a large library can take a
+// while, so it answers with a deferred response and does the work in a goroutine.
+func handlePlayAllCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var query, fileType string
+	shuffle := shuffleOnAdd(i.GuildID) // an explicit shuffle option wins
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "query":
+			query = opt.StringValue()
+		case "type":
+			fileType = opt.StringValue()
+		case "shuffle":
+			shuffle = opt.BoolValue()
+		}
+	}
+
+	channelID := ""
+	if vs, err := s.State.VoiceState(i.GuildID, interactionUserID(i)); err == nil && vs.ChannelID != "" {
+		channelID = vs.ChannelID
+	} else if last := lastChannel(s, i.GuildID); last != nil {
+		channelID = last.ID
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	reply := func(content string) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("[playall] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		tracks, err := playAllTracks(i.GuildID, query, fileType, shuffle)
+		if err != nil {
+			reply(scanErrorMessage(err))
+			return
+		}
+		if len(tracks) == 0 {
+			reply("No sounds match those filters.")
+			return
+		}
+		reply(enqueueAll(s, i, channelID, tracks))
+	}()
+}
+
+// playAllTracks lists the library as full paths, filtered and ordered for /playall.
+// Playlists are left out; their entries would duplicate library files.
+func playAllTracks(guildID, query, fileType string, shuffle bool) ([]string, error) {
+	files, _, err := scanLibrary(soundsDir, effectiveExts(guildID))
+	if err != nil {
+		return nil, err
+	}
+	if fileType != "" {
+		files = filterByType(files, fileType)
+	}
+	needle := strings.ToLower(strings.TrimSpace(query))
+	var tracks []string
+	for _, f := range files {
+		if isPlaylist(f) || (needle != "" && !strings.Contains(strings.ToLower(f), needle)) {
+			continue
+		}
+		tracks = append(tracks, filepath.Join(soundsDir, f))
+	}
+	if shuffle {
+		shuffleTracks(tracks)
+	}
+	return tracks, nil
+}
+
+// queueOnSession appends tracks to the guild's queue, as many as fit within
+// MAX_QUEUE, and returns how many did (0 when it's full). live is false when
+// there's no session taking tracks, none or one that's stopping, and the
+// caller should start one instead.
+func queueOnSession(guildID string, tracks []string) (queued int, live bool) {
+	val, ok := playSessions.Load(guildID)
+	if !ok {
+		return 0, false
+	}
+	gp := val.(*guildPlayback)
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if gp.stopped {
+		return 0, false
+	}
+	tracks = tracks[:min(len(tracks), max(0, maxQueue-len(gp.queue)))]
+	if len(tracks) > 0 {
+		gp.queue = append(gp.queue, tracks...)
+		gp.queueChangedLocked()
+	}
+	return len(tracks), true
+}
+
+// enqueueAll appends tracks to the guild's queue, or starts a session with
+// them, keeping the queue within MAX_QUEUE. It returns the message for the user.
+func enqueueAll(s *discordgo.Session, i *discordgo.InteractionCreate, channelID string, tracks []string) string {
+	total := len(tracks)
+	if queued, live := queueOnSession(i.GuildID, tracks); live {
+		if queued == 0 {
+			return fmt.Sprintf("Queue is full (%d max).", maxQueue)
+		}
+		return queuedSummary(queued, total)
+	}
+
+	if channelID == "" {
+		// Shared by /playall and /category, so it doesn't name either.
+		return "Join a voice channel first, then try again."
+	}
+	// The first track plays right away and doesn't count against the queue.
+	if len(tracks) > maxQueue+1 {
+		tracks = tracks[:maxQueue+1]
+	}
+	if err := startPlayback(s, i.GuildID, channelID, tracks, i.Interaction); err != nil {
+		log.Printf("[enqueueAll] playback error: %v", err)
+		return playErrorMessage(err)
+	}
+	return fmt.Sprintf("Playing in <#%s>. %s", channelID, queuedSummary(len(tracks), total))
+}
+
+func queuedSummary(queued, total int) string {
+	if queued < total {
+		return fmt.Sprintf("Queued %d of %d matching sounds (queue limit is %d).", queued, total, maxQueue)
+	}
+	return fmt.Sprintf("Queued %d sounds.", queued)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// playErrCode classifies why playback couldn't start, so every caller can
+// explain it the same way.
+type playErrCode int
+
+const (
+	errCodeUnknown playErrCode = iota
+	errCodeNothingToPlay
+	errCodeFileMissing
+	errCodeFFmpegMissing
+	errCodeDecodeFailed
+	errCodeOpusMissing
+	errCodeBusy
+	errCodeNoPermission
+	errCodeVoiceJoin
+	errCodeVoiceTimeout
+	errCodeVoiceNoAudio
+	errCodeShuttingDown
+)
+
+// playErrInfo is what a user is told for a code: what went wrong and what to
+// try. name is the code as the control API reports it.
+type playErrInfo struct {
+	name, msg, hint string
+}
+
+var playErrInfos = map[playErrCode]playErrInfo{
+	errCodeUnknown:       {"unknown", "Couldn't start playback.", "Check the bot's log for details."},
+	errCodeNothingToPlay: {"nothing_to_play", "There was nothing to play.", "Pick a sound or a playlist with entries."},
+	errCodeFileMissing:   {"file_missing", "That sound file is missing or unreadable.", "It may have been moved or deleted; reopen the menu to refresh the list."},
+	errCodeFFmpegMissing: {"ffmpeg_missing", "The bot can't find ffmpeg.", "Ask the bot owner to install ffmpeg or set FFMPEG_PATH."},
+	errCodeDecodeFailed:  {"decode_failed", "That file couldn't be decoded.", "It may be corrupt or in a format ffmpeg doesn't read; try another copy."},
+	errCodeOpusMissing:   {"opus_missing", "The bot's ffmpeg can't encode Opus audio.", "Ask the bot owner to install an ffmpeg build with libopus."},
+	errCodeBusy:          {"busy", "Bot is busy, try again.", "Too many sounds are being prepared at once; wait a few seconds."},
+	errCodeNoPermission:  {"no_permission", "The bot isn't allowed to join or speak in that voice channel.", "Give it the Connect and Speak permissions there, or pick another channel."},
+	errCodeVoiceJoin:     {"voice_join_failed", "Couldn't join the voice channel.", "Discord may be having trouble; try again in a moment."},
+	errCodeVoiceTimeout:  {"voice_timeout", "Joined the voice channel, but the connection never became ready.", "This is usually a network hiccup; try again, or pick another channel."},
+	errCodeVoiceNoAudio:  {"voice_no_audio", "Connected to the voice channel, but Discord gave the bot no way to send audio.", "Try again; if it keeps happening, /restart the bot."},
+	errCodeShuttingDown:  {"shutting_down", "The bot is restarting.", "Try again in a minute."},
+}
+
+// playError is a startPlayback failure with its classification.
+type playError struct {
+	code playErrCode
+	err  error
+}
+
+func (e *playError) Error() string { return e.err.Error() }
+func (e *playError) Unwrap() error { return e.err }
+
+// newPlayError classifies err. A busy ffmpeg slot is always errCodeBusy,
+// whichever step hit it.
+func newPlayError(code playErrCode, err error) error {
+	if errors.Is(err, errFFmpegBusy) {
+		code = errCodeBusy
+	}
+	return &playError{code: code, err: err}
+}
+
+// playErrCodeOf returns the code of a playback error, errCodeUnknown for
+// unclassified ones.
+func playErrCodeOf(err error) playErrCode {
+	var pe *playError
+	if errors.As(err, &pe) {
+		return pe.code
+	}
+	if errors.Is(err, errFFmpegBusy) {
+		return errCodeBusy
+	}
+	return errCodeUnknown
+}
+
+// playErrName is the code's stable name, e.g. "voice_timeout".
+func playErrName(err error) string {
+	return playErrInfos[playErrCodeOf(err)].name
+}
+
+// playErrorMessage is the user-facing explanation of a playback error, with
+// a troubleshooting hint underneath.
+func playErrorMessage(err error) string {
+	info := playErrInfos[playErrCodeOf(err)]
+	return fmt.Sprintf("%s\n-# %s", info.msg, info.hint)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Playlist files listed alongside audio in the picker. Selecting one queues its entries.
+var playlistExts = map[string]struct{}{
+	".m3u":  {},
+	".m3u8": {},
+	".pls":  {},
+}
+
+func isPlaylist(name string) bool {
+	_, ok := playlistExts[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+func isURL(entry string) bool {
+	return strings.Contains(entry, "://")
+}
+
+// loadPlaylist reads an m3u/m3u8/pls file and returns its playable entries in
+// order. Relative entries resolve against the playlist's directory; missing or
+// unsupported entries (by exts) are skipped with a warning.
+func loadPlaylist(path string, exts extSet) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pls := strings.ToLower(filepath.Ext(path)) == ".pls"
+	baseDir := filepath.Dir(path)
+
+	var out []string
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // UTF-8 BOM
+		}
+
+		var entry string
+		if pls {
+			// [playlist] / NumberOfEntries / TitleN / LengthN are ignored; only FileN=... matters.
+			key, val, ok := strings.Cut(line, "=")
+			if !ok || !strings.HasPrefix(strings.ToLower(key), "file") {
+				continue
+			}
+			entry = strings.TrimSpace(val)
+		} else {
+			// Both simple and extended (#EXTM3U/#EXTINF) m3u: every non-comment line is an entry.
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entry = line
+		}
+		if entry == "" {
+			continue
+		}
+
+		resolved, err := resolvePlaylistEntry(baseDir, entry, exts)
+		if err != nil {
+			log.Printf("[loadPlaylist] %s:%d: skipping %q: %v", path, lineNo, entry, err)
+			continue
+		}
+		out = append(out, resolved)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+	return out, nil
+}
+
+func resolvePlaylistEntry(baseDir, entry string, exts extSet) (string, error) {
+	entry = strings.TrimPrefix(entry, "file://")
+	if isURL(entry) {
+		return entry, nil
+	}
+	entry = filepath.FromSlash(entry)
+	if !filepath.IsAbs(entry) {
+		entry = filepath.Join(baseDir, entry)
+	}
+	if isPlaylist(entry) {
+		return "", fmt.Errorf("nested playlists are not supported")
+	}
+	if _, ok := exts[strings.ToLower(filepath.Ext(entry))]; !ok {
+		return "", fmt.Errorf("unsupported file type")
+	}
+	info, err := os.Stat(entry)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("is a directory")
+	}
+	return entry, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// /playpath -> owner-only: play any readable file, bypassing the library and
+// its traversal guard. Every use is logged with an [AUDIT] prefix.
+func handlePlayPathCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	userID := interactionUserID(i)
+	if !isOwner(i) {
+		log.Printf("[AUDIT] /playpath refused for non-owner user=%s guild=%s", userID, i.GuildID)
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+
+	var arg string
+	start := -1 // the file's skip_intro
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "path":
+			arg = opt.StringValue()
+		case "start":
+			start = int(opt.IntValue())
+		}
+	}
+	path := filepath.Clean(arg)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(soundsDir, path) // ".." deliberately allowed
+	}
+	log.Printf("[AUDIT] /playpath by owner user=%s guild=%s: %q -> %s", userID, i.GuildID, arg, path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Can't read %s: %v", path, err)))
+		return
+	}
+	if info.IsDir() {
+		logRespondErr(i, respondPrivate(s, i, path+" is a directory."))
+		return
+	}
+
+	channelID := ""
+	if vs, err := s.State.VoiceState(i.GuildID, userID); err == nil && vs.ChannelID != "" {
+		channelID = vs.ChannelID
+	} else if last := lastChannel(s, i.GuildID); last != nil {
+		channelID = last.ID
+	}
+	if channelID == "" {
+		logRespondErr(i, respondPrivate(s, i, "Join a voice channel first, then run /playpath."))
+		return
+	}
+
+	tracks := []string{path}
+	if isPlaylist(path) {
+		if tracks, err = loadPlaylist(path, effectiveExts(i.GuildID)); err != nil || len(tracks) == 0 {
+			logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Could not load playlist %s: %v", path, err)))
+			return
+		}
+		if shuffleOnAdd(i.GuildID) {
+			shuffleTracks(tracks)
+		}
+	}
+
+	// Probing and joining voice take longer than the 3s interaction deadline.
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+	go func() {
+		content := fmt.Sprintf("Playing %s in <#%s>.", path, channelID)
+		if err := startPlaybackAt(s, i.GuildID, channelID, tracks, i.Interaction, start); err != nil {
+			log.Printf("[AUDIT] /playpath %s failed: %v", path, err)
+			content = playErrorMessage(err) + "\n```\n" + tailTruncate(err.Error(), 1500) + "\n```"
+		}
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			log.Printf("[playpath] failed to edit response: %v", err)
+		}
+	}()
+}
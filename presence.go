@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// presenceMu serialises updates so a stale status can't overwrite a newer one.
+var presenceMu sync.Mutex
+
+// presenceTypes maps PRESENCE_TYPE values to activity types.
+var presenceTypes = map[string]discordgo.ActivityType{
+	"playing":   discordgo.ActivityTypeGame,
+	"listening": discordgo.ActivityTypeListening,
+	"watching":  discordgo.ActivityTypeWatching,
+	"competing": discordgo.ActivityTypeCompeting,
+}
+
+// presenceTrack is what the status needs to know about a playing guild.
+type presenceTrack struct {
+	path   string
+	paused bool
+}
+
+// updatePresence sets the bot's status from the active sessions: the track
+// when one guild is playing, a server count for several, and idle (with the
+// optional PRESENCE_TYPE/PRESENCE_TEXT activity or IDLE_STATUS text) when
+// nothing is. It's called on every track change, pause and resume, since a
+// single track's status says whether it's paused.
+func updatePresence(s *discordgo.Session) {
+	presenceMu.Lock()
+	defer presenceMu.Unlock()
+
+	var playing []presenceTrack
+	playSessions.Range(func(_, v any) bool {
+		gp := v.(*guildPlayback)
+		gp.mu.Lock()
+		if !gp.stopped && gp.playing != "" {
+			playing = append(playing, presenceTrack{path: gp.playing, paused: gp.paused})
+		}
+		gp.mu.Unlock()
+		return true
+	})
+
+	var err error
+	switch len(playing) {
+	case 0:
+		status := discordgo.UpdateStatusData{Status: string(discordgo.StatusIdle)}
+		if presenceText != "" {
+			status.Activities = []*discordgo.Activity{{Name: presenceText, Type: presenceType}}
+		} else if idleStatus != "" {
+			status.Activities = []*discordgo.Activity{{Name: idleStatus, Type: discordgo.ActivityTypeCustom, State: idleStatus}}
+		}
+		err = s.UpdateStatusComplex(status)
+	case 1:
+		err = s.UpdateStatusComplex(discordgo.UpdateStatusData{
+			Status:     string(discordgo.StatusOnline),
+			Activities: []*discordgo.Activity{trackActivity(playing[0])},
+		})
+	default:
+		err = s.UpdateGameStatus(0, fmt.Sprintf("in %d servers", len(playing)))
+	}
+	if err != nil {
+		log.Printf("[presence] update failed: %v", err)
+	}
+}
+
+// trackActivity is "Listening to <track>", with "Paused" as its state while
+// paused. Discord ignores activity timestamps from bots, so there's no
+// elapsed bar to keep in step; the status changes only with the track.
+func trackActivity(t presenceTrack) *discordgo.Activity {
+	act := &discordgo.Activity{Name: trackLabel(t.path), Type: discordgo.ActivityTypeListening}
+	if t.paused {
+		act.State = "Paused"
+	}
+	return act
+}
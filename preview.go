@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	previewDefaultSeconds = 10
+	previewMaxSeconds     = 30
+)
+
+// handlePreviewCommand posts the first seconds of a sound as an mp3 in the
+// channel, so people can hear it without the bot joining voice. The encode
+// takes a moment, so it answers with a deferred response first.
+func handlePreviewCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var soundArg string
+	seconds := previewDefaultSeconds
+	for _, opt := range i.ApplicationCommandData().Options {
+		switch opt.Name {
+		case "sound":
+			soundArg = opt.StringValue()
+		case "seconds":
+			seconds = int(opt.IntValue())
+		}
+	}
+	sound, err := librarySound(i.GuildID, soundArg)
+	if err != nil {
+		logRespondErr(i, respondPrivate(s, i, err.Error()))
+		return
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	}))
+
+	reply := func(content string, files []*discordgo.File) {
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content, Files: files}); err != nil {
+			log.Printf("[preview] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		clip, err := previewClip(filepath.Join(soundsDir, sound), seconds)
+		if err != nil {
+			log.Printf("[preview] %s: %v", sound, err)
+			if errors.Is(err, errFFmpegBusy) {
+				reply("Bot is busy, try again in a moment.", nil)
+				return
+			}
+			reply("Couldn't make a preview of "+displayName(sound)+".", nil)
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(sound), filepath.Ext(sound)) + "-preview.mp3"
+		file := func() []*discordgo.File {
+			return []*discordgo.File{{Name: name, ContentType: "audio/mpeg", Reader: bytes.NewReader(clip)}}
+		}
+
+		_, err = s.ChannelMessageSendComplex(i.ChannelID, &discordgo.MessageSend{
+			Content:         fmt.Sprintf("Preview of %s (first %ds), requested by <@%s>", displayName(sound), seconds, interactionUserID(i)),
+			Files:           file(),
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			// Usually missing Send Messages/Attach Files here; the requester can still listen.
+			log.Printf("[preview] posting to channel %s failed, replying privately: %v", i.ChannelID, err)
+			reply("I can't post in this channel, so here's your preview:", file())
+			return
+		}
+		reply("Posted a preview of "+displayName(sound)+".", nil)
+	}()
+}
+
+// previewClip transcodes the first seconds of filePath to an mp3 in a temp
+// file and returns its contents.
+func previewClip(filePath string, seconds int) ([]byte, error) {
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return nil, err
+	}
+	defer releaseFFmpeg()
+	tmp, err := createTemp("preview-*.mp3")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg",
+		"-y", "-v", "error", "-nostdin", "-hide_banner",
+		"-i", filePath,
+		"-t", fmt.Sprint(seconds),
+		"-vn", "-b:a", "128k",
+		"-f", "mp3", tmp.Name(),
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v; stderr:\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(tmp.Name())
+}
+
+func previewCommand() *discordgo.ApplicationCommand {
+	minSeconds := 1.0
+	return &discordgo.ApplicationCommand{
+		Name:        "preview",
+		Description: "Post the start of a sound in chat without joining voice",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "sound", Description: "File path inside the sounds directory", Required: true},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "seconds",
+				Description: fmt.Sprintf("Clip length (default %d)", previewDefaultSeconds),
+				MinValue:    &minSeconds,
+				MaxValue:    previewMaxSeconds,
+			},
+		},
+	}
+}
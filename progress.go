@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	progressInterval = 10 * time.Second
+	progressBarWidth = 16
+	// Interaction tokens last 15 minutes; stop editing a followup shortly before.
+	followupLifetime = 14 * time.Minute
+	nextUpCount      = 3 // queued tracks previewed under the now-playing line
+)
+
+// nowPlayingNotice is where a track's now-playing line was posted: a channel
+// message, or a followup to the interaction that started playback.
+type nowPlayingNotice struct {
+	msg    *discordgo.Message
+	origin *discordgo.Interaction // set for followups, which are edited through it
+	text   string                 // the line without progress or the next-up preview
+	shown  string                 // the content as posted
+}
+
+// trackProgress keeps the now-playing notice current until done is closed:
+// every progressInterval it adds the elapsed/total position (unless
+// PROGRESS_UPDATES is off), and whenever the queue changes it refreshes the
+// next-up preview. Edits are skipped while the text is unchanged (e.g.
+// paused), and followups are left alone once their interaction token is about
+// to expire.
+func trackProgress(s *discordgo.Session, gp *guildPlayback, n nowPlayingNotice, filePath string, done <-chan struct{}) {
+	var total time.Duration
+	var tick <-chan time.Time
+	if progressUpdates {
+		total = cachedDuration(filePath)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var expires time.Time
+	if n.origin != nil {
+		created, err := discordgo.SnowflakeTimestamp(n.origin.ID)
+		if err != nil {
+			return
+		}
+		expires = created.Add(followupLifetime)
+	}
+
+	gp.mu.Lock()
+	if gp.queueChanged == nil {
+		gp.queueChanged = make(chan struct{}, 1)
+	}
+	changed := gp.queueChanged
+	gp.mu.Unlock()
+
+	last := n.shown
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick:
+		case <-changed:
+		}
+		if !expires.IsZero() && time.Now().After(expires) {
+			return
+		}
+		gp.mu.Lock()
+		current := gp.playing == filePath && (gp.streamer != nil || gp.stream != nil)
+		elapsed := gp.elapsedLocked()
+		next := gp.nextUpLocked()
+		gp.mu.Unlock()
+		if !current {
+			return
+		}
+
+		var progress string
+		if progressUpdates {
+			progress = progressLine(elapsed, total)
+		}
+		content := noticeContent(n.text, progress, next)
+		if content == last {
+			continue
+		}
+		var err error
+		if n.origin != nil {
+			_, err = s.FollowupMessageEdit(n.origin, n.msg.ID, &discordgo.WebhookEdit{Content: &content})
+		} else {
+			_, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+				ID:              n.msg.ID,
+				Channel:         n.msg.ChannelID,
+				Content:         &content,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+		}
+		if err != nil {
+			log.Printf("[progress] couldn't update now-playing message %s: %v", n.msg.ID, err)
+			return
+		}
+		last = content
+	}
+}
+
+// noticeContent is the now-playing notice: its line, then the progress and
+// the next-up preview where there are any.
+func noticeContent(text, progress, next string) string {
+	for _, extra := range []string{progress, next} {
+		if extra != "" {
+			text += "\n" + extra
+		}
+	}
+	return text
+}
+
+// nextUpLocked previews the head of the queue, e.g. "Next up: a, b, c (+4
+// more)", or returns "" when nothing is queued. Call with gp.mu held.
+func (gp *guildPlayback) nextUpLocked() string {
+	if len(gp.queue) == 0 {
+		return ""
+	}
+	var labels []string
+	for _, t := range gp.queue[:min(len(gp.queue), nextUpCount)] {
+		labels = append(labels, trackLabel(t))
+	}
+	line := "Next up: " + strings.Join(labels, ", ")
+	if more := len(gp.queue) - len(labels); more > 0 {
+		line += fmt.Sprintf(" (+%d more)", more)
+	}
+	return line
+}
+
+// queueChangedLocked has the live now-playing notice, if any, refresh its
+// next-up preview. Call with gp.mu held, after changing gp.queue.
+func (gp *guildPlayback) queueChangedLocked() {
+	select {
+	case gp.queueChanged <- struct{}{}:
+	default:
+	}
+}
+
+// progressLine renders e.g. "`▬▬▬▬🔘▬▬▬▬` 1:02 / 3:45", or just the elapsed
+// time when the length is unknown (streams, uploads).
+func progressLine(elapsed, total time.Duration) string {
+	if total <= 0 {
+		return "`" + formatPosition(elapsed) + "`"
+	}
+	elapsed = min(elapsed, total)
+	pos := int(int64(progressBarWidth-1) * int64(elapsed) / int64(total))
+	bar := strings.Repeat("▬", pos) + "🔘" + strings.Repeat("▬", progressBarWidth-1-pos)
+	return fmt.Sprintf("`%s` %s / %s", bar, formatPosition(elapsed), formatPosition(total))
+}
+
+// formatPosition formats a track position as m:ss, or h:mm:ss past an hour.
+func formatPosition(d time.Duration) string {
+	secs := int(d.Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNextUp(t *testing.T) {
+	root := testLibrary(t)
+	track := func(name string) string { return filepath.Join(root, name) }
+	for _, tc := range []struct {
+		queue []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{track("a.mp3")}, "Next up: a"},
+		{[]string{track("a.mp3"), track("b.mp3"), track("c.mp3")}, "Next up: a, b, c"},
+		{[]string{track("a.mp3"), track("b.mp3"), track("c.mp3"), track("d.mp3"), "https://cdn.example.com/e.mp3?ex=1"}, "Next up: a, b, c (+2 more)"},
+	} {
+		gp := &guildPlayback{queue: tc.queue}
+		if got := gp.nextUpLocked(); got != tc.want {
+			t.Errorf("queue %v: got %q, want %q", tc.queue, got, tc.want)
+		}
+	}
+}
+
+func TestNoticeContent(t *testing.T) {
+	for _, tc := range []struct {
+		progress, next, want string
+	}{
+		{"", "", "Now playing: a"},
+		{"`0:10`", "", "Now playing: a\n`0:10`"},
+		{"", "Next up: b", "Now playing: a\nNext up: b"},
+		{"`0:10`", "Next up: b", "Now playing: a\n`0:10`\nNext up: b"},
+	} {
+		if got := noticeContent("Now playing: a", tc.progress, tc.next); got != tc.want {
+			t.Errorf("noticeContent(%q, %q): got %q, want %q", tc.progress, tc.next, got, tc.want)
+		}
+	}
+}
+
+// A change to the queue wakes the notice's updater once, and never blocks
+// whoever changed it, whether or not a notice is live.
+func TestQueueChangedLocked(t *testing.T) {
+	gp := &guildPlayback{}
+	gp.queueChangedLocked() // no notice yet
+
+	gp.queueChanged = make(chan struct{}, 1)
+	gp.queueChangedLocked()
+	gp.queueChangedLocked()
+	select {
+	case <-gp.queueChanged:
+	default:
+		t.Fatal("no wakeup after a queue change")
+	}
+	select {
+	case <-gp.queueChanged:
+		t.Error("two changes before the updater woke gave two wakeups")
+	default:
+	}
+}
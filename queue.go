@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const shuffleOnAddFile = "shuffle-on-add.json"
+
+// shuffleOnAddGuilds holds the guilds that shuffle every batch they enqueue
+// (playlists, /playall), persisted in DATA_DIR.
+var shuffleOnAddGuilds = struct {
+	sync.Mutex
+	enabled map[string]bool
+}{enabled: make(map[string]bool)}
+
+func loadShuffleOnAdd() {
+	shuffleOnAddGuilds.Lock()
+	defer shuffleOnAddGuilds.Unlock()
+	if err := loadJSON(shardFileName(shuffleOnAddFile), &shuffleOnAddGuilds.enabled); err != nil {
+		log.Printf("[queue] couldn't load shuffle-on-add settings: %v", err)
+	}
+	if shuffleOnAddGuilds.enabled == nil {
+		shuffleOnAddGuilds.enabled = make(map[string]bool)
+	}
+}
+
+func shuffleOnAdd(guildID string) bool {
+	shuffleOnAddGuilds.Lock()
+	defer shuffleOnAddGuilds.Unlock()
+	return shuffleOnAddGuilds.enabled[guildID]
+}
+
+// shuffleTracks shuffles a batch in place before it is enqueued. Only the
+// new batch is reordered, never tracks already waiting.
+func shuffleTracks(tracks []string) {
+	rand.Shuffle(len(tracks), func(a, b int) { tracks[a], tracks[b] = tracks[b], tracks[a] })
+}
+
+func handleQueueCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 || data.Options[0].Name != "shuffle-on-add" {
+		return
+	}
+
+	shuffleOnAddGuilds.Lock()
+	on := !shuffleOnAddGuilds.enabled[i.GuildID] // no option = toggle
+	for _, opt := range data.Options[0].Options {
+		if opt.Name == "enabled" {
+			on = opt.BoolValue()
+		}
+	}
+	if on {
+		shuffleOnAddGuilds.enabled[i.GuildID] = true
+	} else {
+		delete(shuffleOnAddGuilds.enabled, i.GuildID)
+	}
+	if err := saveJSON(shardFileName(shuffleOnAddFile), shuffleOnAddGuilds.enabled); err != nil {
+		log.Printf("[queue] couldn't save shuffle-on-add settings: %v", err)
+	}
+	shuffleOnAddGuilds.Unlock()
+
+	log.Printf("[queue] guild=%s shuffle-on-add=%v", i.GuildID, on)
+	msg := "Shuffle on add is **off**: playlists and /playall keep their order."
+	if on {
+		msg = "Shuffle on add is **on**: playlists and /playall batches are shuffled as they're queued. Tracks already queued keep their order."
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+// handlePlayNextCommand moves the queued track at a 1-based position to the
+// front, so it plays right after the current one.
+func handlePlayNextCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var pos int
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "position" {
+			pos = int(opt.IntValue())
+		}
+	}
+
+	val, ok := playSessions.Load(i.GuildID)
+	if !ok {
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing."))
+		return
+	}
+	gp := val.(*guildPlayback)
+	gp.mu.Lock()
+	if gp.stopped || len(gp.queue) == 0 {
+		gp.mu.Unlock()
+		logRespondErr(i, respondPrivate(s, i, "The queue is empty."))
+		return
+	}
+	if pos < 1 || pos > len(gp.queue) {
+		n := len(gp.queue)
+		gp.mu.Unlock()
+		logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("Position must be between 1 and %d.", n)))
+		return
+	}
+	track := gp.queue[pos-1]
+	copy(gp.queue[1:pos], gp.queue[:pos-1])
+	gp.queue[0] = track
+	gp.queueChangedLocked()
+	gp.mu.Unlock()
+
+	log.Printf("[queue] guild=%s moved #%d to next: %s", i.GuildID, pos, track)
+	msg := fmt.Sprintf("%s plays next.", trackLabel(track))
+	if pos == 1 {
+		msg = fmt.Sprintf("%s was already next.", trackLabel(track))
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg, nil))
+}
+
+func queueCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:        "queue",
+		Description: "Queue settings for this server",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "shuffle-on-add",
+				Description: "Shuffle playlists and /playall batches as they're queued",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionBoolean, Name: "enabled", Description: "On or off (default: toggle)"},
+				},
+			},
+		},
+	}
+}
+
+func playNextCommand() *discordgo.ApplicationCommand {
+	minPos := 1.0
+	return &discordgo.ApplicationCommand{
+		Name:        "playnext",
+		Description: "Move a queued track to play right after the current one",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "position",
+				Description: "Its place in the queue (1 = already next)",
+				Required:    true,
+				MinValue:    &minPos,
+			},
+		},
+	}
+}
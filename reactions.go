@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Reaction controls on the now-playing notice (REACTION_CONTROLS).
+const (
+	reactPause = "⏸️"
+	reactSkip  = "⏭️"
+	reactStop  = "⏹️"
+)
+
+func addControlReactions(s *discordgo.Session, msg *discordgo.Message) {
+	for _, emoji := range []string{reactPause, reactSkip, reactStop} {
+		if err := s.MessageReactionAdd(msg.ChannelID, msg.ID, emoji); err != nil {
+			log.Printf("[reactions] couldn't add %s to message %s: %v", emoji, msg.ID, err)
+			return
+		}
+	}
+}
+
+// onReactionAdd maps control reactions on a now-playing notice to pause/resume,
+// skip and stop. Only people in the bot's voice channel may use them.
+func onReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if s.State.User != nil && r.UserID == s.State.User.ID {
+		return
+	}
+	val, ok := playSessions.Load(r.GuildID)
+	if !ok {
+		return
+	}
+	gp := val.(*guildPlayback)
+	gp.mu.Lock()
+	match := gp.nowPlaying != nil && gp.nowPlaying.ID == r.MessageID
+	botChannel := ""
+	if gp.vc != nil {
+		botChannel = gp.vc.ChannelID
+	}
+	paused := gp.paused
+	gp.mu.Unlock()
+	if !match {
+		return
+	}
+
+	// Drop the reaction whatever happens, so it can be used again.
+	defer func() {
+		if err := s.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.APIName(), r.UserID); err != nil {
+			log.Printf("[reactions] couldn't remove reaction (missing Manage Messages?): %v", err)
+		}
+	}()
+
+	vs, err := s.State.VoiceState(r.GuildID, r.UserID)
+	if err != nil || botChannel == "" || vs.ChannelID != botChannel {
+		return
+	}
+	var roles []string
+	if r.Member != nil {
+		roles = r.Member.Roles
+	}
+	perms, _ := s.State.UserChannelPermissions(r.UserID, r.ChannelID)
+	if _, ok := djAllowed(r.GuildID, r.UserID, roles, perms&discordgo.PermissionAdministrator != 0, djScopeControls); !ok {
+		log.Printf("[djrole] denied reaction %s for user=%s guild=%s", r.Emoji.Name, r.UserID, r.GuildID)
+		return
+	}
+
+	switch bareEmoji(r.Emoji.Name) {
+	case bareEmoji(reactPause):
+		if paused {
+			gp.resume()
+		} else {
+			gp.pause()
+		}
+		updatePresence(s)
+	case bareEmoji(reactSkip):
+		gp.skip()
+	case bareEmoji(reactStop):
+		log.Printf("[reactions] stop by %s in guild=%s", r.UserID, r.GuildID)
+		stopGuildPlayback(r.GuildID)
+	}
+}
+
+// bareEmoji drops the emoji presentation selector; Discord doesn't always echo it.
+func bareEmoji(name string) string {
+	return strings.TrimSuffix(name, "\ufe0f")
+}
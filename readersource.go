@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// Tracks are passed around (queue, prefetch, history) as strings, so a reader
+// source is registered under a pseudo-path "reader:<n>" that startEncode
+// resolves back to the reader. Anything dca can decode from a pipe works,
+// e.g. a live stream or TTS output POSTed to the control API.
+const readerPrefix = "reader:"
+
+// readerTrack is an io.ReadCloser waiting to be played once.
+type readerTrack struct {
+	label string
+	r     io.ReadCloser
+	done  chan struct{} // closed once playback is over and the reader is closed
+
+	taken   atomic.Bool
+	release sync.Once
+}
+
+var (
+	readerTracks sync.Map // pseudo-path → *readerTrack
+	readerSeq    atomic.Uint64
+)
+
+// registerReader makes r playable under the returned pseudo-path. The reader
+// is closed after it has been played, or by releaseReader if it never is.
+func registerReader(label string, r io.ReadCloser) (string, *readerTrack) {
+	id := readerPrefix + strconv.FormatUint(readerSeq.Add(1), 10)
+	rt := &readerTrack{label: label, r: r, done: make(chan struct{})}
+	readerTracks.Store(id, rt)
+	return id, rt
+}
+
+func isReaderTrack(filePath string) bool {
+	return strings.HasPrefix(filePath, readerPrefix)
+}
+
+// releaseReader closes the reader and forgets the track. Safe to call twice.
+func releaseReader(id string) {
+	if val, ok := readerTracks.LoadAndDelete(id); ok {
+		rt := val.(*readerTrack)
+		rt.release.Do(func() {
+			if err := rt.r.Close(); err != nil {
+				log.Printf("[reader] closing %s: %v", rt.label, err)
+			}
+			close(rt.done)
+		})
+	}
+}
+
+// readerLabel is the display name of a reader track, for trackLabel.
+func readerLabel(id string) string {
+	if val, ok := readerTracks.Load(id); ok {
+		return val.(*readerTrack).label
+	}
+	return "live audio"
+}
+
+// readerSource is an encode session reading from a registered reader; its
+// Cleanup also closes the reader.
+type readerSource struct {
+	*primedEncoder
+	id string
+}
+
+func (r *readerSource) Cleanup() {
+	// Close the reader first: ffmpeg's stdin copy blocks on it, and dca's
+	// Cleanup waits for ffmpeg to exit.
+	releaseReader(r.id)
+	r.primedEncoder.Cleanup()
+}
+
+// startReaderEncode pipes a registered reader into ffmpeg. No sidecar,
+// loudness or passthrough handling applies; there is no file to look at.
+func startReaderEncode(id string, to trackOptions) (trackSource, error) {
+	val, ok := readerTracks.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("reader source %s is gone", id)
+	}
+	rt := val.(*readerTrack)
+	if rt.taken.Swap(true) {
+		return nil, fmt.Errorf("reader source %s was already played", id)
+	}
+
+	opts := encodeOptions()
+	opts.AudioFilter = audioFilter(id, opts.Volume, to, false)
+	to.applyBitrate(opts)
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		releaseReader(id)
+		return nil, err
+	}
+	log.Printf("[encodeTrack] starting encoder for %s (%s)", id, rt.label)
+	enc, err := dca.EncodeMem(rt.r, opts)
+	if err != nil {
+		releaseFFmpeg()
+		releaseReader(id)
+		return nil, fmt.Errorf("failed to start ffmpeg/dca encode for %s: %w", rt.label, err)
+	}
+	primed, err := primeEncoder(enc, rt.label)
+	if err != nil {
+		releaseFFmpeg()
+		releaseReader(id)
+		return nil, err
+	}
+	return &slotSource{trackSource: &readerSource{primedEncoder: primed, id: id}}, nil
+}
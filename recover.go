@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const panicReply = "Something went wrong handling that. It has been logged; please try again."
+
+// recoverInteraction turns a panicking handler into a logged stack trace and
+// an ephemeral error for the user, instead of a crash and a failed
+// interaction. Use as `defer recoverInteraction(s, i)`.
+func recoverInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("[panic] while handling interaction type=%v guild=%s user=%s: %v\n%s",
+		i.Type, i.GuildID, interactionUserID(i), r, debug.Stack())
+
+	if err := respondPrivate(s, i, panicReply); err != nil {
+		// The handler had already responded (or deferred) before panicking.
+		if _, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: panicReply,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		}); err != nil {
+			log.Printf("[panic] couldn't tell the user: %v", err)
+		}
+	}
+}
+
+// recoverPlayback keeps a panic in a guild's playback lifecycle from taking
+// the whole bot down. run's own deferred cleanup has already disconnected
+// and removed the session by the time this runs; stop kills a leftover encoder.
+func (gp *guildPlayback) recoverPlayback(s *discordgo.Session) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("[panic] in playback for guild=%s: %v\n%s", gp.guildID, r, debug.Stack())
+	gp.stop()
+	announce(s, gp.guildID, gp.origin, "Playback stopped because of an internal error.")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRecoverInteraction(t *testing.T) {
+	s, api := newTestSession(t)
+
+	// A handler that panics before responding gets the error as its response.
+	i := testInteraction(discordgo.InteractionApplicationCommand, "1", discordgo.ApplicationCommandInteractionData{Name: "boom"})
+	func() {
+		defer recoverInteraction(s, i)
+		panic("boom")
+	}()
+	got := api.responses()
+	if len(got) != 1 || got[0].Content != panicReply || got[0].Flags != discordgo.MessageFlagsEphemeral {
+		t.Fatalf("responses after a panic: got %+v, want one ephemeral %q", got, panicReply)
+	}
+
+	// One that already deferred gets it as a followup instead.
+	i = testInteraction(discordgo.InteractionApplicationCommand, "1", discordgo.ApplicationCommandInteractionData{Name: "boom"})
+	func() {
+		defer recoverInteraction(s, i)
+		logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredChannelMessageWithSource}))
+		panic("boom")
+	}()
+	if f := api.followups(); len(f) != 1 || f[0].Content != panicReply {
+		t.Fatalf("followups after a panic past the response: got %+v, want %q", f, panicReply)
+	}
+
+	// The bot goes on handling interactions.
+	onInteractionCreate(s, testInteraction(discordgo.InteractionMessageComponent, "1", discordgo.MessageComponentInteractionData{CustomID: "seek_forward"}))
+	got = api.responses()
+	if last := got[len(got)-1]; !strings.Contains(last.Content, "no longer supported") {
+		t.Errorf("next interaction after a panic: got %q", last.Content)
+	}
+}
+
+func TestRecoverPlayback(t *testing.T) {
+	s, api := newTestSession(t)
+	origin := testInteraction(discordgo.InteractionMessageComponent, "1", discordgo.MessageComponentInteractionData{CustomID: "sound_select"})
+	gp := &guildPlayback{guildID: "1", origin: origin.Interaction}
+
+	func() {
+		defer gp.recoverPlayback(s)
+		panic("boom")
+	}()
+
+	gp.mu.Lock()
+	stopped := gp.stopped
+	gp.mu.Unlock()
+	if !stopped {
+		t.Error("the session wasn't stopped after a panic")
+	}
+	if f := api.followups(); len(f) != 1 || !strings.Contains(f[0].Content, "internal error") {
+		t.Errorf("announcements after a panic: got %+v", f)
+	}
+}
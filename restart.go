@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// restartExitCode is what the process exits with when it asks a wrapper
+// (systemd, a shell loop, ...) to start it again.
+const restartExitCode = 3
+
+// restartCh is signalled by /restart; main treats it like a shutdown signal.
+var restartCh = make(chan struct{}, 1)
+
+func handleRestartCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isOwner(i) {
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+	log.Printf("[restart] requested by %s", interactionUserID(i))
+	msg := "Restarting: stopping all playback, back in a few seconds."
+	if !restartExec {
+		msg = "Restarting: stopping all playback and exiting for the process manager to restart me."
+	}
+	logRespondErr(i, respondPrivate(s, i, msg))
+	select {
+	case restartCh <- struct{}{}:
+	default: // already restarting
+	}
+}
+
+// restartProcess replaces the current process with a fresh copy of the binary,
+// or exits with restartExitCode when re-exec is disabled or unavailable.
+func restartProcess() {
+	if restartExec {
+		err := execSelf()
+		log.Printf("[restart] re-exec failed, exiting with code %d instead: %v", restartExitCode, err)
+	}
+	os.Exit(restartExitCode)
+}
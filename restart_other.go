@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// execSelf is unsupported without exec(2); the caller falls back to exiting.
+func execSelf() error {
+	return errors.New("re-exec is not supported on this platform")
+}
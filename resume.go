@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// resumeSaveInterval is how often playing positions are written to disk, so a
+// crash loses at most this much of the current track.
+const resumeSaveInterval = 15 * time.Second
+
+// shuttingDown stops playback cleanup from clearing the saved state while
+// main is stopping every session on the way out.
+var shuttingDown atomic.Bool
+
+// resumeEntry is what one guild was playing when the state was last saved.
+type resumeEntry struct {
+	GuildID     string    `json:"guild_id"`
+	ChannelID   string    `json:"channel_id"`
+	Playing     string    `json:"playing"`
+	PositionSec int       `json:"position_sec"`
+	Queue       []string  `json:"queue,omitempty"`
+	Saved       time.Time `json:"saved"`
+}
+
+// resumeSaves serializes writers so an older snapshot can't land last.
+var resumeSaves sync.Mutex
+
+// resumeFileName is per shard, like scheduleFileName.
+func resumeFileName() string {
+	return shardFileName("resume.json")
+}
+
+// saveResumeState records every guild's current track, position and queue.
+// It's a no-op unless RESUME_ON_START is set, and once shutdown has begun.
+func saveResumeState() {
+	if !resumeOnStart || shuttingDown.Load() {
+		return
+	}
+	resumeSaves.Lock()
+	defer resumeSaves.Unlock()
+
+	entries := []resumeEntry{}
+	now := time.Now()
+	playSessions.Range(func(_, value any) bool {
+		gp := value.(*guildPlayback)
+		gp.mu.Lock()
+		defer gp.mu.Unlock()
+		if e, ok := resumeEntryLocked(gp, now); ok {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	sort.Slice(entries, func(a, b int) bool { return entries[a].GuildID < entries[b].GuildID })
+	if err := saveJSON(resumeFileName(), entries); err != nil {
+		log.Printf("[resume] couldn't save playback state: %v", err)
+	}
+}
+
+// resumeEntryLocked is gp's current track, position and queue as saved for
+// RESUME_ON_START, or ok is false when there's nothing to resume. Call with
+// gp.mu held.
+func resumeEntryLocked(gp *guildPlayback, now time.Time) (e resumeEntry, ok bool) {
+	// Streams fed over the control API can't be reopened.
+	if gp.stopped || gp.playing == "" || isReaderTrack(gp.playing) {
+		return e, false
+	}
+	e = resumeEntry{
+		GuildID:     gp.guildID,
+		ChannelID:   gp.channelID,
+		Playing:     gp.playing,
+		PositionSec: int(gp.elapsedLocked().Seconds()),
+		Saved:       now,
+	}
+	// Live streams have no position to seek to.
+	if isURL(gp.playing) {
+		e.PositionSec = 0
+	}
+	for _, t := range gp.queue {
+		if !isReaderTrack(t) {
+			e.Queue = append(e.Queue, t)
+		}
+	}
+	return e, true
+}
+
+// resumePlayback rejoins the channels that were playing when the bot last
+// stopped and continues from the saved position. Guilds whose channel is gone
+// or no longer joinable are dropped with a notice. It then keeps the saved
+// positions fresh while the bot runs.
+func resumePlayback(s *discordgo.Session) {
+	if !resumeOnStart {
+		return
+	}
+	var entries []resumeEntry
+	if err := loadJSON(resumeFileName(), &entries); err != nil {
+		log.Printf("[resume] couldn't load playback state: %v", err)
+	}
+	for _, e := range entries {
+		if err := resumeGuild(s, e); err != nil {
+			log.Printf("[resume] guild=%s: not resuming %s: %v", e.GuildID, trackLabel(e.Playing), err)
+			msg := fmt.Sprintf("Couldn't resume %s after the restart: %v", trackLabel(e.Playing), err)
+			if playErrCodeOf(err) != errCodeUnknown {
+				msg = fmt.Sprintf("Couldn't resume %s after the restart. %s", trackLabel(e.Playing), playErrorMessage(err))
+			}
+			announce(s, e.GuildID, nil, msg)
+		}
+	}
+	saveResumeState()
+
+	go func() {
+		for range time.Tick(resumeSaveInterval) {
+			saveResumeState()
+		}
+	}()
+}
+
+func resumeGuild(s *discordgo.Session, e resumeEntry) error {
+	// Guilds arrive over the gateway after startup, so ask the API directly.
+	ch, err := s.Channel(e.ChannelID)
+	if err != nil || ch.GuildID != e.GuildID {
+		return errors.New("the voice channel no longer exists")
+	}
+	tracks := append([]string{e.Playing}, e.Queue...)
+	log.Printf("[resume] guild=%s: resuming %s at %ds in channel=%s (%d queued)",
+		e.GuildID, trackLabel(e.Playing), e.PositionSec, e.ChannelID, len(e.Queue))
+	return startPlaybackAt(s, e.GuildID, e.ChannelID, tracks, nil, e.PositionSec)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// scheduledSound is one /schedule entry. At is the next time it fires.
+type scheduledSound struct {
+	ID        int       `json:"id"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id,omitempty"` // "" = SCHEDULE_CHANNEL for the guild
+	Sound     string    `json:"sound"`                // relative to soundsDir
+	At        time.Time `json:"at"`
+	Repeat    string    `json:"repeat,omitempty"` // "", "hourly" or "daily"
+	CreatedBy string    `json:"created_by"`
+}
+
+type scheduleFile struct {
+	NextID    int               `json:"next_id"`
+	Schedules []*scheduledSound `json:"schedules"`
+}
+
+var schedules = struct {
+	sync.Mutex
+	nextID int
+	items  map[int]*scheduledSound
+	timers map[int]*time.Timer
+}{nextID: 1, items: make(map[int]*scheduledSound), timers: make(map[int]*time.Timer)}
+
+var scheduleRepeats = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+}
+
+// scheduleFileName is per shard: each shard only sees its own guilds' commands.
+func scheduleFileName() string {
+	return shardFileName("schedules.json")
+}
+
+// loadSchedules restores persisted schedules and arms their timers. Repeating
+// entries that came due while the bot was down skip ahead; one-offs are dropped.
+func loadSchedules(s *discordgo.Session) {
+	var f scheduleFile
+	if err := loadJSON(scheduleFileName(), &f); err != nil {
+		log.Printf("[schedule] couldn't load schedules: %v", err)
+		return
+	}
+
+	now := time.Now()
+	schedules.Lock()
+	defer schedules.Unlock()
+	if f.NextID > schedules.nextID {
+		schedules.nextID = f.NextID
+	}
+	for _, sc := range f.Schedules {
+		if !sc.At.After(now) {
+			every, ok := scheduleRepeats[sc.Repeat]
+			if !ok {
+				log.Printf("[schedule] dropping #%d (%s): missed while offline", sc.ID, sc.Sound)
+				continue
+			}
+			for !sc.At.After(now) {
+				sc.At = sc.At.Add(every)
+			}
+		}
+		schedules.items[sc.ID] = sc
+		armScheduleLocked(s, sc)
+	}
+	if len(schedules.items) > 0 {
+		log.Printf("[schedule] restored %d schedule(s)", len(schedules.items))
+	}
+	saveSchedulesLocked()
+}
+
+func armScheduleLocked(s *discordgo.Session, sc *scheduledSound) {
+	id := sc.ID
+	schedules.timers[id] = time.AfterFunc(time.Until(sc.At), func() { fireSchedule(s, id) })
+}
+
+func saveSchedulesLocked() {
+	f := scheduleFile{NextID: schedules.nextID}
+	for _, sc := range schedules.items {
+		f.Schedules = append(f.Schedules, sc)
+	}
+	sort.Slice(f.Schedules, func(a, b int) bool { return f.Schedules[a].ID < f.Schedules[b].ID })
+	if err := saveJSON(scheduleFileName(), f); err != nil {
+		log.Printf("[schedule] couldn't save schedules: %v", err)
+	}
+}
+
+func fireSchedule(s *discordgo.Session, id int) {
+	schedules.Lock()
+	sc, ok := schedules.items[id]
+	if !ok {
+		schedules.Unlock()
+		return
+	}
+	fired := *sc
+	if every, repeats := scheduleRepeats[sc.Repeat]; repeats {
+		for !sc.At.After(time.Now()) {
+			sc.At = sc.At.Add(every)
+		}
+		armScheduleLocked(s, sc)
+	} else {
+		delete(schedules.items, id)
+		delete(schedules.timers, id)
+	}
+	saveSchedulesLocked()
+	schedules.Unlock()
+
+	log.Printf("[schedule] firing #%d in guild=%s: %s", fired.ID, fired.GuildID, fired.Sound)
+	playScheduled(s, &fired)
+}
+
+// playScheduled queues the sound behind whatever is playing, or joins the
+// schedule's channel and plays it.
+func playScheduled(s *discordgo.Session, sc *scheduledSound) {
+	if !effectiveExts(sc.GuildID).allows(sc.Sound) {
+		log.Printf("[schedule] #%d skipped: %s isn't one of the guild's file types any more", sc.ID, sc.Sound)
+		return
+	}
+	fullPath := filepath.Join(soundsDir, sc.Sound)
+	if val, ok := playSessions.Load(sc.GuildID); ok {
+		gp := val.(*guildPlayback)
+		gp.mu.Lock()
+		defer gp.mu.Unlock()
+		if gp.stopped {
+			return
+		}
+		if len(gp.queue) >= maxQueue {
+			log.Printf("[schedule] #%d skipped: queue is full", sc.ID)
+			return
+		}
+		gp.queue = append(gp.queue, fullPath)
+		gp.queueChangedLocked()
+		return
+	}
+
+	channelID := sc.ChannelID
+	if channelID == "" {
+		channelID = scheduleChannels[sc.GuildID]
+	}
+	if channelID == "" {
+		log.Printf("[schedule] #%d skipped: no channel and no SCHEDULE_CHANNEL for guild=%s", sc.ID, sc.GuildID)
+		return
+	}
+	if err := startPlayback(s, sc.GuildID, channelID, []string{fullPath}, nil); err != nil {
+		log.Printf("[schedule] #%d playback error: %v", sc.ID, err)
+	}
+}
+
+// parseScheduleTime accepts "HH:MM" (next occurrence, bot's local time), a
+// relative "in 10m" / "10m", or an absolute "2006-01-02 15:04" / RFC 3339 time.
+func parseScheduleTime(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(v, "in "))); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("duration must be positive")
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", v, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			if !t.After(now) {
+				return time.Time{}, fmt.Errorf("%s is in the past", v)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("couldn't read %q; use HH:MM, 2006-01-02 15:04, or a duration like 30m", v)
+}
+
+func handleScheduleCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+	sub := data.Options[0]
+	opts := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, o := range sub.Options {
+		opts[o.Name] = o
+	}
+
+	switch sub.Name {
+	case "add":
+		handleScheduleAdd(s, i, opts)
+	case "list":
+		handleScheduleList(s, i)
+	case "cancel":
+		id := int(opts["id"].IntValue())
+		schedules.Lock()
+		sc, ok := schedules.items[id]
+		if ok && sc.GuildID == i.GuildID {
+			schedules.timers[id].Stop()
+			delete(schedules.timers, id)
+			delete(schedules.items, id)
+			saveSchedulesLocked()
+		}
+		schedules.Unlock()
+		if !ok || sc.GuildID != i.GuildID {
+			logRespondErr(i, respondPrivate(s, i, fmt.Sprintf("No schedule #%d in this server.", id)))
+			return
+		}
+		logRespondErr(i, respondEphemeral(s, i, fmt.Sprintf("Cancelled schedule #%d (%s).", id, displayName(sc.Sound)), nil))
+	}
+}
+
+// dropGuildSchedules cancels and forgets every schedule in guildID, for when
+// the bot is removed from it, and returns how many there were.
+func dropGuildSchedules(guildID string) int {
+	schedules.Lock()
+	defer schedules.Unlock()
+	n := 0
+	for id, sc := range schedules.items {
+		if sc.GuildID != guildID {
+			continue
+		}
+		if t := schedules.timers[id]; t != nil {
+			t.Stop()
+		}
+		delete(schedules.timers, id)
+		delete(schedules.items, id)
+		n++
+	}
+	if n > 0 {
+		saveSchedulesLocked()
+	}
+	return n
+}
+
+func handleScheduleAdd(s *discordgo.Session, i *discordgo.InteractionCreate, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	at, err := parseScheduleTime(opts["time"].StringValue(), time.Now())
+	if err != nil {
+		logRespondErr(i, respondPrivate(s, i, "Invalid time: "+err.Error()))
+		return
+	}
+
+	sound, err := librarySound(i.GuildID, opts["sound"].StringValue())
+	if err != nil {
+		logRespondErr(i, respondPrivate(s, i, err.Error()))
+		return
+	}
+
+	sc := &scheduledSound{
+		GuildID:   i.GuildID,
+		Sound:     sound,
+		At:        at,
+		CreatedBy: interactionUserID(i),
+	}
+	if o, ok := opts["repeat"]; ok {
+		sc.Repeat = o.StringValue()
+	}
+	if o, ok := opts["channel"]; ok {
+		sc.ChannelID = o.ChannelValue(nil).ID
+	} else if scheduleChannels[i.GuildID] == "" {
+		logRespondErr(i, respondPrivate(s, i, "Pick a channel; this server has no default SCHEDULE_CHANNEL."))
+		return
+	}
+
+	schedules.Lock()
+	sc.ID = schedules.nextID
+	schedules.nextID++
+	schedules.items[sc.ID] = sc
+	armScheduleLocked(s, sc)
+	saveSchedulesLocked()
+	schedules.Unlock()
+
+	msg := fmt.Sprintf("Scheduled #%d: %s at <t:%d:f>", sc.ID, displayName(sound), at.Unix())
+	if sc.Repeat != "" {
+		msg += ", repeating " + sc.Repeat
+	}
+	logRespondErr(i, respondEphemeral(s, i, msg+".", nil))
+}
+
+func handleScheduleList(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	schedules.Lock()
+	var list []scheduledSound
+	for _, sc := range schedules.items {
+		if sc.GuildID == i.GuildID {
+			list = append(list, *sc)
+		}
+	}
+	schedules.Unlock()
+
+	if len(list) == 0 {
+		logRespondErr(i, respondEphemeral(s, i, "Nothing is scheduled in this server.", nil))
+		return
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].At.Before(list[b].At) })
+
+	var b strings.Builder
+	for _, sc := range list {
+		line := fmt.Sprintf("#%d <t:%d:R> %s", sc.ID, sc.At.Unix(), displayName(sc.Sound))
+		if sc.Repeat != "" {
+			line += " (" + sc.Repeat + ")"
+		}
+		if sc.ChannelID != "" {
+			line += " in <#" + sc.ChannelID + ">"
+		}
+		if b.Len()+len(line) > 1900 {
+			b.WriteString("…")
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	logRespondErr(i, respondEphemeral(s, i, b.String(), nil))
+}
+
+var manageGuildPermission int64 = discordgo.PermissionManageServer
+
+func scheduleCommand() *discordgo.ApplicationCommand {
+	return &discordgo.ApplicationCommand{
+		Name:                     "schedule",
+		Description:              "Play sounds at set times",
+		DefaultMemberPermissions: &manageGuildPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "add",
+				Description: "Schedule a sound",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionString, Name: "time", Description: "HH:MM, 2006-01-02 15:04, or a delay like 30m", Required: true},
+					{Type: discordgo.ApplicationCommandOptionString, Name: "sound", Description: "File path inside the sounds directory", Required: true},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "repeat",
+						Description: "Repeat the sound",
+						Choices: []*discordgo.ApplicationCommandOptionChoice{
+							{Name: "hourly", Value: "hourly"},
+							{Name: "daily", Value: "daily"},
+						},
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         "channel",
+						Description:  "Voice channel to join if the bot isn't already playing",
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice, discordgo.ChannelTypeGuildStageVoice},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "list",
+				Description: "Show this server's schedules",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "cancel",
+				Description: "Cancel a schedule",
+				Options: []*discordgo.ApplicationCommandOption{
+					{Type: discordgo.ApplicationCommandOptionInteger, Name: "id", Description: "Schedule number from /schedule list", Required: true},
+				},
+			},
+		},
+	}
+}
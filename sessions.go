@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sessionsPageSize keeps a page of /sessions well inside an embed's limits.
+const sessionsPageSize = 10
+
+func sessionsCommand() *discordgo.ApplicationCommand {
+	minPage := 1.0
+	return &discordgo.ApplicationCommand{
+		Name:                     "sessions",
+		Description:              "Owner only: list what the bot is playing in every server",
+		DefaultMemberPermissions: &adminPermissions,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "page",
+				Description: fmt.Sprintf("Page of %d servers (default 1)", sessionsPageSize),
+				MinValue:    &minPage,
+			},
+		},
+	}
+}
+
+// handleSessionsCommand lists the playback sessions of this process, one
+// embed field per guild. The state comes from nowPlaying, which reads each
+// session under its mutex.
+func handleSessionsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isOwner(i) {
+		log.Printf("[AUDIT] /sessions denied for user=%s guild=%s", interactionUserID(i), i.GuildID)
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+	page := 1
+	for _, opt := range i.ApplicationCommandData().Options {
+		if opt.Name == "page" {
+			page = int(opt.IntValue())
+		}
+	}
+
+	list := nowPlaying()
+	if len(list) == 0 {
+		logRespondErr(i, respondPrivate(s, i, "Nothing is playing in any server."))
+		return
+	}
+	pages := (len(list) + sessionsPageSize - 1) / sessionsPageSize
+	page = min(page, pages)
+	start := (page - 1) * sessionsPageSize
+	end := min(start+sessionsPageSize, len(list))
+
+	fields := make([]*discordgo.MessageEmbedField, 0, end-start)
+	for _, info := range list[start:end] {
+		name := info.GuildID
+		if g, err := s.State.Guild(info.GuildID); err == nil && g.Name != "" {
+			name = g.Name
+		}
+		position := formatPosition(time.Duration(info.ElapsedSeconds * float64(time.Second)))
+		if info.TotalSeconds != nil {
+			position += " / " + formatPosition(time.Duration(*info.TotalSeconds*float64(time.Second)))
+		}
+		lines := []string{
+			"Channel: <#" + info.ChannelID + ">",
+			"Playing: " + tailTruncate(info.Title, 200),
+			"Position: " + position,
+			fmt.Sprintf("Queue: %d", info.QueueLength),
+		}
+		if info.Paused {
+			lines[2] += " (paused)"
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  tailTruncate(name, 200) + " (" + info.GuildID + ")",
+			Value: strings.Join(lines, "\n"),
+		})
+	}
+	footer := fmt.Sprintf("Page %d of %d", page, pages)
+	if shardCount > 1 {
+		footer += fmt.Sprintf(" · shard %d of %d only", shardID, shardCount)
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+			Embeds: []*discordgo.MessageEmbed{{
+				Title:  fmt.Sprintf("Playing in %d server(s)", len(list)),
+				Fields: fields,
+				Footer: &discordgo.MessageEmbedFooter{Text: footer},
+			}},
+		},
+	}))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleSettingsCommand shows the effective configuration, as loaded from the
+// environment plus this server's overrides, so operators don't need the
+// startup log. Secrets are only reported as set or not.
+func handleSettingsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	opts := encodeOptions()
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	orNone := func(v string) string {
+		if v == "" {
+			return "none"
+		}
+		return v
+	}
+	lines := func(l ...string) string { return strings.Join(l, "\n") }
+
+	ffmpegLimit := "unlimited"
+	if ffmpegSlots != nil {
+		ffmpegLimit = fmt.Sprint(cap(ffmpegSlots))
+	}
+	control := "off"
+	if controlAddr != "" {
+		control = controlAddr
+		if controlToken != "" {
+			control += " (token set)"
+		} else {
+			control += " (no token)"
+		}
+	}
+	restartMode := "re-exec in place"
+	if !restartExec {
+		restartMode = fmt.Sprintf("exit with code %d", restartExitCode)
+	}
+	idle := "leave at once"
+	if idleTimeout > 0 {
+		idle = idleTimeout.String()
+	}
+	presence := orNone(idleStatus)
+	if presenceText != "" {
+		presence = fmt.Sprintf("%s %q", strings.ToLower(presenceTypeName()), presenceText)
+	}
+
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Library", Value: lines(
+			"Sounds dir: `"+soundsDir+"`",
+			"Data dir: `"+dataDir+"`",
+			"File types: "+allowedExts.String(),
+			"Commands: /"+soundsCmdName+", /"+stopCmdName,
+		)},
+		{Name: "Audio", Value: lines(
+			fmt.Sprintf("Bitrate: %d kbps, frames: %d ms, buffer: %d frames", opts.Bitrate, frameDuration, bufferedFrames),
+			fmt.Sprintf("Packet loss hint: %d%%", packetLoss),
+			fmt.Sprintf("Channels: %d", audioChannels),
+			fmt.Sprintf("Fade in/out: %d/%d ms", fadeInMS, fadeOutMS),
+			"Trim silence: "+onOff(trimSilence),
+			"Fade out on stop: "+onOff(fadeOutStop),
+			"Leave sound: "+orNone(leaveSound),
+			fmt.Sprintf("Loudness normalization: %s (target %.0f LUFS)", onOff(normalizeLoudness), loudnessTarget),
+			"Opus passthrough: "+onOff(opusPassthrough),
+			"Gapless: "+onOff(gapless),
+			"Legacy streamer: "+onOff(legacyStream),
+			"Join deafened: "+onOff(joinDeafened),
+		)},
+		{Name: "Limits", Value: lines(
+			fmt.Sprintf("Max queue: %d", maxQueue),
+			"Max ffmpeg processes: "+ffmpegLimit,
+			fmt.Sprintf("Join sound cooldown: %s", joinSoundCooldown),
+		)},
+		{Name: "Behaviour", Value: lines(
+			"Confirm /"+stopCmdName+": "+onOff(confirmStop),
+			"Cancel stops playback: "+onOff(cancelStopsPlayback),
+			"Skip channel picker: "+onOff(skipChannelPicker),
+			"Require same voice channel: "+onOff(requireSameVC),
+			"Reaction controls: "+onOff(reactionControls),
+			"Announce queue end: "+onOff(announceQueueEnd),
+			"Idle timeout: "+idle,
+			"Progress updates: "+onOff(progressUpdates),
+			"Resume on start: "+onOff(resumeOnStart),
+			"Private replies: "+onOff(ephemeralResponses),
+			"Idle presence: "+presence,
+		)},
+		{Name: "Operations", Value: lines(
+			"Owner: "+ownerMention(),
+			"Control API: "+control,
+			"Library change notices: "+channelMention(libraryNotifyChannel),
+			fmt.Sprintf("Shard: %d of %d", shardID, shardCount),
+			"Restart: "+restartMode,
+			"Graceful drain: "+drainSummary(),
+			fmt.Sprintf("ffmpeg crash retries: %d", ffmpegCrashRetries),
+			"Gateway intents: "+strings.Join(intentList(s.Identify.Intents), ", "),
+			"Bot token: set (hidden)",
+		)},
+		{Name: "This server", Value: lines(
+			"Announce channel: "+channelMention(announceChannels[i.GuildID]),
+			"Schedule channel: "+channelMention(scheduleChannels[i.GuildID]),
+			"File types: "+effectiveExts(i.GuildID).String(),
+			"DJ role: "+djSummary(i.GuildID),
+			fmt.Sprintf("Bitrate: %d kbps (for the boost level; lower in channels set below it)", guildBitrate(s, i.GuildID)),
+			"Equalizer: "+eqLabels[eqProfile(i.GuildID)],
+			"Night mode: "+nightModeLabel(i.GuildID),
+			"Shuffle on add: "+onOff(shuffleOnAdd(i.GuildID)),
+			"Disabled commands: "+orNone(strings.Join(guildDisabledCommands(i.GuildID), ", ")),
+		)},
+	}
+
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:  discordgo.MessageFlagsEphemeral,
+			Embeds: []*discordgo.MessageEmbed{{Title: "Effective settings", Fields: fields}},
+		},
+	}))
+}
+
+func presenceTypeName() string {
+	for name, t := range presenceTypes {
+		if t == presenceType {
+			return name
+		}
+	}
+	return "playing"
+}
+
+func ownerMention() string {
+	if ownerID == "" {
+		return "not set"
+	}
+	return "<@" + ownerID + ">"
+}
+
+func channelMention(id string) string {
+	if id == "" {
+		return "none"
+	}
+	return "<#" + id + ">"
+}
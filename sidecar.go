@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+)
+
+// trackMeta holds optional per-file playback settings read from a JSON sidecar
+// next to the audio file, e.g. sounds/airhorn.mp3.json:
+//
+//	{"volume": 0.4}
+type trackMeta struct {
+	Volume      *float64 `json:"volume,omitempty"`       // 1 = as encoded, 0.5 = half, up to 2
+	TrimSilence *bool    `json:"trim_silence,omitempty"` // overrides TRIM_SILENCE for this file
+	SkipIntro   int      `json:"skip_intro,omitempty"`   // seconds to start into the file by default
+}
+
+func sidecarPath(filePath string) string {
+	return filePath + ".json"
+}
+
+// loadTrackMeta reads the sidecar for filePath. A missing sidecar is normal and
+// yields zero-value meta; a malformed one is logged and ignored.
+func loadTrackMeta(filePath string) trackMeta {
+	var meta trackMeta
+	if isURL(filePath) {
+		return meta
+	}
+	data, err := os.ReadFile(sidecarPath(filePath))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[sidecar] cannot read %s: %v", sidecarPath(filePath), err)
+		}
+		return meta
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		log.Printf("[sidecar] ignoring malformed %s: %v", sidecarPath(filePath), err)
+		return trackMeta{}
+	}
+	if meta.Volume != nil && (*meta.Volume < 0 || *meta.Volume > 2) {
+		log.Printf("[sidecar] %s: volume %.2f out of range 0-2, ignoring", sidecarPath(filePath), *meta.Volume)
+		meta.Volume = nil
+	}
+	if meta.SkipIntro < 0 {
+		log.Printf("[sidecar] %s: negative skip_intro %d, ignoring", sidecarPath(filePath), meta.SkipIntro)
+		meta.SkipIntro = 0
+	}
+	return meta
+}
+
+// introStart is where filePath starts when no position is asked for: its
+// sidecar's skip_intro, or the beginning.
+func introStart(filePath string) int {
+	if isReaderTrack(filePath) {
+		return 0
+	}
+	return loadTrackMeta(filePath).SkipIntro
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleStopAllCommand stops playback in every guild this process serves,
+// for when the bot is being abused in many servers at once. Owner only.
+func handleStopAllCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !isOwner(i) {
+		log.Printf("[AUDIT] /stopall denied for user=%s guild=%s", interactionUserID(i), i.GuildID)
+		logRespondErr(i, respondPrivate(s, i, "This command is restricted to the bot owner."))
+		return
+	}
+
+	stopped, cleared := 0, 0
+	playSessions.Range(func(key, value any) bool {
+		gp := value.(*guildPlayback)
+		gp.mu.Lock()
+		cleared += len(gp.queue)
+		gp.queue = nil
+		gp.mu.Unlock()
+		gp.stop()
+		playSessions.CompareAndDelete(key, gp)
+		stopped++
+		return true
+	})
+	log.Printf("[AUDIT] /stopall by user=%s: stopped %d session(s), cleared %d queued track(s)", interactionUserID(i), stopped, cleared)
+	updatePresence(s)
+
+	msg := fmt.Sprintf("Stopped playback in %d server(s) and cleared %d queued track(s).", stopped, cleared)
+	if shardCount > 1 {
+		msg += fmt.Sprintf(" This only covers shard %d; run it in a server on each other shard too.", shardID)
+	}
+	logRespondErr(i, respondPrivate(s, i, msg))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shardFileName is name with the shard in it when sharded, e.g.
+// "eq-shard1.json", so processes sharing DATA_DIR don't overwrite each other's
+// guilds. A guild always lands on the same shard while SHARD_COUNT stays put.
+func shardFileName(name string) string {
+	if shardCount <= 1 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-shard%d%s", strings.TrimSuffix(name, ext), shardID, ext)
+}
+
+// loadJSON reads DATA_DIR/name into v. A missing file leaves v untouched.
+func loadJSON(name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(dataDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON writes v to DATA_DIR/name via a temp file and rename, so a crash
+// mid-write never leaves a truncated file behind.
+func saveJSON(name string, v any) error {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataDir, name)
+	tmp, err := os.CreateTemp(dataDir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/dca"
+)
+
+// Totals across all guilds since startup, served by GET /metrics.
+var (
+	audioUnderruns    atomic.Int64
+	voiceSendStalls   atomic.Int64 // frames OpusSend was slow to accept
+	voiceSendFailures atomic.Int64 // streams ended by a stalled or dead voice connection
+	voiceReconnects   atomic.Int64
+)
+
+const (
+	// sendStallLimit consecutive slow frames (about a second of choppy audio)
+	// mean the connection is unhealthy, so the stream ends and reconnects.
+	sendStallLimit = 50
+
+	// voiceReconnectAttempts is how often one track may reconnect and resume.
+	voiceReconnectAttempts = 2
+
+	// sendTimeout is how long one frame may wait for OpusSend before the
+	// connection counts as dead rather than slow.
+	sendTimeout = time.Second
+
+	// voiceDisconnectTimeout bounds disconnectVoice.
+	voiceDisconnectTimeout = 5 * time.Second
+)
+
+// underrunMonitor wraps a frame source and counts frames that weren't ready
+// when the streamer asked for them. The encoder buffers ahead of playback, so
+// waiting longer than a frame's duration means ffmpeg isn't keeping up and
+// listeners hear a gap.
+type underrunMonitor struct {
+	dca.OpusReader
+	guildID string
+
+	frames    int
+	underruns int
+	worst     time.Duration
+}
+
+func (m *underrunMonitor) OpusFrame() ([]byte, error) {
+	start := time.Now()
+	frame, err := m.OpusReader.OpusFrame()
+	wait := time.Since(start)
+	if err != nil {
+		return frame, err
+	}
+
+	m.frames++
+	if m.frames > 1 && wait > m.FrameDuration() {
+		m.underruns++
+		total := audioUnderruns.Add(1)
+		if wait > m.worst {
+			m.worst = wait
+		}
+		// Log the first few per track; the summary covers the rest.
+		if m.underruns <= 5 {
+			log.Printf("[underrun] guild=%s frame %d waited %s (frame=%s, total underruns=%d)",
+				m.guildID, m.frames, wait.Round(time.Millisecond), m.FrameDuration(), total)
+		}
+	}
+	return frame, nil
+}
+
+// summary logs the per-track underrun count once the stream is done.
+func (m *underrunMonitor) summary(track string) {
+	if m.underruns == 0 {
+		return
+	}
+	log.Printf("[underrun] guild=%s track=%s: %d underrun(s) in %d frames, worst wait %s",
+		m.guildID, track, m.underruns, m.frames, m.worst.Round(time.Millisecond))
+}
+
+type streamCmd int
+
+const (
+	streamPause streamCmd = iota
+	streamResume
+	streamStop
+	streamSkip
+)
+
+var (
+	errStreamStopped = errors.New("stream stopped")
+	errStreamSkipped = errors.New("stream skipped")
+	errSendTimeout   = errors.New("timed out sending to voice connection")
+	errVoiceStalled  = errors.New("voice connection kept stalling")
+)
+
+// isVoiceSendErr reports whether a stream ended because the voice connection
+// stopped taking frames, which a reconnect may fix.
+func isVoiceSendErr(err error) bool {
+	return errors.Is(err, errSendTimeout) || errors.Is(err, errVoiceStalled)
+}
+
+// streamer pumps Opus frames from an encoder to a voice connection. Unlike
+// dca.NewStream it checks a control channel before every frame, so pause,
+// stop and skip take effect within one frame, and it counts frames sent for an
+// accurate playback position. While paused it sends silence frames instead.
+type streamer struct {
+	src   dca.OpusReader // only touched by run, which swaps it
+	frame time.Duration  // src's frame duration; a swapped-in source has the same
+	vc    *discordgo.VoiceConnection
+	ctrl  chan streamCmd      // stop or skip; see control
+	swap  chan dca.OpusReader // replaces src from the next frame (see fadeStop)
+	done  chan struct{}       // closed when run returns
+
+	framesSent atomic.Int64 // audio frames only, not silence
+	paused     atomic.Bool
+	stalls     int          // consecutive slow sends; only touched by run
+	sendWait   atomic.Int64 // moving average of how long a frame waits for OpusSend, in ns
+}
+
+func newStreamer(src dca.OpusReader, vc *discordgo.VoiceConnection) *streamer {
+	return &streamer{
+		src:   src,
+		frame: src.FrameDuration(),
+		vc:    vc,
+		ctrl:  make(chan streamCmd, 1),
+		swap:  make(chan dca.OpusReader, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// run streams until the source is exhausted (io.EOF), a stop/skip command
+// arrives (errStreamStopped/errStreamSkipped) or sending fails.
+func (st *streamer) run() error {
+	defer close(st.done)
+
+	for {
+		select {
+		case cmd := <-st.ctrl:
+			if err := st.handle(cmd); err != nil {
+				return err
+			}
+		case src := <-st.swap:
+			st.src = src
+		default:
+		}
+
+		audio := !st.paused.Load()
+		frame := silenceFrame
+		if audio {
+			f, err := st.src.OpusFrame()
+			if err != nil {
+				return err
+			}
+			frame = f
+		}
+
+		if err := st.send(frame); err != nil {
+			if isVoiceSendErr(err) {
+				voiceSendFailures.Add(1)
+			}
+			return err
+		}
+		if audio {
+			st.framesSent.Add(1)
+		}
+	}
+}
+
+// send blocks until discordgo's sender takes the frame (it paces OpusSend at
+// one frame per 20ms), while still reacting to control commands.
+func (st *streamer) send(frame []byte) error {
+	start := time.Now()
+	timeout := time.NewTimer(sendTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case st.vc.OpusSend <- frame:
+			wait := time.Since(start)
+			st.sendWait.Store((st.sendWait.Load()*7 + int64(wait)) / 8)
+			// The sender should take a frame every 20ms; well beyond that the
+			// UDP side is backed up and listeners hear chop.
+			if wait > 3*st.frame {
+				st.stalls++
+				total := voiceSendStalls.Add(1)
+				if st.stalls == 1 || st.stalls%10 == 0 {
+					log.Printf("[stream] slow voice send: %s for one frame (%d in a row, total %d)",
+						wait.Round(time.Millisecond), st.stalls, total)
+				}
+				if st.stalls >= sendStallLimit {
+					return errVoiceStalled
+				}
+			} else {
+				st.stalls = 0
+			}
+			return nil
+		case cmd := <-st.ctrl:
+			// Keep the frame in hand; a pause takes effect from the next one.
+			if err := st.handle(cmd); err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return errSendTimeout
+		}
+	}
+}
+
+func (st *streamer) handle(cmd streamCmd) error {
+	switch cmd {
+	case streamPause:
+		st.paused.Store(true)
+	case streamResume:
+		st.paused.Store(false)
+	case streamStop:
+		return errStreamStopped
+	case streamSkip:
+		return errStreamSkipped
+	}
+	return nil
+}
+
+// control delivers cmd to the running stream without blocking, so callers
+// may hold gp.mu even while run waits on a slow source. Pause and resume just
+// set paused, which run reads before every frame. Stop and skip both end the
+// stream, so while one is pending another adds nothing and is dropped. It's a
+// no-op once the stream has finished.
+func (st *streamer) control(cmd streamCmd) {
+	switch cmd {
+	case streamPause:
+		st.paused.Store(true)
+		return
+	case streamResume:
+		st.paused.Store(false)
+		return
+	}
+	select {
+	case st.ctrl <- cmd:
+	default:
+	}
+}
+
+// reconnect replaces a voice connection that stopped taking frames with a
+// fresh one to the same channel, so the current track can carry on.
+func (gp *guildPlayback) reconnect(s *discordgo.Session, channelID string) (*discordgo.VoiceConnection, error) {
+	gp.mu.Lock()
+	old := gp.vc
+	gp.mu.Unlock()
+	if old != nil {
+		disconnectVoice(old)
+	}
+
+	vc, err := joinVoice(s, gp.guildID, channelID)
+	if err != nil {
+		return nil, err
+	}
+	_ = vc.Speaking(true)
+
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+	if gp.stopped {
+		disconnectVoice(vc)
+		return nil, errStreamStopped
+	}
+	gp.vc = vc
+	voiceReconnects.Add(1)
+	return vc, nil
+}
+
+// disconnectVoice leaves voice without letting a wedged connection hang the
+// caller. discordgo's Speaking and Disconnect write to the voice and main
+// websockets under their locks, and those are what's stuck after a send
+// timeout; a /stop must still answer and clean up. A disconnect that takes
+// longer is left to finish in the background.
+func disconnectVoice(vc *discordgo.VoiceConnection) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = vc.Speaking(false)
+		_ = vc.Disconnect()
+	}()
+	select {
+	case <-done:
+	case <-time.After(voiceDisconnectTimeout):
+		log.Printf("[stream] guild=%s: voice disconnect still blocked after %s; not waiting for it", vc.GuildID, voiceDisconnectTimeout)
+	}
+}
+
+// position is how much audio has been sent so far.
+func (st *streamer) position() time.Duration {
+	return time.Duration(st.framesSent.Load()) * st.frame
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeOpus yields n 20 ms frames, then io.EOF.
+type fakeOpus struct{ n int }
+
+func (f *fakeOpus) OpusFrame() ([]byte, error) {
+	if f.n == 0 {
+		return nil, io.EOF
+	}
+	f.n--
+	return []byte{31 << 3}, nil
+}
+
+func (f *fakeOpus) FrameDuration() time.Duration { return 20 * time.Millisecond }
+
+// position is read by the progress bar, presence and the control API while
+// run swaps in the fade-out source, and keeps counting across the swap.
+func TestStreamerPositionDuringSwap(t *testing.T) {
+	vc := &discordgo.VoiceConnection{OpusSend: make(chan []byte)}
+	st := newStreamer(&fakeOpus{n: 50}, vc)
+
+	errc := make(chan error, 1)
+	go func() { errc <- st.run() }()
+	for range 10 {
+		<-vc.OpusSend
+	}
+	st.swap <- &fakeOpus{n: 200}
+	go func() {
+		for range vc.OpusSend {
+		}
+	}()
+
+	for {
+		select {
+		case err := <-errc:
+			if err != io.EOF {
+				t.Fatalf("run: %v", err)
+			}
+			// The swap lands within a frame or two of being sent.
+			if got := st.position(); got < 210*20*time.Millisecond || got > 212*20*time.Millisecond {
+				t.Errorf("position %s, want 210-212 frames of 20ms", got)
+			}
+			close(vc.OpusSend)
+			return
+		default:
+			_ = st.position()
+		}
+	}
+}
+
+// Commands are sent with gp.mu held, so they must not wait for run, which
+// may be stuck on a slow source or not running yet.
+func TestStreamerControlNeverBlocks(t *testing.T) {
+	st := newStreamer(&fakeOpus{}, &discordgo.VoiceConnection{})
+	sent := make(chan struct{})
+	go func() {
+		for _, cmd := range []streamCmd{streamPause, streamSkip, streamResume, streamStop, streamPause} {
+			st.control(cmd)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("control blocked with nothing reading commands")
+	}
+	if !st.paused.Load() {
+		t.Error("the last pause didn't take")
+	}
+	if cmd := <-st.ctrl; cmd != streamSkip {
+		t.Errorf("pending command %v, want the first one, skip", cmd)
+	}
+}
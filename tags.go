@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	tagsFile = "tags.json"
+
+	// tagWorkers is how many ffprobe processes indexing runs at once, at most;
+	// see tagProbeWorkers.
+	tagWorkers = 4
+)
+
+// tagFields are the metadata fields /find searches, in option order.
+var tagFields = []string{"artist", "album", "title"}
+
+// tagEntry is a file's embedded metadata, valid while its size and mod time
+// are unchanged. Tags holds the tagFields that are set, lowercased keys.
+type tagEntry struct {
+	ModTime time.Time         `json:"mod_time"`
+	Size    int64             `json:"size"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// tagIndex caches tags per full file path. It's filled lazily by the first
+// /find and kept in DATA_DIR, so later searches only probe new files.
+var tagIndex = struct {
+	sync.Mutex
+	entries map[string]tagEntry
+	loaded  bool
+}{entries: make(map[string]tagEntry)}
+
+// tagProbeWorkers is how many files indexing probes at once: tagWorkers, but
+// always leaving one MAX_FFMPEG_PROCS slot free so playback isn't kept
+// waiting behind a large index.
+func tagProbeWorkers() int {
+	if ffmpegSlots == nil {
+		return tagWorkers
+	}
+	return max(1, min(tagWorkers, cap(ffmpegSlots)-1))
+}
+
+// libraryTags returns the tags of each file in files (relative to soundsDir),
+// probing any that aren't indexed yet or changed since.
+func libraryTags(files []string, index map[string]audioFile) map[string]map[string]string {
+	tagIndex.Lock()
+	if !tagIndex.loaded {
+		if err := loadJSON(shardFileName(tagsFile), &tagIndex.entries); err != nil {
+			log.Printf("[tags] couldn't load the tag index: %v", err)
+		}
+		if tagIndex.entries == nil {
+			tagIndex.entries = make(map[string]tagEntry)
+		}
+		tagIndex.loaded = true
+	}
+	result := make(map[string]map[string]string, len(files))
+	var stale []string
+	for _, rel := range files {
+		if isPlaylist(rel) {
+			continue
+		}
+		e, ok := tagIndex.entries[filepath.Join(soundsDir, rel)]
+		if ok && e.Size == index[rel].Size && e.ModTime.Equal(index[rel].ModTime) {
+			result[rel] = e.Tags
+		} else {
+			stale = append(stale, rel)
+		}
+	}
+	tagIndex.Unlock()
+	if len(stale) == 0 {
+		return result
+	}
+
+	start := time.Now()
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	var skipped atomic.Int32
+	for range min(tagProbeWorkers(), len(stale)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				filePath := filepath.Join(soundsDir, rel)
+				tags, err := probeTags(filePath)
+				if err != nil {
+					// Not indexed, so the next search tries it again.
+					skipped.Add(1)
+					continue
+				}
+				tagIndex.Lock()
+				tagIndex.entries[filePath] = tagEntry{ModTime: index[rel].ModTime, Size: index[rel].Size, Tags: tags}
+				result[rel] = tags
+				tagIndex.Unlock()
+			}
+		}()
+	}
+	for _, rel := range stale {
+		jobs <- rel
+	}
+	close(jobs)
+	wg.Wait()
+	log.Printf("[tags] indexed %d file(s) in %s", len(stale)-int(skipped.Load()), time.Since(start).Round(time.Millisecond))
+	if n := skipped.Load(); n > 0 {
+		log.Printf("[tags] skipped %d file(s): every ffmpeg slot was busy", n)
+	}
+
+	tagIndex.Lock()
+	defer tagIndex.Unlock()
+	if err := saveJSON(shardFileName(tagsFile), tagIndex.entries); err != nil {
+		log.Printf("[tags] couldn't save the tag index: %v", err)
+	}
+	return result
+}
+
+// probeTags reads a file's artist, album and title with ffprobe. Container
+// tags win over stream tags, which is where Ogg files keep theirs. A file
+// ffprobe can't read just has no tags; the error is errFFmpegBusy when no
+// process slot freed up in time, and the file wasn't probed.
+func probeTags(filePath string) (map[string]string, error) {
+	if err := acquireFFmpeg(ffmpegSlotWait); err != nil {
+		return nil, err
+	}
+	defer releaseFFmpeg()
+	out, err := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format_tags:stream_tags",
+		"-select_streams", "a:0",
+		"-of", "json",
+		filePath,
+	).Output()
+	if err != nil {
+		return nil, nil
+	}
+	var probe struct {
+		Format  struct{ Tags map[string]string }   `json:"format"`
+		Streams []struct{ Tags map[string]string } `json:"streams"`
+	}
+	if json.Unmarshal(out, &probe) != nil {
+		return nil, nil
+	}
+	sources := []map[string]string{probe.Format.Tags}
+	for _, st := range probe.Streams {
+		sources = append(sources, st.Tags)
+	}
+	tags := map[string]string{}
+	for _, src := range sources {
+		for k, v := range src {
+			k, v = strings.ToLower(k), strings.TrimSpace(v)
+			if v != "" && tags[k] == "" && slices.Contains(tagFields, k) {
+				tags[k] = v
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
+
+// matchTags reports whether a file matches every field in want, each as a
+// case-insensitive substring. A file without a tag for a field is matched on
+// its path instead, so libraries named by filename alone still work.
+func matchTags(rel string, tags map[string]string, want map[string]string) bool {
+	for field, needle := range want {
+		have, ok := tags[field]
+		if !ok {
+			have = rel
+		}
+		if !strings.Contains(strings.ToLower(have), needle) {
+			return false
+		}
+	}
+	return true
+}
+
+func findCommand() *discordgo.ApplicationCommand {
+	var options []*discordgo.ApplicationCommandOption
+	for _, field := range tagFields {
+		options = append(options, &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        field,
+			Description: fmt.Sprintf("Part of the %s; files without the tag are matched by name", field),
+		})
+	}
+	return &discordgo.ApplicationCommand{
+		Name:        "find",
+		Description: "Browse sounds by artist, album or title tags",
+		Options:     options,
+	}
+}
+
+// handleFindCommand opens the sound picker on the files whose tags match the
+// given fields. The first search probes the whole library, which can take a
+// while, so it answers with a deferred response.
+func handleFindCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	want := map[string]string{}
+	var labels []string
+	for _, opt := range i.ApplicationCommandData().Options {
+		if v := strings.TrimSpace(opt.StringValue()); v != "" {
+			want[opt.Name] = strings.ToLower(v)
+			labels = append(labels, fmt.Sprintf("%s: %q", opt.Name, v))
+		}
+	}
+	if len(want) == 0 {
+		logRespondErr(i, respondPrivate(s, i, "Give at least one of artist, album or title."))
+		return
+	}
+
+	var flags discordgo.MessageFlags
+	if ephemeralResponses {
+		flags = discordgo.MessageFlagsEphemeral
+	}
+	logRespondErr(i, interactionRespond(s, i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: flags},
+	}))
+
+	reply := func(content string, components []discordgo.MessageComponent) {
+		if components == nil {
+			components = []discordgo.MessageComponent{}
+		}
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content, Components: &components}); err != nil {
+			log.Printf("[find] failed to edit response: %v", err)
+		}
+	}
+
+	go func() {
+		files, index, err := scanLibrary(soundsDir, effectiveExts(i.GuildID))
+		if err != nil {
+			reply(scanErrorMessage(err), nil)
+			return
+		}
+		tags := libraryTags(files, index)
+		var matched []string
+		for _, rel := range files {
+			if !isPlaylist(rel) && matchTags(rel, tags[rel], want) {
+				matched = append(matched, rel)
+			}
+		}
+		if len(matched) == 0 {
+			reply("No sounds match "+strings.Join(labels, ", ")+".", nil)
+			return
+		}
+		state := newBrowser(i, &browserState{
+			AllFiles:    matched,
+			LibrarySize: len(files),
+			Index:       index,
+			Tags:        strings.Join(labels, ", "),
+		}, "name")
+		reply(pickerContent(state), buildSoundPickerComponents(state))
+	}()
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestTagProbeWorkers(t *testing.T) {
+	old := ffmpegSlots
+	t.Cleanup(func() { ffmpegSlots = old })
+	for _, tc := range []struct {
+		slots int // MAX_FFMPEG_PROCS; 0 = no cap
+		want  int
+	}{
+		{0, tagWorkers},
+		{1, 1},
+		{2, 1},
+		{4, 3},
+		{tagWorkers + 1, tagWorkers},
+		{16, tagWorkers},
+	} {
+		ffmpegSlots = nil
+		if tc.slots > 0 {
+			ffmpegSlots = make(chan struct{}, tc.slots)
+		}
+		if got := tagProbeWorkers(); got != tc.want {
+			t.Errorf("%d slots: got %d workers, want %d", tc.slots, got, tc.want)
+		}
+	}
+}